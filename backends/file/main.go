@@ -4,20 +4,31 @@ import (
 	"encoding/json"
 	"errors"
 	"fmt"
+	"net/url"
 	"os"
 	"reflect"
+	"strconv"
+	"strings"
 
 	"github.com/ps78674/gorestldap/internal/data"
 	"gopkg.in/yaml.v3"
 )
 
 type config struct {
-	UsersPath  string `yaml:"users_path"`
-	GroupsPath string `yaml:"groups_path"`
+	UsersPath         string `yaml:"users_path"`
+	GroupsPath        string `yaml:"groups_path"`
+	SudoRolesPath     string `yaml:"sudo_roles_path"`
+	NetgroupsPath     string `yaml:"netgroups_path"`
+	HostsPath         string `yaml:"hosts_path"`
+	AutomountMapsPath string `yaml:"automount_maps_path"`
 }
 
 type backend struct {
 	config *config
+	// users and groups of last returned changes and version of their data
+	token  string
+	users  []data.User
+	groups []data.Group
 }
 
 var Backend backend
@@ -42,6 +53,105 @@ func (b *backend) GetData() ([]data.User, []data.Group, error) {
 	return users, groups, nil
 }
 
+func (b *backend) GetSudoRoles() ([]data.SudoRole, error) {
+	if len(b.config.SudoRolesPath) == 0 {
+		return nil, nil
+	}
+
+	sudoRoles := []data.SudoRole{}
+	if err := getData(b.config.SudoRolesPath, &sudoRoles); err != nil {
+		return nil, fmt.Errorf("error getting sudo roles data: %s", err)
+	}
+
+	return sudoRoles, nil
+}
+
+func (b *backend) GetNetgroups() ([]data.Netgroup, error) {
+	if len(b.config.NetgroupsPath) == 0 {
+		return nil, nil
+	}
+
+	netgroups := []data.Netgroup{}
+	if err := getData(b.config.NetgroupsPath, &netgroups); err != nil {
+		return nil, fmt.Errorf("error getting netgroups data: %s", err)
+	}
+
+	return netgroups, nil
+}
+
+func (b *backend) GetHosts() ([]data.Host, error) {
+	if len(b.config.HostsPath) == 0 {
+		return nil, nil
+	}
+
+	hosts := []data.Host{}
+	if err := getData(b.config.HostsPath, &hosts); err != nil {
+		return nil, fmt.Errorf("error getting hosts data: %s", err)
+	}
+
+	return hosts, nil
+}
+
+func (b *backend) GetAutomountMaps() ([]data.AutomountMap, error) {
+	if len(b.config.AutomountMapsPath) == 0 {
+		return nil, nil
+	}
+
+	maps := []data.AutomountMap{}
+	if err := getData(b.config.AutomountMapsPath, &maps); err != nil {
+		return nil, fmt.Errorf("error getting automount maps data: %s", err)
+	}
+
+	return maps, nil
+}
+
+// GetChangesSince returns users and groups changed since 'token', token holds modification times of users and groups files
+// changes are computed against data returned with token, all data is returned for other tokens
+func (b *backend) GetChangesSince(token string) (*data.Changes, error) {
+	usersModTime, err := getModTime(b.config.UsersPath)
+	if err != nil {
+		return nil, fmt.Errorf("error getting users data: %s", err)
+	}
+	groupsModTime, err := getModTime(b.config.GroupsPath)
+	if err != nil {
+		return nil, fmt.Errorf("error getting groups data: %s", err)
+	}
+
+	changes := &data.Changes{
+		Token: url.Values{"users": {usersModTime}, "groups": {groupsModTime}}.Encode(),
+	}
+
+	prev, err := url.ParseQuery(token)
+	if err != nil || len(token) == 0 || token != b.token {
+		users, groups, err := b.GetData()
+		if err != nil {
+			return nil, err
+		}
+		changes.Users, changes.Groups, changes.Full = users, groups, true
+		b.token, b.users, b.groups = changes.Token, users, groups
+		return changes, nil
+	}
+
+	users, groups := b.users, b.groups
+	if prev.Get("users") != usersModTime {
+		users = []data.User{}
+		if err := getData(b.config.UsersPath, &users); err != nil {
+			return nil, fmt.Errorf("error getting users data: %s", err)
+		}
+		changes.Users, changes.DeletedUsers = getUsersChanges(b.users, users)
+	}
+	if prev.Get("groups") != groupsModTime {
+		groups = []data.Group{}
+		if err := getData(b.config.GroupsPath, &groups); err != nil {
+			return nil, fmt.Errorf("error getting groups data: %s", err)
+		}
+		changes.Groups, changes.DeletedGroups = getGroupsChanges(b.groups, groups)
+	}
+
+	b.token, b.users, b.groups = changes.Token, users, groups
+	return changes, nil
+}
+
 func (b *backend) UpdateData(old, new interface{}) error {
 	switch entry := new.(type) {
 	case data.User:
@@ -95,6 +205,67 @@ func (b *backend) UpdateData(old, new interface{}) error {
 	return nil
 }
 
+// getUsersChanges returns users of 'new' which are not in 'old' or differ from them and cns of users of 'old' missing in 'new'
+func getUsersChanges(old, new []data.User) ([]data.User, []string) {
+	oldByCN := make(map[string]data.User, len(old))
+	for _, user := range old {
+		oldByCN[strings.ToLower(user.CN)] = user
+	}
+
+	var changed []data.User
+	for _, user := range new {
+		key := strings.ToLower(user.CN)
+		if oldUser, ok := oldByCN[key]; !ok || !reflect.DeepEqual(oldUser, user) {
+			changed = append(changed, user)
+		}
+		delete(oldByCN, key)
+	}
+
+	var deleted []string
+	for _, user := range old {
+		if _, ok := oldByCN[strings.ToLower(user.CN)]; ok {
+			deleted = append(deleted, user.CN)
+		}
+	}
+
+	return changed, deleted
+}
+
+// getGroupsChanges returns groups of 'new' which are not in 'old' or differ from them and cns of groups of 'old' missing in 'new'
+func getGroupsChanges(old, new []data.Group) ([]data.Group, []string) {
+	oldByCN := make(map[string]data.Group, len(old))
+	for _, group := range old {
+		oldByCN[strings.ToLower(group.CN)] = group
+	}
+
+	var changed []data.Group
+	for _, group := range new {
+		key := strings.ToLower(group.CN)
+		if oldGroup, ok := oldByCN[key]; !ok || !reflect.DeepEqual(oldGroup, group) {
+			changed = append(changed, group)
+		}
+		delete(oldByCN, key)
+	}
+
+	var deleted []string
+	for _, group := range old {
+		if _, ok := oldByCN[strings.ToLower(group.CN)]; ok {
+			deleted = append(deleted, group.CN)
+		}
+	}
+
+	return changed, deleted
+}
+
+// getModTime returns modification time of file 'path' as unix nanoseconds
+func getModTime(path string) (string, error) {
+	fi, err := os.Stat(path)
+	if err != nil {
+		return "", fmt.Errorf("error getting file info: %s", err)
+	}
+	return strconv.FormatInt(fi.ModTime().UnixNano(), 10), nil
+}
+
 func getData(path string, data interface{}) error {
 	contents, err := os.ReadFile(path)
 	if err != nil {
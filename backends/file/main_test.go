@@ -0,0 +1,125 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/ps78674/gorestldap/internal/data"
+)
+
+func TestGetUsersChanges(t *testing.T) {
+	old := []data.User{
+		{CN: "user01", UID: "user01"},
+		{CN: "user02", UID: "user02"},
+		{CN: "user03", UID: "user03"},
+	}
+	new := []data.User{
+		// unchanged
+		{CN: "user01", UID: "user01"},
+		// cn differs in case only -> same user
+		{CN: "USER02", UID: "user02"},
+		{CN: "user04", UID: "user04"},
+	}
+
+	changed, deleted := getUsersChanges(old, new)
+	if want := []data.User{new[1], new[2]}; !reflect.DeepEqual(changed, want) {
+		t.Errorf("changed = %+v, want %+v", changed, want)
+	}
+	if want := []string{"user03"}; !reflect.DeepEqual(deleted, want) {
+		t.Errorf("deleted = %v, want %v", deleted, want)
+	}
+
+	changed, deleted = getUsersChanges(old, old)
+	if len(changed) != 0 || len(deleted) != 0 {
+		t.Errorf("got changes of equal users: %+v, %v", changed, deleted)
+	}
+}
+
+func TestGetGroupsChanges(t *testing.T) {
+	old := []data.Group{
+		{CN: "group01", GIDNumber: 1000, MemberUID: []string{"user01"}},
+		{CN: "group02", GIDNumber: 1001},
+	}
+	new := []data.Group{
+		{CN: "group01", GIDNumber: 1000, MemberUID: []string{"user01", "user02"}},
+		{CN: "group03", GIDNumber: 1002},
+	}
+
+	changed, deleted := getGroupsChanges(old, new)
+	if !reflect.DeepEqual(changed, new) {
+		t.Errorf("changed = %+v, want %+v", changed, new)
+	}
+	if want := []string{"group02"}; !reflect.DeepEqual(deleted, want) {
+		t.Errorf("deleted = %v, want %v", deleted, want)
+	}
+
+	changed, deleted = getGroupsChanges(nil, new)
+	if !reflect.DeepEqual(changed, new) || len(deleted) != 0 {
+		t.Errorf("changes of new groups = %+v, %v", changed, deleted)
+	}
+}
+
+func TestGetChangesSince(t *testing.T) {
+	dir := t.TempDir()
+	b := backend{config: &config{
+		UsersPath:  filepath.Join(dir, "users.json"),
+		GroupsPath: filepath.Join(dir, "groups.json"),
+	}}
+	writeFile := func(name, contents string, modTime time.Time) {
+		path := filepath.Join(dir, name)
+		if err := os.WriteFile(path, []byte(contents), 0600); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.Chtimes(path, modTime, modTime); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	start := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+	writeFile("users.json", `[{"cn":"user01"},{"cn":"user02"}]`, start)
+	writeFile("groups.json", `[{"cn":"group01"}]`, start)
+
+	// unknown token -> all data
+	changes, err := b.GetChangesSince("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !changes.Full || len(changes.Users) != 2 || len(changes.Groups) != 1 {
+		t.Fatalf("first changes = %+v, want all data", changes)
+	}
+
+	// users file is changed
+	writeFile("users.json", `[{"cn":"user01","mail":"user01@example.com"},{"cn":"user03"}]`, start.Add(time.Second))
+	changes, err = b.GetChangesSince(changes.Token)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if changes.Full || len(changes.Users) != 2 || len(changes.Groups) != 0 {
+		t.Errorf("changes = %+v, want 2 changed users", changes)
+	}
+	if want := []string{"user02"}; !reflect.DeepEqual(changes.DeletedUsers, want) {
+		t.Errorf("deleted users = %v, want %v", changes.DeletedUsers, want)
+	}
+
+	// nothing is changed
+	token := changes.Token
+	changes, err = b.GetChangesSince(token)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if changes.Full || len(changes.Users) != 0 || len(changes.DeletedUsers) != 0 || changes.Token != token {
+		t.Errorf("changes = %+v, want no changes", changes)
+	}
+
+	// token of other backend state -> all data
+	changes, err = b.GetChangesSince("users=1&groups=1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !changes.Full || len(changes.Users) != 2 {
+		t.Errorf("changes = %+v, want all data", changes)
+	}
+}
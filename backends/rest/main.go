@@ -1,10 +1,13 @@
 package main
 
 import (
+	"bytes"
 	"encoding/json"
 	"errors"
 	"fmt"
+	"net/url"
 	"reflect"
+	"strings"
 	"time"
 
 	"github.com/ps78674/gorestldap/internal/data"
@@ -13,11 +16,32 @@ import (
 )
 
 type config struct {
-	URL            string        `yaml:"url"`
-	UsersPath      string        `yaml:"users_path"`
-	GroupsPath     string        `yaml:"groups_path"`
-	AuthToken      string        `yaml:"auth_token"`
-	HTTPReqTimeout time.Duration `yaml:"http_request_timeout"`
+	URL               string        `yaml:"url"`
+	UsersPath         string        `yaml:"users_path"`
+	GroupsPath        string        `yaml:"groups_path"`
+	SudoRolesPath     string        `yaml:"sudo_roles_path"`
+	NetgroupsPath     string        `yaml:"netgroups_path"`
+	HostsPath         string        `yaml:"hosts_path"`
+	AutomountMapsPath string        `yaml:"automount_maps_path"`
+	AuthToken         string        `yaml:"auth_token"`
+	HTTPReqTimeout    time.Duration `yaml:"http_request_timeout"`
+	DeltaUpdates      bool          `yaml:"delta_updates"`
+}
+
+// usersChanges is a response of users path with 'since' query parameter
+type usersChanges struct {
+	Changed []data.User `json:"changed"`
+	Deleted []string    `json:"deleted"`
+	Full    bool        `json:"full"`
+	Token   string      `json:"token"`
+}
+
+// groupsChanges is a response of groups path with 'since' query parameter
+type groupsChanges struct {
+	Changed []data.Group `json:"changed"`
+	Deleted []string     `json:"deleted"`
+	Full    bool         `json:"full"`
+	Token   string       `json:"token"`
 }
 
 type backend struct {
@@ -51,6 +75,120 @@ func (b *backend) GetData() ([]data.User, []data.Group, error) {
 	return users, groups, nil
 }
 
+func (b *backend) GetSudoRoles() ([]data.SudoRole, error) {
+	if len(b.config.SudoRolesPath) == 0 {
+		return nil, nil
+	}
+
+	client := fasthttp.Client{
+		ReadTimeout:  b.config.HTTPReqTimeout,
+		WriteTimeout: b.config.HTTPReqTimeout,
+	}
+
+	sudoRoles := []data.SudoRole{}
+	if err := getData(&client, b.config.URL+b.config.SudoRolesPath, b.config.AuthToken, &sudoRoles); err != nil {
+		return nil, fmt.Errorf("error getting sudo roles data: %s", err)
+	}
+
+	return sudoRoles, nil
+}
+
+func (b *backend) GetNetgroups() ([]data.Netgroup, error) {
+	if len(b.config.NetgroupsPath) == 0 {
+		return nil, nil
+	}
+
+	client := fasthttp.Client{
+		ReadTimeout:  b.config.HTTPReqTimeout,
+		WriteTimeout: b.config.HTTPReqTimeout,
+	}
+
+	netgroups := []data.Netgroup{}
+	if err := getData(&client, b.config.URL+b.config.NetgroupsPath, b.config.AuthToken, &netgroups); err != nil {
+		return nil, fmt.Errorf("error getting netgroups data: %s", err)
+	}
+
+	return netgroups, nil
+}
+
+func (b *backend) GetHosts() ([]data.Host, error) {
+	if len(b.config.HostsPath) == 0 {
+		return nil, nil
+	}
+
+	client := fasthttp.Client{
+		ReadTimeout:  b.config.HTTPReqTimeout,
+		WriteTimeout: b.config.HTTPReqTimeout,
+	}
+
+	hosts := []data.Host{}
+	if err := getData(&client, b.config.URL+b.config.HostsPath, b.config.AuthToken, &hosts); err != nil {
+		return nil, fmt.Errorf("error getting hosts data: %s", err)
+	}
+
+	return hosts, nil
+}
+
+func (b *backend) GetAutomountMaps() ([]data.AutomountMap, error) {
+	if len(b.config.AutomountMapsPath) == 0 {
+		return nil, nil
+	}
+
+	client := fasthttp.Client{
+		ReadTimeout:  b.config.HTTPReqTimeout,
+		WriteTimeout: b.config.HTTPReqTimeout,
+	}
+
+	maps := []data.AutomountMap{}
+	if err := getData(&client, b.config.URL+b.config.AutomountMapsPath, b.config.AuthToken, &maps); err != nil {
+		return nil, fmt.Errorf("error getting automount maps data: %s", err)
+	}
+
+	return maps, nil
+}
+
+// GetChangesSince returns users and groups changed since 'token' if delta updates are enabled
+// they are requested with 'since' query parameter set to users and groups tokens returned by api, empty for all entries
+func (b *backend) GetChangesSince(token string) (*data.Changes, error) {
+	if !b.config.DeltaUpdates {
+		return nil, nil
+	}
+
+	since, err := url.ParseQuery(token)
+	if err != nil {
+		return nil, fmt.Errorf("wrong token '%s': %s", token, err)
+	}
+
+	client := fasthttp.Client{
+		ReadTimeout:  b.config.HTTPReqTimeout,
+		WriteTimeout: b.config.HTTPReqTimeout,
+	}
+
+	users := usersChanges{}
+	if err := getData(&client, b.config.URL+b.config.UsersPath+"?since="+url.QueryEscape(since.Get("users")), b.config.AuthToken, &users); err != nil {
+		return nil, fmt.Errorf("error getting users changes: %s", err)
+	}
+
+	groups := groupsChanges{}
+	if err := getData(&client, b.config.URL+b.config.GroupsPath+"?since="+url.QueryEscape(since.Get("groups")), b.config.AuthToken, &groups); err != nil {
+		return nil, fmt.Errorf("error getting groups changes: %s", err)
+	}
+
+	// changes are merged into all users and groups, so either both or none of them must be full
+	if users.Full != groups.Full {
+		return nil, errors.New("error getting changes: api returned all entries of users or groups only")
+	}
+
+	return &data.Changes{
+		Users:         users.Changed,
+		Groups:        groups.Changed,
+		DeletedUsers:  users.Deleted,
+		DeletedGroups: groups.Deleted,
+		Full:          users.Full,
+		Token:         url.Values{"users": {users.Token}, "groups": {groups.Token}}.Encode(),
+	}, nil
+}
+
 func (b *backend) UpdateData(old, new interface{}) error {
 	client := fasthttp.Client{
 		ReadTimeout:  b.config.HTTPReqTimeout,
@@ -58,26 +196,54 @@ func (b *backend) UpdateData(old, new interface{}) error {
 	}
 
 	tgt := reflect.New(reflect.TypeOf(old))
+	var cleared []reflect.StructField
 	for i := 0; i < reflect.ValueOf(old).NumField(); i++ {
 		var eq bool
 		switch {
 		case reflect.ValueOf(old).Field(i).Kind() == reflect.Slice:
 			eq = reflect.DeepEqual(reflect.ValueOf(old).Field(i).Interface(), reflect.ValueOf(new).Field(i).Interface())
+		case reflect.ValueOf(old).Field(i).Kind() == reflect.Map:
+			// send changed attributes only, removed attributes have no values
+			oldAttrs, newAttrs := reflect.ValueOf(old).Field(i).Interface().(data.Attributes), reflect.ValueOf(new).Field(i).Interface().(data.Attributes)
+			changed := data.Attributes{}
+			for k, v := range newAttrs {
+				if !reflect.DeepEqual(oldAttrs[k], v) {
+					changed[k] = v
+				}
+			}
+			for k := range oldAttrs {
+				if _, ok := newAttrs[k]; !ok {
+					changed[k] = []string{}
+				}
+			}
+			if len(changed) > 0 {
+				tgt.Elem().FieldByIndex([]int{i}).Set(reflect.ValueOf(changed))
+			}
+			continue
 		default:
 			eq = reflect.ValueOf(old).Field(i).Interface() == reflect.ValueOf(new).Field(i).Interface()
 		}
 		if !eq {
-			tgt.Elem().FieldByIndex([]int{i}).Set(reflect.ValueOf(new).Field(i))
+			v := reflect.ValueOf(new).Field(i)
+			tgt.Elem().FieldByIndex([]int{i}).Set(v)
+			if v.IsZero() || (v.Kind() == reflect.Slice && v.Len() == 0) {
+				cleared = append(cleared, reflect.TypeOf(old).Field(i))
+			}
 		}
 	}
 
+	body, err := getUpdateBody(tgt.Interface(), cleared)
+	if err != nil {
+		return fmt.Errorf("error marshalling data: %s", err)
+	}
+
 	switch entry := old.(type) {
 	case data.User:
-		if err := updateData(&client, b.config.URL+b.config.UsersPath+"/"+entry.CN, b.config.AuthToken, tgt.Interface()); err != nil {
+		if err := updateData(&client, b.config.URL+b.config.UsersPath+"/"+entry.CN, b.config.AuthToken, body); err != nil {
 			return fmt.Errorf("error updating users data: %s", err)
 		}
 	case data.Group:
-		if err := updateData(&client, b.config.URL+b.config.GroupsPath+"/"+entry.CN, b.config.AuthToken, tgt.Interface()); err != nil {
+		if err := updateData(&client, b.config.URL+b.config.GroupsPath+"/"+entry.CN, b.config.AuthToken, body); err != nil {
 			return fmt.Errorf("error updating groups data: %s", err)
 		}
 	}
@@ -85,6 +251,41 @@ func (b *backend) UpdateData(old, new interface{}) error {
 	return nil
 }
 
+// getUpdateBody returns JSON of changed fields of entry 'tgt', fields 'cleared' have no values and are omitted by their omitempty tags,
+// so they are added as empty lists or nulls for backend to remove their values
+func getUpdateBody(tgt interface{}, cleared []reflect.StructField) (json.RawMessage, error) {
+	b, err := json.Marshal(tgt)
+	if err != nil {
+		return nil, err
+	}
+
+	var buf bytes.Buffer
+	buf.Write(bytes.TrimSuffix(b, []byte("}")))
+	for _, field := range cleared {
+		name, opts, _ := strings.Cut(field.Tag.Get("json"), ",")
+		if len(name) == 0 || name == "-" || !strings.Contains(","+opts+",", ",omitempty,") {
+			continue
+		}
+		if buf.Len() > 1 {
+			buf.WriteByte(',')
+		}
+		key, err := json.Marshal(name)
+		if err != nil {
+			return nil, err
+		}
+		buf.Write(key)
+		buf.WriteByte(':')
+		if field.Type.Kind() == reflect.Slice {
+			buf.WriteString("[]")
+		} else {
+			buf.WriteString("null")
+		}
+	}
+	buf.WriteByte('}')
+
+	return buf.Bytes(), nil
+}
+
 func getData(c *fasthttp.Client, url, token string, data interface{}) error {
 	respData, err := doRequest(c, url, token, nil)
 	if err != nil {
@@ -0,0 +1,64 @@
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/ps78674/gorestldap/internal/data"
+)
+
+func TestUpdateData(t *testing.T) {
+	var method, path string
+	var body map[string]interface{}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		method, path = r.Method, r.URL.Path
+		b, err := io.ReadAll(r.Body)
+		if err == nil {
+			err = json.Unmarshal(b, &body)
+		}
+		if err != nil {
+			t.Errorf("wrong request body: %s", err)
+		}
+	}))
+	defer srv.Close()
+
+	b := backend{config: &config{URL: srv.URL, UsersPath: "/users", HTTPReqTimeout: time.Second}}
+
+	shadowMax := 99999
+	old := data.User{
+		CN:           "user01",
+		UID:          "user01",
+		Mail:         "user01@example.com",
+		ShadowMax:    &shadowMax,
+		SSHPublicKey: []string{"ssh-ed25519 AAAA user01"},
+		Attributes:   data.Attributes{"title": {"engineer"}},
+	}
+	new := old
+	new.Mail = "user01@example.org"
+	new.ShadowMax = nil
+	new.SSHPublicKey = []string{}
+	new.Attributes = data.Attributes{}
+
+	if err := b.UpdateData(old, new); err != nil {
+		t.Fatal(err)
+	}
+	if method != http.MethodPut || path != "/users/user01" {
+		t.Errorf("request = %s %s, want PUT /users/user01", method, path)
+	}
+
+	// removed values are sent explicitly, unchanged fields are not sent
+	want := map[string]interface{}{
+		"mail":         "user01@example.org",
+		"shadowMax":    nil,
+		"sshPublicKey": []interface{}{},
+		"title":        []interface{}{},
+	}
+	if !reflect.DeepEqual(body, want) {
+		t.Errorf("body = %v, want %v", body, want)
+	}
+}
@@ -6,13 +6,18 @@ import (
 	"os/signal"
 	"path"
 	"syscall"
+	"time"
 
 	"github.com/ps78674/gorestldap/internal/backend"
+	"github.com/ps78674/gorestldap/internal/cache"
 	"github.com/ps78674/gorestldap/internal/config"
+	"github.com/ps78674/gorestldap/internal/data"
 	"github.com/ps78674/gorestldap/internal/http"
 	"github.com/ps78674/gorestldap/internal/ldap"
+	"github.com/ps78674/gorestldap/internal/ldaputils"
 	"github.com/ps78674/gorestldap/internal/logger"
 	"github.com/ps78674/gorestldap/internal/ticker"
+	"github.com/sirupsen/logrus"
 	"github.com/valyala/fasthttp"
 )
 
@@ -31,31 +36,24 @@ func main() {
 		os.Exit(1)
 	}
 
-	// open backend
-	backendPath := path.Join(cfg.BackendDir, cfg.BackendName+".so")
-	logger.Debugf("loading backend '%s'", backendPath)
-	backend, err := backend.Open(backendPath, cfg.Backends[cfg.BackendName])
-	if err != nil {
-		logger.Fatalf("error opening backend: %s", err)
-	}
+	// set attributes schema
+	ldap.SetSchema(cfg.Schema)
 
-	// get initial data
-	users, groups, err := backend.GetData()
-	if err != nil {
-		logger.Fatalf("error getting data: %s", err)
+	// open backends and get initial data of naming contexts
+	var contexts []*ldap.NamingContext
+	var tickers []*ticker.Ticker
+	for _, ncCfg := range cfg.NamingContexts {
+		nc, err := newNamingContext(cfg, ncCfg, logger)
+		if err != nil {
+			logger.Fatalf("naming context '%s': %s", ncCfg.BaseDN, err)
+		}
+		defer nc.Ticker.Stop()
+		contexts = append(contexts, nc)
+		tickers = append(tickers, nc.Ticker)
 	}
 
-	// get entries
-	entries := ldap.GetEntries(cfg.BaseDN, cfg.UsersOUName, cfg.GroupsOUName)
-	entries.Users = users
-	entries.Groups = groups
-
-	// create ticker
-	ticker := ticker.NewTicker(cfg.UpdateInterval)
-	defer ticker.Stop()
-
 	// create new LDAP Server
-	ldapServer, err := ldap.NewServer(entries, cfg.BaseDN, cfg.UsersOUName, cfg.GroupsOUName, cfg.RespectCritical, backend, ticker, logger)
+	ldapServer, err := ldap.NewServer(contexts, cfg.RespectCritical, cfg.MembershipFormat != "", cfg.GroupSchema, cfg.Limits, cfg.PagedSearchTimeout, logger)
 	if err != nil {
 		logger.Fatalf("error creating ldap server: %s", err)
 	}
@@ -81,7 +79,7 @@ func main() {
 	var httpServer *fasthttp.Server
 	if len(cfg.HTTPListenAddr) > 0 {
 		logger.Infof("starting http server on '%s'", cfg.HTTPListenAddr)
-		httpServer = http.NewServer(cfg.CallbackAuthToken, ticker, logger)
+		httpServer = http.NewServer(cfg.CallbackAuthToken, tickers, contexts, logger)
 		go func() {
 			if err := httpServer.ListenAndServe(cfg.HTTPListenAddr); err != nil {
 				logger.Fatalf("http server error: %s", err)
@@ -89,37 +87,20 @@ func main() {
 		}()
 	}
 
-	// update data every cfg.UpdateInterval
-	go func() {
-		for range ticker.C {
-			func() {
-				logger.Info("updating entries data")
-
-				entries.Lock()
-				defer entries.Unlock()
-
-				logger.Debug("getting backend data")
-				users, groups, err := backend.GetData()
-				if err != nil {
-					logger.Errorf("error getting data: %s", err)
-					return
-				}
-
-				entries.Users = users
-				entries.Groups = groups
-
-				logger.Debug("entries updated")
-			}()
-		}
-	}()
+	// update data every naming context update interval
+	for i, nc := range contexts {
+		go updateNamingContext(cfg, cfg.NamingContexts[i], nc, logger)
+	}
 
-	// reset ticker / update data on SIGUSR1
+	// reset tickers / update data on SIGUSR1
 	chReload := make(chan os.Signal, 1)
 	go func() {
 		for {
 			signal.Notify(chReload, syscall.SIGUSR1)
 			<-chReload
-			ticker.Reset()
+			for _, t := range tickers {
+				t.Reset()
+			}
 		}
 	}()
 
@@ -137,3 +118,229 @@ func main() {
 	ldapServer.Stop()
 	logger.Debug("all client connections closed")
 }
+
+// newNamingContext opens backend of naming context 'ncCfg' and gets its initial data
+func newNamingContext(cfg config.Config, ncCfg config.NamingContext, logger *logrus.Logger) (*ldap.NamingContext, error) {
+	// open backend
+	backendPath := path.Join(cfg.BackendDir, ncCfg.BackendName+".so")
+	logger.Debugf("loading backend '%s' for '%s'", backendPath, ncCfg.BaseDN)
+	backend, err := backend.Open(backendPath, ncCfg.Backend)
+	if err != nil {
+		return nil, fmt.Errorf("error opening backend: %s", err)
+	}
+
+	// get initial data, cached data is served until backend data is loaded
+	// timestamps saved to cache are kept for entries which are not changed since then
+	loaded, err := getBackendData(backend, nil, logger)
+	var saved map[string]data.SavedTimestamps
+	switch {
+	case err != nil && len(ncCfg.CachePath) == 0:
+		return nil, fmt.Errorf("error getting data: %s", err)
+	case err != nil:
+		logger.Errorf("error getting data of '%s': %s", ncCfg.BaseDN, err)
+		cached, timestamps, savedAt, cacheErr := cache.Load(ncCfg.CachePath, cfg.CacheKey)
+		if cacheErr != nil {
+			return nil, fmt.Errorf("error getting data: %s, error loading cache '%s': %s", err, ncCfg.CachePath, cacheErr)
+		}
+		logger.Warnf("serving stale data of '%s' from cache saved at %s until backend data is loaded", ncCfg.BaseDN, savedAt.Format(time.RFC3339))
+		loaded, saved = cached, timestamps
+		loaded.Stale = true
+		loaded.LoadedAt = savedAt
+	case len(ncCfg.CachePath) > 0:
+		_, timestamps, _, cacheErr := cache.Load(ncCfg.CachePath, cfg.CacheKey)
+		if cacheErr != nil {
+			logger.Debugf("timestamps of '%s' are not loaded from cache '%s': %s", ncCfg.BaseDN, ncCfg.CachePath, cacheErr)
+		}
+		saved = timestamps
+	}
+	backendData := loaded.Copy()
+	setComputedAttributes(cfg, ncCfg, loaded, logger)
+
+	// get entries
+	entries := ldap.GetEntries(ncCfg.BaseDN)
+	entries.OUs = loaded.OUs
+	entries.Users = loaded.Users
+	entries.Groups = loaded.Groups
+	entries.SudoRoles = loaded.SudoRoles
+	entries.Netgroups = loaded.Netgroups
+	entries.Hosts = loaded.Hosts
+	entries.AutomountMaps = loaded.AutomountMaps
+	entries.Automounts = loaded.Automounts
+	entries.Token = loaded.Token
+	entries.Stale = loaded.Stale
+	entries.LoadedAt = loaded.LoadedAt
+	ldap.SetTimestamps(nil, saved, entries, ncCfg.BaseDN, ncCfg.UsersOUName, ncCfg.GroupsOUName, time.Now())
+	ldap.IndexEntries(entries, ncCfg.BaseDN, ncCfg.UsersOUName, ncCfg.GroupsOUName)
+	if !entries.Stale {
+		saveCache(cfg, ncCfg, backendData, entries, logger)
+	}
+
+	snapshot := &data.Snapshot{}
+	snapshot.Store(entries)
+
+	return &ldap.NamingContext{
+		Entries:      snapshot,
+		BaseDN:       ncCfg.BaseDN,
+		UsersOUName:  ncCfg.UsersOUName,
+		GroupsOUName: ncCfg.GroupsOUName,
+		// create notifier for persistent searches
+		Notifier: ldap.NewNotifier(ncCfg.BaseDN, ncCfg.UsersOUName, ncCfg.GroupsOUName),
+		Backend:  backend,
+		Ticker:   ticker.NewTicker(ncCfg.UpdateInterval),
+	}, nil
+}
+
+// updateNamingContext reloads data of naming context 'nc' on every tick
+// new entries are loaded and indexed while clients keep using current ones, then they are swapped
+func updateNamingContext(cfg config.Config, ncCfg config.NamingContext, nc *ldap.NamingContext, logger *logrus.Logger) {
+	for range nc.Ticker.C {
+		func() {
+			logger.Infof("updating entries data of '%s'", nc.BaseDN)
+
+			old := nc.Entries.Load()
+
+			logger.Debug("getting backend data")
+			loaded, err := getBackendData(nc.Backend, old, logger)
+			if err != nil {
+				logger.Errorf("error getting data of '%s': %s", nc.BaseDN, err)
+				if old.Stale {
+					logger.Warnf("serving stale data of '%s' from cache", nc.BaseDN)
+				}
+				return
+			}
+			if old.Stale {
+				logger.Infof("data of '%s' is loaded from backend, stale data is not served anymore", nc.BaseDN)
+			}
+
+			backendData := loaded.Copy()
+			setComputedAttributes(cfg, ncCfg, loaded, logger)
+
+			// timestamps not set by backend are derived from changes since previous snapshot
+			loaded.Domain = old.Domain
+			ldap.SetTimestamps(old, nil, loaded, ncCfg.BaseDN, ncCfg.UsersOUName, ncCfg.GroupsOUName, time.Now())
+			ldap.IndexEntries(loaded, ncCfg.BaseDN, ncCfg.UsersOUName, ncCfg.GroupsOUName)
+
+			nc.Entries.Store(loaded)
+
+			logger.Debug("entries updated")
+
+			// notify persistent searches
+			nc.Notifier.Publish(old, loaded)
+
+			saveCache(cfg, ncCfg, backendData, loaded, logger)
+		}()
+	}
+}
+
+// getBackendData returns entries data of backend 'b', sudo roles, netgroups, hosts and automount maps are loaded if backend provides them
+// users and groups changed since entries 'current' are merged into them if backend provides changes
+func getBackendData(b backend.Backend, current *data.Entries, logger *logrus.Logger) (*data.Entries, error) {
+	loaded, err := getUsersAndGroups(b, current, logger)
+	if err != nil {
+		return nil, err
+	}
+
+	if sb, ok := b.(backend.SudoBackend); ok {
+		if loaded.SudoRoles, err = sb.GetSudoRoles(); err != nil {
+			return nil, err
+		}
+	}
+
+	if nb, ok := b.(backend.NSSBackend); ok {
+		if loaded.Netgroups, err = nb.GetNetgroups(); err != nil {
+			return nil, err
+		}
+		if loaded.Hosts, err = nb.GetHosts(); err != nil {
+			return nil, err
+		}
+		if loaded.AutomountMaps, err = nb.GetAutomountMaps(); err != nil {
+			return nil, err
+		}
+	}
+
+	loaded.LoadedAt = time.Now()
+	return loaded, nil
+}
+
+// saveCache saves backend data 'loaded' and timestamps of served entries 'entries' to cache file of naming context 'ncCfg' if it is set
+func saveCache(cfg config.Config, ncCfg config.NamingContext, loaded, entries *data.Entries, logger *logrus.Logger) {
+	if len(ncCfg.CachePath) == 0 {
+		return
+	}
+	timestamps := ldap.GetSavedTimestamps(entries, ncCfg.BaseDN, ncCfg.UsersOUName, ncCfg.GroupsOUName)
+	if err := cache.Save(ncCfg.CachePath, cfg.CacheKey, loaded, timestamps); err != nil {
+		logger.Errorf("error saving data of '%s' to cache '%s': %s", ncCfg.BaseDN, ncCfg.CachePath, err)
+	}
+}
+
+// getUsersAndGroups returns entries with users and groups of backend 'b' and version of their data
+// all data is loaded if backend provides no changes or changes since entries 'current' can not be loaded
+func getUsersAndGroups(b backend.Backend, current *data.Entries, logger *logrus.Logger) (*data.Entries, error) {
+	if db, ok := b.(backend.DeltaBackend); ok {
+		var token string
+		if current != nil {
+			token = current.Token
+		}
+
+		changes, err := db.GetChangesSince(token)
+		switch {
+		case err != nil:
+			logger.Warnf("error getting data changes, loading all data: %s", err)
+		case changes != nil:
+			logger.Debugf("got data changes since '%s': full=%t users=%d groups=%d deleted users=%d deleted groups=%d", token, changes.Full, len(changes.Users), len(changes.Groups), len(changes.DeletedUsers), len(changes.DeletedGroups))
+			users, groups := ldap.ApplyChanges(current, *changes)
+			return &data.Entries{Users: users, Groups: groups, Token: changes.Token}, nil
+		}
+	}
+
+	users, groups, err := b.GetData()
+	if err != nil {
+		return nil, err
+	}
+	return &data.Entries{Users: users, Groups: groups}, nil
+}
+
+// setComputedAttributes checks attributes schema, places sudo roles and nss entries, computes ous, entryUUIDs, membership attributes, users and groups schema
+func setComputedAttributes(cfg config.Config, ncCfg config.NamingContext, loaded *data.Entries, logger *logrus.Logger) {
+	users, groups := loaded.Users, loaded.Groups
+	for _, err := range ldap.CheckSchema(users, groups) {
+		logger.Warn(err)
+	}
+
+	// sudoers ou exists if backend provides sudo roles
+	ous := ncCfg.OUs
+	if loaded.SudoRoles != nil {
+		for _, err := range ldap.SetSudoRoles(loaded.SudoRoles, ncCfg.BaseDN, ncCfg.SudoersOUName) {
+			logger.Warn(err)
+		}
+		ous = append(ous[:len(ous):len(ous)], ldaputils.OUPath(ncCfg.SudoersOUName))
+	}
+
+	// netgroups, hosts and automount ous exist if backend provides such entries
+	for _, err := range ldap.SetNSSEntries(loaded, ncCfg.BaseDN, ncCfg.NetgroupsOUName, ncCfg.HostsOUName, ncCfg.AutomountOUName) {
+		logger.Warn(err)
+	}
+	if loaded.Netgroups != nil {
+		ous = append(ous[:len(ous):len(ous)], ldaputils.OUPath(ncCfg.NetgroupsOUName))
+	}
+	if loaded.Hosts != nil {
+		ous = append(ous[:len(ous):len(ous)], ldaputils.OUPath(ncCfg.HostsOUName))
+	}
+	if loaded.AutomountMaps != nil {
+		ous = append(ous[:len(ous):len(ous)], ldaputils.OUPath(ncCfg.AutomountOUName))
+	}
+
+	var errs []error
+	loaded.OUs, errs = ldap.GetOUs(loaded, ncCfg.BaseDN, ncCfg.UsersOUName, ncCfg.GroupsOUName, ous)
+	for _, err := range errs {
+		logger.Warn(err)
+	}
+	for _, err := range ldap.SetEntryUUIDs(loaded, ncCfg.BaseDN, ncCfg.UsersOUName, ncCfg.GroupsOUName) {
+		logger.Warn(err)
+	}
+	for _, err := range ldap.SetMembership(users, groups, ncCfg.BaseDN, ncCfg.UsersOUName, ncCfg.GroupsOUName, cfg.MembershipFormat == config.MembershipFormatDN) {
+		logger.Warn(err)
+	}
+	ldap.SetUserSchema(users, cfg.MembershipFormat != "")
+	ldap.SetGroupSchema(groups, cfg.GroupSchema)
+}
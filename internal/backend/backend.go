@@ -4,6 +4,7 @@ import (
 	"errors"
 	"fmt"
 	"plugin"
+	"reflect"
 
 	"github.com/ps78674/gorestldap/internal/data"
 	"gopkg.in/yaml.v3"
@@ -15,7 +16,27 @@ type Backend interface {
 	UpdateData(interface{}, interface{}) error
 }
 
+// SudoBackend is implemented by backends providing sudoers entries, nil roles mean sudoers are not configured
+type SudoBackend interface {
+	GetSudoRoles() ([]data.SudoRole, error)
+}
+
+// NSSBackend is implemented by backends providing netgroups, hosts and automount maps with their automount entries
+// nil entries mean they are not configured
+type NSSBackend interface {
+	GetNetgroups() ([]data.Netgroup, error)
+	GetHosts() ([]data.Host, error)
+	GetAutomountMaps() ([]data.AutomountMap, error)
+}
+
+// DeltaBackend is implemented by backends providing users and groups changed since backend data version 'token'
+// nil changes mean backend is not configured to provide them, all data is loaded with GetData then
+type DeltaBackend interface {
+	GetChangesSince(token string) (*data.Changes, error)
+}
+
 // Open opens a backend.
+// Every call returns new backend instance configured with 'cfg'.
 func Open(path string, cfg interface{}) (Backend, error) {
 	// open plugin
 	p, err := plugin.Open(path)
@@ -30,11 +51,13 @@ func Open(path string, cfg interface{}) (Backend, error) {
 	}
 
 	// assert type
-	backend, ok := symBackend.(Backend)
-	if !ok {
+	if _, ok := symBackend.(Backend); !ok {
 		return nil, errors.New("error loading backend: unexpected type")
 	}
 
+	// plugin is loaded once, so each naming context gets its own instance of exported var
+	backend := reflect.New(reflect.TypeOf(symBackend).Elem()).Interface().(Backend)
+
 	// marshall backend config
 	b, err := yaml.Marshal(cfg)
 	if err != nil {
@@ -0,0 +1,270 @@
+package ber
+
+import (
+	"errors"
+	"fmt"
+)
+
+// identifier classes
+const (
+	ClassUniversal   = 0x00
+	ClassApplication = 0x40
+	ClassContext     = 0x80
+	ClassPrivate     = 0xc0
+)
+
+// universal tags
+const (
+	TagBoolean     = 0x01
+	TagInteger     = 0x02
+	TagOctetString = 0x04
+	TagEnumerated  = 0x0a
+	TagSequence    = 0x10
+	TagSet         = 0x11
+)
+
+const (
+	classMask       = 0xc0
+	constructedMask = 0x20
+	tagMask         = 0x1f
+)
+
+// Packet is a decoded BER element
+type Packet struct {
+	Class       int
+	Constructed bool
+	Tag         int
+	Value       []byte
+	Children    []*Packet
+}
+
+// Decode decodes exactly one BER element from b
+func Decode(b []byte) (*Packet, error) {
+	p, n, err := decode(b)
+	if err != nil {
+		return nil, err
+	}
+	if n != len(b) {
+		return nil, fmt.Errorf("got %d bytes of trailing data", len(b)-n)
+	}
+	return p, nil
+}
+
+func decode(b []byte) (*Packet, int, error) {
+	if len(b) < 2 {
+		return nil, 0, errors.New("unexpected end of data")
+	}
+
+	p := &Packet{
+		Class:       int(b[0] & classMask),
+		Constructed: b[0]&constructedMask != 0,
+		Tag:         int(b[0] & tagMask),
+	}
+	offset := 1
+
+	// high tag number form
+	if p.Tag == tagMask {
+		p.Tag = 0
+		for {
+			if offset >= len(b) {
+				return nil, 0, errors.New("unexpected end of data in tag")
+			}
+			c := b[offset]
+			offset++
+			p.Tag = p.Tag<<7 | int(c&0x7f)
+			if c&0x80 == 0 {
+				break
+			}
+			if p.Tag > 1<<24 {
+				return nil, 0, errors.New("tag number is too large")
+			}
+		}
+	}
+
+	if offset >= len(b) {
+		return nil, 0, errors.New("unexpected end of data in length")
+	}
+	length := int(b[offset])
+	offset++
+	if length&0x80 != 0 {
+		n := length & 0x7f
+		if n == 0 {
+			return nil, 0, errors.New("indefinite length is not supported")
+		}
+		if n > 4 {
+			return nil, 0, errors.New("length is too large")
+		}
+		if offset+n > len(b) {
+			return nil, 0, errors.New("unexpected end of data in length")
+		}
+		length = 0
+		for _, c := range b[offset : offset+n] {
+			length = length<<8 | int(c)
+		}
+		offset += n
+	}
+
+	if length < 0 || offset+length > len(b) {
+		return nil, 0, errors.New("length exceeds available data")
+	}
+	p.Value = b[offset : offset+length]
+
+	if p.Constructed {
+		for data := p.Value; len(data) > 0; {
+			child, n, err := decode(data)
+			if err != nil {
+				return nil, 0, err
+			}
+			p.Children = append(p.Children, child)
+			data = data[n:]
+		}
+	}
+
+	return p, offset + length, nil
+}
+
+// Bytes encodes packet p
+func (p *Packet) Bytes() []byte {
+	value := p.Value
+	if p.Constructed {
+		value = []byte{}
+		for _, child := range p.Children {
+			value = append(value, child.Bytes()...)
+		}
+	}
+
+	var out []byte
+	identifier := byte(p.Class & classMask)
+	if p.Constructed {
+		identifier |= constructedMask
+	}
+	if p.Tag < tagMask {
+		out = append(out, identifier|byte(p.Tag))
+	} else {
+		out = append(out, identifier|tagMask)
+		var tagBytes []byte
+		for t := p.Tag; t > 0; t >>= 7 {
+			tagBytes = append([]byte{byte(t & 0x7f)}, tagBytes...)
+		}
+		for i := 0; i < len(tagBytes)-1; i++ {
+			tagBytes[i] |= 0x80
+		}
+		out = append(out, tagBytes...)
+	}
+
+	if len(value) < 0x80 {
+		out = append(out, byte(len(value)))
+	} else {
+		var lenBytes []byte
+		for l := len(value); l > 0; l >>= 8 {
+			lenBytes = append([]byte{byte(l)}, lenBytes...)
+		}
+		out = append(out, 0x80|byte(len(lenBytes)))
+		out = append(out, lenBytes...)
+	}
+
+	return append(out, value...)
+}
+
+// Is returns true if packet has class 'class' and tag 'tag'
+func (p *Packet) Is(class, tag int) bool {
+	return p.Class == class && p.Tag == tag
+}
+
+// Append appends children to constructed packet p
+func (p *Packet) Append(children ...*Packet) *Packet {
+	p.Children = append(p.Children, children...)
+	return p
+}
+
+// Int decodes packet value as integer
+func (p *Packet) Int() (int64, error) {
+	if p.Constructed || len(p.Value) == 0 {
+		return 0, errors.New("invalid integer encoding")
+	}
+	if len(p.Value) > 8 {
+		return 0, errors.New("integer is too large")
+	}
+	var i int64
+	if p.Value[0]&0x80 != 0 {
+		i = -1
+	}
+	for _, c := range p.Value {
+		i = i<<8 | int64(c)
+	}
+	return i, nil
+}
+
+// Bool decodes packet value as boolean
+func (p *Packet) Bool() (bool, error) {
+	if p.Constructed || len(p.Value) != 1 {
+		return false, errors.New("invalid boolean encoding")
+	}
+	return p.Value[0] != 0, nil
+}
+
+// String returns packet value as string
+func (p *Packet) String() string {
+	return string(p.Value)
+}
+
+// NewPrimitive creates primitive packet with raw value
+func NewPrimitive(class, tag int, value []byte) *Packet {
+	return &Packet{Class: class, Tag: tag, Value: value}
+}
+
+// NewConstructed creates constructed packet with children
+func NewConstructed(class, tag int, children ...*Packet) *Packet {
+	return &Packet{Class: class, Tag: tag, Constructed: true, Children: children}
+}
+
+// NewSequence creates universal SEQUENCE
+func NewSequence(children ...*Packet) *Packet {
+	return NewConstructed(ClassUniversal, TagSequence, children...)
+}
+
+// NewSet creates universal SET
+func NewSet(children ...*Packet) *Packet {
+	return NewConstructed(ClassUniversal, TagSet, children...)
+}
+
+// NewOctetString creates universal OCTET STRING
+func NewOctetString(s string) *Packet {
+	return NewPrimitive(ClassUniversal, TagOctetString, []byte(s))
+}
+
+// NewBoolean creates universal BOOLEAN
+func NewBoolean(b bool) *Packet {
+	return NewPrimitive(ClassUniversal, TagBoolean, encodeBool(b))
+}
+
+// NewInteger creates universal INTEGER
+func NewInteger(i int64) *Packet {
+	return NewPrimitive(ClassUniversal, TagInteger, EncodeInt(i))
+}
+
+// NewEnumerated creates universal ENUMERATED
+func NewEnumerated(i int64) *Packet {
+	return NewPrimitive(ClassUniversal, TagEnumerated, EncodeInt(i))
+}
+
+// EncodeInt returns minimal two's complement encoding of i
+func EncodeInt(i int64) []byte {
+	n := 1
+	for v := i; v > 127 || v < -128; v >>= 8 {
+		n++
+	}
+	out := make([]byte, n)
+	for j := n - 1; j >= 0; j-- {
+		out[j] = byte(i)
+		i >>= 8
+	}
+	return out
+}
+
+func encodeBool(b bool) []byte {
+	if b {
+		return []byte{0xff}
+	}
+	return []byte{0x00}
+}
@@ -0,0 +1,105 @@
+package ber
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestEncodeInt(t *testing.T) {
+	tests := []struct {
+		i    int64
+		want []byte
+	}{
+		{0, []byte{0x00}},
+		{127, []byte{0x7f}},
+		{128, []byte{0x00, 0x80}},
+		{256, []byte{0x01, 0x00}},
+		{-1, []byte{0xff}},
+		{-128, []byte{0x80}},
+		{-129, []byte{0xff, 0x7f}},
+	}
+
+	for _, tt := range tests {
+		got := EncodeInt(tt.i)
+		if !bytes.Equal(got, tt.want) {
+			t.Errorf("EncodeInt(%d) = %x, want %x", tt.i, got, tt.want)
+		}
+		i, err := NewInteger(tt.i).Int()
+		if err != nil || i != tt.i {
+			t.Errorf("Int() of %d = %d, %v", tt.i, i, err)
+		}
+	}
+}
+
+func TestDecode(t *testing.T) {
+	p := NewSequence(
+		NewInteger(-5),
+		NewBoolean(true),
+		NewOctetString(strings.Repeat("a", 300)),
+		NewPrimitive(ClassContext, 40, []byte("high tag")),
+		NewConstructed(ClassContext, 1, NewEnumerated(3)),
+	)
+
+	decoded, err := Decode(p.Bytes())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !decoded.Is(ClassUniversal, TagSequence) || !decoded.Constructed || len(decoded.Children) != 5 {
+		t.Fatalf("wrong sequence %+v", decoded)
+	}
+	if i, err := decoded.Children[0].Int(); err != nil || i != -5 {
+		t.Errorf("integer = %d, %v", i, err)
+	}
+	if b, err := decoded.Children[1].Bool(); err != nil || !b {
+		t.Errorf("boolean = %t, %v", b, err)
+	}
+	if s := decoded.Children[2].String(); len(s) != 300 {
+		t.Errorf("got octet string of %d bytes", len(s))
+	}
+	if c := decoded.Children[3]; !c.Is(ClassContext, 40) || c.String() != "high tag" {
+		t.Errorf("wrong high tag element %+v", c)
+	}
+	if c := decoded.Children[4]; !c.Is(ClassContext, 1) || len(c.Children) != 1 {
+		t.Errorf("wrong constructed element %+v", c)
+	}
+	if !bytes.Equal(decoded.Bytes(), p.Bytes()) {
+		t.Error("decoded packet is encoded differently")
+	}
+}
+
+func TestDecodeErrors(t *testing.T) {
+	tests := []struct {
+		name string
+		b    []byte
+	}{
+		{"empty", nil},
+		{"short", []byte{0x04}},
+		{"value exceeds data", []byte{0x04, 0x05, 'a'}},
+		{"indefinite length", []byte{0x30, 0x80, 0x00, 0x00}},
+		{"length too large", []byte{0x04, 0x85, 0x01, 0x00, 0x00, 0x00, 0x00}},
+		{"trailing data", []byte{0x04, 0x01, 'a', 'b'}},
+		{"wrong child", []byte{0x30, 0x02, 0x04, 0x05}},
+	}
+
+	for _, tt := range tests {
+		if _, err := Decode(tt.b); err == nil {
+			t.Errorf("%s: expected error", tt.name)
+		}
+	}
+}
+
+func TestPacketValueErrors(t *testing.T) {
+	if _, err := NewPrimitive(ClassUniversal, TagInteger, nil).Int(); err == nil {
+		t.Error("expected error for empty integer")
+	}
+	if _, err := NewPrimitive(ClassUniversal, TagInteger, make([]byte, 9)).Int(); err == nil {
+		t.Error("expected error for too large integer")
+	}
+	if _, err := NewPrimitive(ClassUniversal, TagBoolean, []byte{0x01, 0x01}).Bool(); err == nil {
+		t.Error("expected error for wrong boolean")
+	}
+	if _, err := NewSequence().Bool(); err == nil {
+		t.Error("expected error for constructed boolean")
+	}
+}
@@ -0,0 +1,126 @@
+package cache
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/ps78674/gorestldap/internal/data"
+)
+
+// snapshot is a cache file contents
+type snapshot struct {
+	SavedAt    time.Time                       `json:"savedAt"`
+	Entries    *data.Entries                   `json:"entries"`
+	Timestamps map[string]data.SavedTimestamps `json:"timestamps,omitempty"`
+}
+
+// Save writes backend data 'entries' and timestamps of served entries 'timestamps' to cache file 'path' readable by owner only
+// data is encrypted with AES-GCM if key 'key' is set
+func Save(path, key string, entries *data.Entries, timestamps map[string]data.SavedTimestamps) error {
+	b, err := json.Marshal(snapshot{SavedAt: time.Now(), Entries: entries, Timestamps: timestamps})
+	if err != nil {
+		return fmt.Errorf("error marshalling data: %s", err)
+	}
+
+	if len(key) > 0 {
+		if b, err = encrypt(b, key); err != nil {
+			return fmt.Errorf("error encrypting data: %s", err)
+		}
+	}
+
+	// cache is replaced at once, so it is never partially written
+	f, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".*")
+	if err != nil {
+		return fmt.Errorf("error creating file: %s", err)
+	}
+	defer os.Remove(f.Name())
+
+	if err := f.Chmod(0600); err != nil {
+		f.Close()
+		return fmt.Errorf("error setting file mode: %s", err)
+	}
+	if _, err := f.Write(b); err != nil {
+		f.Close()
+		return fmt.Errorf("error writing file: %s", err)
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return fmt.Errorf("error writing file: %s", err)
+	}
+	if err := f.Close(); err != nil {
+		return fmt.Errorf("error writing file: %s", err)
+	}
+
+	if err := os.Rename(f.Name(), path); err != nil {
+		return fmt.Errorf("error replacing file: %s", err)
+	}
+
+	return nil
+}
+
+// Load returns backend data and timestamps saved to cache file 'path' with key 'key' and time they were saved
+func Load(path, key string) (*data.Entries, map[string]data.SavedTimestamps, time.Time, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, nil, time.Time{}, fmt.Errorf("error opening file: %s", err)
+	}
+
+	if len(key) > 0 {
+		if b, err = decrypt(b, key); err != nil {
+			return nil, nil, time.Time{}, fmt.Errorf("error decrypting data: %s", err)
+		}
+	}
+
+	var s snapshot
+	if err := json.Unmarshal(b, &s); err != nil {
+		return nil, nil, time.Time{}, fmt.Errorf("error unmarshalling data: %s", err)
+	}
+	if s.Entries == nil {
+		return nil, nil, time.Time{}, errors.New("error unmarshalling data: no entries")
+	}
+
+	return s.Entries, s.Timestamps, s.SavedAt, nil
+}
+
+// newGCM returns AES-256-GCM cipher with key derived from 'key'
+func newGCM(key string) (cipher.AEAD, error) {
+	sum := sha256.Sum256([]byte(key))
+	block, err := aes.NewCipher(sum[:])
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// encrypt returns nonce followed by encrypted 'b'
+func encrypt(b []byte, key string) ([]byte, error) {
+	gcm, err := newGCM(key)
+	if err != nil {
+		return nil, err
+	}
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	return gcm.Seal(nonce, nonce, b, nil), nil
+}
+
+// decrypt returns data encrypted with encrypt
+func decrypt(b []byte, key string) ([]byte, error) {
+	gcm, err := newGCM(key)
+	if err != nil {
+		return nil, err
+	}
+	if len(b) < gcm.NonceSize() {
+		return nil, errors.New("data is too short")
+	}
+	return gcm.Open(nil, b[:gcm.NonceSize()], b[gcm.NonceSize():], nil)
+}
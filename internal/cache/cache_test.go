@@ -0,0 +1,95 @@
+package cache
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/ps78674/gorestldap/internal/data"
+)
+
+func TestSaveLoad(t *testing.T) {
+	entries := &data.Entries{
+		Users:  []data.User{{CN: "user01", UID: "user01", Mail: "user01@example.com"}},
+		Groups: []data.Group{{CN: "group01", GIDNumber: 1000, MemberUID: []string{"user01"}}},
+	}
+	timestamps := map[string]data.SavedTimestamps{
+		"cn=user01,ou=users,dc=example,dc=com": {Hash: "hash", Timestamps: data.Timestamps{CreateTimestamp: "20240102030405Z"}},
+	}
+
+	for _, key := range []string{"", "secret"} {
+		path := filepath.Join(t.TempDir(), "cache.json")
+		before := time.Now()
+		if err := Save(path, key, entries, timestamps); err != nil {
+			t.Fatalf("key %q: %s", key, err)
+		}
+
+		fi, err := os.Stat(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if mode := fi.Mode().Perm(); mode != 0600 {
+			t.Errorf("key %q: file mode = %o, want 600", key, mode)
+		}
+		b, err := os.ReadFile(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if encrypted := !bytes.Contains(b, []byte("user01@example.com")); encrypted != (len(key) > 0) {
+			t.Errorf("key %q: data is encrypted = %t", key, encrypted)
+		}
+
+		loaded, loadedTimestamps, savedAt, err := Load(path, key)
+		if err != nil {
+			t.Fatalf("key %q: %s", key, err)
+		}
+		if len(loaded.Users) != 1 || loaded.Users[0].Mail != "user01@example.com" {
+			t.Errorf("key %q: users = %+v", key, loaded.Users)
+		}
+		if len(loaded.Groups) != 1 || loaded.Groups[0].GIDNumber != 1000 || len(loaded.Groups[0].MemberUID) != 1 {
+			t.Errorf("key %q: groups = %+v", key, loaded.Groups)
+		}
+		if len(loadedTimestamps) != 1 || loadedTimestamps["cn=user01,ou=users,dc=example,dc=com"] != timestamps["cn=user01,ou=users,dc=example,dc=com"] {
+			t.Errorf("key %q: timestamps = %+v, want %+v", key, loadedTimestamps, timestamps)
+		}
+		if savedAt.Before(before.Add(-time.Second)) || savedAt.After(time.Now()) {
+			t.Errorf("key %q: saved at %s", key, savedAt)
+		}
+	}
+}
+
+func TestLoadErrors(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "cache.json")
+	if err := Save(path, "secret", &data.Entries{}, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, _, _, err := Load(path, "other"); err == nil {
+		t.Error("expected error for wrong key")
+	}
+	if _, _, _, err := Load(path, ""); err == nil {
+		t.Error("expected error for missing key")
+	}
+	if _, _, _, err := Load(filepath.Join(dir, "missing.json"), ""); err == nil {
+		t.Error("expected error for missing file")
+	}
+
+	short := filepath.Join(dir, "short.json")
+	if err := os.WriteFile(short, []byte("abc"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, _, err := Load(short, "secret"); err == nil {
+		t.Error("expected error for short data")
+	}
+
+	empty := filepath.Join(dir, "empty.json")
+	if err := os.WriteFile(empty, []byte(`{"savedAt":"2024-01-02T03:04:05Z"}`), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, _, err := Load(empty, ""); err == nil {
+		t.Error("expected error for missing entries")
+	}
+}
@@ -1,7 +1,9 @@
 package config
 
 import (
+	"errors"
 	"fmt"
+	"net/url"
 	"os"
 	"path/filepath"
 	"strings"
@@ -13,32 +15,110 @@ import (
 )
 
 type Config struct {
-	ConfigPath        string                 `docopt:"--config"`
-	BackendName       string                 `docopt:"--backend"`
-	BaseDN            string                 `docopt:"--basedn"`
-	ListenAddr        string                 `docopt:"--listen"`
-	UpdateInterval    time.Duration          `docopt:"--interval"`
-	LogPath           string                 `docopt:"--log"`
-	Debug             bool                   `docopt:"--debug"`
-	LogTimestamp      bool                   `yaml:"log_timestamp"`
-	LogCaller         bool                   `yaml:"log_caller"`
-	BackendDir        string                 `yaml:"backend_dir"`
-	Backends          map[string]interface{} `yaml:"backends"`
-	RespectCritical   bool                   `yaml:"respect_control_criticality"`
-	UsersOUName       string                 `yaml:"users_ou_name"`
-	GroupsOUName      string                 `yaml:"groups_ou_name"`
-	UseTLS            bool                   `yaml:"use_tls"`
-	ServerCert        string                 `yaml:"server_cert"`
-	ServerKey         string                 `yaml:"server_key"`
-	HTTPListenAddr    string                 `yaml:"http_listen_addr"`
-	CallbackAuthToken string                 `yaml:"callback_auth_token"`
+	ConfigPath         string                 `docopt:"--config"`
+	BackendName        string                 `docopt:"--backend"`
+	BaseDN             string                 `docopt:"--basedn"`
+	ListenAddr         string                 `docopt:"--listen"`
+	UpdateInterval     time.Duration          `docopt:"--interval"`
+	LogPath            string                 `docopt:"--log"`
+	Debug              bool                   `docopt:"--debug"`
+	LogTimestamp       bool                   `yaml:"log_timestamp"`
+	LogCaller          bool                   `yaml:"log_caller"`
+	BackendDir         string                 `yaml:"backend_dir"`
+	Backends           map[string]interface{} `yaml:"backends"`
+	RespectCritical    bool                   `yaml:"respect_control_criticality"`
+	PagedSearchTimeout time.Duration          `yaml:"paged_search_timeout"`
+	Limits             map[string]Limits      `yaml:"limits"`
+	UsersOUName        string                 `yaml:"users_ou_name"`
+	GroupsOUName       string                 `yaml:"groups_ou_name"`
+	SudoersOUName      string                 `yaml:"sudoers_ou_name"`
+	NetgroupsOUName    string                 `yaml:"netgroups_ou_name"`
+	HostsOUName        string                 `yaml:"hosts_ou_name"`
+	AutomountOUName    string                 `yaml:"automount_ou_name"`
+	OUs                []string               `yaml:"organizational_units"`
+	NamingContexts     []NamingContext        `yaml:"naming_contexts"`
+	MembershipFormat   string                 `yaml:"membership_format"`
+	GroupSchema        GroupSchema            `yaml:"group_schema"`
+	Schema             Schema                 `yaml:"schema"`
+	UseTLS             bool                   `yaml:"use_tls"`
+	ServerCert         string                 `yaml:"server_cert"`
+	ServerKey          string                 `yaml:"server_key"`
+	HTTPListenAddr     string                 `yaml:"http_listen_addr"`
+	CallbackAuthToken  string                 `yaml:"callback_auth_token"`
+	CacheDir           string                 `yaml:"cache_dir"`
+	CacheKey           string                 `yaml:"cache_key"`
 }
 
+// NamingContext is a suffix served from its own backend, unset values are taken from top level settings
+type NamingContext struct {
+	BaseDN          string        `yaml:"base_dn"`
+	BackendName     string        `yaml:"backend"`
+	Backend         interface{}   `yaml:"backend_config"`
+	UsersOUName     string        `yaml:"users_ou_name"`
+	GroupsOUName    string        `yaml:"groups_ou_name"`
+	SudoersOUName   string        `yaml:"sudoers_ou_name"`
+	NetgroupsOUName string        `yaml:"netgroups_ou_name"`
+	HostsOUName     string        `yaml:"hosts_ou_name"`
+	AutomountOUName string        `yaml:"automount_ou_name"`
+	OUs             []string      `yaml:"organizational_units"`
+	UpdateInterval  time.Duration `yaml:"update_interval"`
+	CachePath       string        `yaml:"cache_path"`
+}
+
+// Limits are administrative search limits, zero value means no limit
+type Limits struct {
+	Size int           `yaml:"size"`
+	Time time.Duration `yaml:"time"`
+}
+
+// GroupSchema sets objectClasses and computed membership attributes of groups
+type GroupSchema struct {
+	Mode string `yaml:"mode"`
+	// objectClasses replacing backend ones, backend objectClasses are used if empty
+	ObjectClasses []string `yaml:"object_classes"`
+	// computed attributes, backend memberUid is used if memberUid is not computed
+	Attributes []string `yaml:"attributes"`
+}
+
+// Schema defines users and groups attributes which have no struct fields
+type Schema struct {
+	// serve attributes which are not defined in schema as multi valued case insensitive ones
+	AllowUndefined bool                     `yaml:"allow_undefined"`
+	Attributes     map[string]AttributeType `yaml:"attributes"`
+}
+
+// AttributeType is an attribute definition
+type AttributeType struct {
+	SingleValue   bool `yaml:"single_value"`
+	CaseSensitive bool `yaml:"case_sensitive"`
+}
+
+const (
+	defaultUsersOUName        = "users"
+	defaultGroupsOUName       = "groups"
+	defaultSudoersOUName      = "sudoers"
+	defaultNetgroupsOUName    = "netgroups"
+	defaultHostsOUName        = "hosts"
+	defaultAutomountOUName    = "automount"
+	defaultPagedSearchTimeout = 5 * time.Minute
+)
+
+// membership attributes (memberOf, member, uniqueMember) value formats
+const (
+	MembershipFormatName = "name"
+	MembershipFormatDN   = "dn"
+)
+
+// group schema modes
 const (
-	defaultUsersOUName  = "users"
-	defaultGroupsOUName = "groups"
+	GroupSchemaBackend    = "backend"
+	GroupSchemaRFC2307    = "rfc2307"
+	GroupSchemaRFC2307bis = "rfc2307bis"
 )
 
+// computed group attributes
+var groupSchemaAttributes = []string{"memberUid", "member", "uniqueMember", "memberOf"}
+
 var (
 	VersionString = "devel"
 	ProgramName   = filepath.Base(os.Args[0])
@@ -98,8 +178,195 @@ func (c *Config) Init() error {
 		c.GroupsOUName = defaultGroupsOUName
 	}
 
+	if len(c.SudoersOUName) == 0 {
+		c.SudoersOUName = defaultSudoersOUName
+	}
+
+	if len(c.NetgroupsOUName) == 0 {
+		c.NetgroupsOUName = defaultNetgroupsOUName
+	}
+
+	if len(c.HostsOUName) == 0 {
+		c.HostsOUName = defaultHostsOUName
+	}
+
+	if len(c.AutomountOUName) == 0 {
+		c.AutomountOUName = defaultAutomountOUName
+	}
+
+	// users memberOf is served from backend if format is not set
+	switch c.MembershipFormat {
+	case "", MembershipFormatName, MembershipFormatDN:
+	default:
+		return fmt.Errorf("wrong membership_format '%s': must be '%s' or '%s'", c.MembershipFormat, MembershipFormatName, MembershipFormatDN)
+	}
+
+	if err := c.GroupSchema.init(); err != nil {
+		return err
+	}
+
+	// attributes are case insensitive
+	attributes := make(map[string]AttributeType, len(c.Schema.Attributes))
+	for k, v := range c.Schema.Attributes {
+		attributes[strings.ToLower(k)] = v
+	}
+	c.Schema.Attributes = attributes
+
+	if c.PagedSearchTimeout == 0 {
+		c.PagedSearchTimeout = defaultPagedSearchTimeout
+	}
+
+	// limits may be set per role (anonymous, users, admins) or per bind dn
+	limits := make(map[string]Limits, len(c.Limits))
+	for k, v := range c.Limits {
+		limits[ldaputils.NormalizeEntry(k)] = v
+	}
+	c.Limits = limits
+
 	c.UsersOUName = strings.ToLower(c.UsersOUName)
 	c.GroupsOUName = strings.ToLower(c.GroupsOUName)
 
+	// single naming context is served with top level settings
+	if len(c.NamingContexts) == 0 {
+		c.NamingContexts = []NamingContext{{BaseDN: c.BaseDN}}
+	}
+	for i := range c.NamingContexts {
+		if err := c.NamingContexts[i].init(c); err != nil {
+			return err
+		}
+	}
+	for i, nc := range c.NamingContexts {
+		for _, other := range c.NamingContexts[:i] {
+			if ldaputils.IsSubordinate(nc.BaseDN, other.BaseDN, true) || ldaputils.IsSubordinate(other.BaseDN, nc.BaseDN, true) {
+				return fmt.Errorf("wrong naming_contexts: '%s' overlaps '%s'", nc.BaseDN, other.BaseDN)
+			}
+		}
+	}
+
 	return nil
 }
+
+// init sets unset values of naming context from top level settings 'c'
+func (nc *NamingContext) init(c *Config) error {
+	if _, err := ldaputils.ParseDN(nc.BaseDN); err != nil {
+		return fmt.Errorf("wrong naming_contexts: %s", err)
+	}
+	nc.BaseDN = ldaputils.NormalizeEntry(nc.BaseDN)
+	if len(nc.BaseDN) == 0 {
+		return errors.New("wrong naming_contexts: base_dn is not set")
+	}
+
+	if len(nc.BackendName) == 0 {
+		nc.BackendName = c.BackendName
+	}
+	if nc.Backend == nil {
+		nc.Backend = c.Backends[nc.BackendName]
+	}
+
+	if len(nc.UsersOUName) == 0 {
+		nc.UsersOUName = c.UsersOUName
+	}
+	if len(nc.GroupsOUName) == 0 {
+		nc.GroupsOUName = c.GroupsOUName
+	}
+	if len(nc.SudoersOUName) == 0 {
+		nc.SudoersOUName = c.SudoersOUName
+	}
+	if len(nc.NetgroupsOUName) == 0 {
+		nc.NetgroupsOUName = c.NetgroupsOUName
+	}
+	if len(nc.HostsOUName) == 0 {
+		nc.HostsOUName = c.HostsOUName
+	}
+	if len(nc.AutomountOUName) == 0 {
+		nc.AutomountOUName = c.AutomountOUName
+	}
+	nc.UsersOUName = strings.ToLower(nc.UsersOUName)
+	nc.GroupsOUName = strings.ToLower(nc.GroupsOUName)
+	nc.SudoersOUName = strings.ToLower(nc.SudoersOUName)
+	nc.NetgroupsOUName = strings.ToLower(nc.NetgroupsOUName)
+	nc.HostsOUName = strings.ToLower(nc.HostsOUName)
+	nc.AutomountOUName = strings.ToLower(nc.AutomountOUName)
+
+	// additional ous are paths relative to base dn, e.g. ou=people,ou=eu
+	if nc.OUs == nil {
+		nc.OUs = c.OUs
+	}
+	ous := make([]string, 0, len(nc.OUs))
+	for _, ou := range nc.OUs {
+		path, err := ldaputils.NormalizeOUPath(ou, nc.BaseDN)
+		if err != nil {
+			return fmt.Errorf("wrong organizational_units of '%s': %s", nc.BaseDN, err)
+		}
+		ous = append(ous, path)
+	}
+	nc.OUs = ous
+
+	if nc.UpdateInterval == 0 {
+		nc.UpdateInterval = c.UpdateInterval
+	}
+
+	// backend data is cached per naming context
+	if len(nc.CachePath) == 0 && len(c.CacheDir) > 0 {
+		nc.CachePath = filepath.Join(c.CacheDir, url.PathEscape(nc.BaseDN)+".cache")
+	}
+
+	return nil
+}
+
+// init sets default objectClasses and attributes of schema mode
+func (s *GroupSchema) init() error {
+	var objectClasses, attributes []string
+	switch s.Mode {
+	case "", GroupSchemaBackend:
+		s.Mode = GroupSchemaBackend
+	case GroupSchemaRFC2307:
+		objectClasses = []string{"top", "posixGroup"}
+		attributes = []string{"memberUid", "memberOf"}
+	case GroupSchemaRFC2307bis:
+		objectClasses = []string{"top", "posixGroup", "groupOfNames"}
+		attributes = []string{"memberUid", "member", "memberOf"}
+	default:
+		return fmt.Errorf("wrong group_schema mode '%s': must be '%s', '%s' or '%s'", s.Mode, GroupSchemaBackend, GroupSchemaRFC2307, GroupSchemaRFC2307bis)
+	}
+
+	if len(s.ObjectClasses) == 0 {
+		s.ObjectClasses = objectClasses
+	}
+
+	if len(s.Attributes) == 0 {
+		s.Attributes = attributes
+	}
+	for _, attr := range s.Attributes {
+		var found bool
+		for _, a := range groupSchemaAttributes {
+			if strings.EqualFold(attr, a) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return fmt.Errorf("wrong group_schema attribute '%s': must be one of %s", attr, strings.Join(groupSchemaAttributes, ", "))
+		}
+	}
+
+	return nil
+}
+
+// HasAttribute returns true if attribute 'attr' is computed
+func (s GroupSchema) HasAttribute(attr string) bool {
+	for _, a := range s.Attributes {
+		if strings.EqualFold(a, attr) {
+			return true
+		}
+	}
+	return false
+}
+
+// Lookup returns definition of attribute 'name'
+func (s Schema) Lookup(name string) (AttributeType, bool) {
+	if t, ok := s.Attributes[strings.ToLower(name)]; ok {
+		return t, true
+	}
+	return AttributeType{}, s.AllowUndefined
+}
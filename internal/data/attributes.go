@@ -0,0 +1,236 @@
+package data
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+)
+
+// Attributes are entry attributes without struct fields, keyed by attribute name as returned by backend
+type Attributes map[string][]string
+
+// RawAttribute is an attribute which values are not scalars or arrays of scalars, it is not served
+// and its JSON value is kept to write entry back to backend
+type RawAttribute struct {
+	Name  string
+	Value json.RawMessage
+}
+
+// Get returns attribute name as stored and values of attribute 'name'
+func (a Attributes) Get(name string) (string, []string, bool) {
+	for k, v := range a {
+		if strings.EqualFold(k, name) {
+			return k, v, true
+		}
+	}
+	return "", nil, false
+}
+
+// Set replaces values of attribute 'name', attribute is removed if 'values' are empty
+func (a *Attributes) Set(name string, values []string) {
+	if k, _, found := a.Get(name); found {
+		name = k
+	}
+	if len(values) == 0 {
+		delete(*a, name)
+		if len(*a) == 0 {
+			*a = nil
+		}
+		return
+	}
+	if *a == nil {
+		*a = make(Attributes)
+	}
+	(*a)[name] = values
+}
+
+// Names returns sorted attribute names
+func (a Attributes) Names() []string {
+	names := make([]string, 0, len(a))
+	for k := range a {
+		names = append(names, k)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Copy returns a copy of attributes
+func (a Attributes) Copy() Attributes {
+	if a == nil {
+		return nil
+	}
+	c := make(Attributes, len(a))
+	for k, v := range a {
+		c[k] = append([]string(nil), v...)
+	}
+	return c
+}
+
+func (u *User) UnmarshalJSON(b []byte) error {
+	type user User
+	if err := json.Unmarshal(b, (*user)(u)); err != nil {
+		return err
+	}
+	attrs, invalid, err := unmarshalAttributes(b, reflect.TypeOf(*u))
+	u.Attributes, u.InvalidAttributes = attrs, invalid
+	return err
+}
+
+func (u User) MarshalJSON() ([]byte, error) {
+	type user User
+	return marshalAttributes((user)(u), u.Attributes, u.InvalidAttributes)
+}
+
+func (g *Group) UnmarshalJSON(b []byte) error {
+	type group Group
+	if err := json.Unmarshal(b, (*group)(g)); err != nil {
+		return err
+	}
+	attrs, invalid, err := unmarshalAttributes(b, reflect.TypeOf(*g))
+	g.Attributes, g.InvalidAttributes = attrs, invalid
+	return err
+}
+
+func (g Group) MarshalJSON() ([]byte, error) {
+	type group Group
+	return marshalAttributes((group)(g), g.Attributes, g.InvalidAttributes)
+}
+
+// unmarshalAttributes returns attributes of JSON object 'b' which are not fields of struct type 't'
+// scalar values are converted to strings, attributes with other values are returned as invalid sorted by name
+func unmarshalAttributes(b []byte, t reflect.Type) (Attributes, []RawAttribute, error) {
+	var object map[string]json.RawMessage
+	if err := json.Unmarshal(b, &object); err != nil {
+		return nil, nil, err
+	}
+
+	var attrs Attributes
+	var invalid []RawAttribute
+	for k, raw := range object {
+		if isStructField(t, k) {
+			continue
+		}
+
+		var values []string
+		var list []json.RawMessage
+		if err := json.Unmarshal(raw, &list); err != nil {
+			list = []json.RawMessage{raw}
+		}
+		var err error
+		for _, item := range list {
+			var v string
+			var ok bool
+			if v, ok, err = unmarshalValue(item); err != nil {
+				break
+			}
+			if ok {
+				values = append(values, v)
+			}
+		}
+		if err == errNotScalar {
+			invalid = append(invalid, RawAttribute{Name: k, Value: raw})
+			continue
+		}
+		if err != nil {
+			return nil, nil, fmt.Errorf("error unmarshalling attribute '%s': %s", k, err)
+		}
+
+		attrs.Set(k, values)
+	}
+	sort.Slice(invalid, func(i, j int) bool { return invalid[i].Name < invalid[j].Name })
+
+	return attrs, invalid, nil
+}
+
+var errNotScalar = errors.New("value is not a string, number or boolean")
+
+// unmarshalValue returns JSON value 'raw' as string, null values are skipped
+func unmarshalValue(raw json.RawMessage) (string, bool, error) {
+	var v interface{}
+	if err := json.Unmarshal(raw, &v); err != nil {
+		return "", false, err
+	}
+	switch v := v.(type) {
+	case nil:
+		return "", false, nil
+	case string:
+		return v, true, nil
+	case bool:
+		if v {
+			return "TRUE", true, nil
+		}
+		return "FALSE", true, nil
+	case float64:
+		// numbers as is
+		return string(raw), true, nil
+	}
+	return "", false, errNotScalar
+}
+
+// marshalAttributes returns JSON object of struct 'v' followed by attributes 'attrs' and invalid attributes 'invalid'
+func marshalAttributes(v interface{}, attrs Attributes, invalid []RawAttribute) ([]byte, error) {
+	b, err := json.Marshal(v)
+	if err != nil || (len(attrs) == 0 && len(invalid) == 0) {
+		return b, err
+	}
+
+	// keep struct fields order, append attributes sorted by name
+	var buf bytes.Buffer
+	buf.Write(bytes.TrimSuffix(b, []byte("}")))
+	for i, k := range attrs.Names() {
+		if i > 0 || len(b) > 2 {
+			buf.WriteByte(',')
+		}
+		key, err := json.Marshal(k)
+		if err != nil {
+			return nil, err
+		}
+		values := attrs[k]
+		if values == nil {
+			values = []string{}
+		}
+		raw, err := json.Marshal(values)
+		if err != nil {
+			return nil, err
+		}
+		buf.Write(key)
+		buf.WriteByte(':')
+		buf.Write(raw)
+	}
+	for i, a := range invalid {
+		if i > 0 || len(attrs) > 0 || len(b) > 2 {
+			buf.WriteByte(',')
+		}
+		key, err := json.Marshal(a.Name)
+		if err != nil {
+			return nil, err
+		}
+		buf.Write(key)
+		buf.WriteByte(':')
+		buf.Write(a.Value)
+	}
+	buf.WriteByte('}')
+
+	return buf.Bytes(), nil
+}
+
+// isStructField returns true if JSON key 'key' is decoded into field of struct type 't'
+func isStructField(t reflect.Type, key string) bool {
+	for i := 0; i < t.NumField(); i++ {
+		name, _, _ := strings.Cut(t.Field(i).Tag.Get("json"), ",")
+		if name == "-" {
+			continue
+		}
+		if len(name) == 0 {
+			name = t.Field(i).Name
+		}
+		if strings.EqualFold(name, key) {
+			return true
+		}
+	}
+	return false
+}
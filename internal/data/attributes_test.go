@@ -0,0 +1,52 @@
+package data
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+)
+
+func TestUserAttributesJSON(t *testing.T) {
+	b := []byte(`{"cn":"user01","title":"Engineer","employeeNumber":42,"active":true,"phones":["1",2,null],"address":{"city":"Riga"},"nested":[["a"]],"empty":null}`)
+
+	var user User
+	if err := json.Unmarshal(b, &user); err != nil {
+		t.Fatal(err)
+	}
+
+	wantAttrs := Attributes{
+		"title":          {"Engineer"},
+		"employeeNumber": {"42"},
+		"active":         {"TRUE"},
+		"phones":         {"1", "2"},
+	}
+	if !reflect.DeepEqual(user.Attributes, wantAttrs) {
+		t.Errorf("attributes = %v, want %v", user.Attributes, wantAttrs)
+	}
+
+	wantInvalid := []RawAttribute{
+		{Name: "address", Value: json.RawMessage(`{"city":"Riga"}`)},
+		{Name: "nested", Value: json.RawMessage(`[["a"]]`)},
+	}
+	if !reflect.DeepEqual(user.InvalidAttributes, wantInvalid) {
+		t.Errorf("invalid attributes = %v, want %v", user.InvalidAttributes, wantInvalid)
+	}
+
+	// invalid attributes are written back as is
+	out, err := json.Marshal(user)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var object map[string]json.RawMessage
+	if err := json.Unmarshal(out, &object); err != nil {
+		t.Fatal(err)
+	}
+	for _, a := range wantInvalid {
+		if string(object[a.Name]) != string(a.Value) {
+			t.Errorf("marshalled %s = %s, want %s", a.Name, object[a.Name], a.Value)
+		}
+	}
+	if string(object["phones"]) != `["1","2"]` {
+		t.Errorf("marshalled phones = %s", object["phones"])
+	}
+}
@@ -1,6 +1,9 @@
 package data
 
-import "sync"
+import (
+	"sync/atomic"
+	"time"
+)
 
 type DSE struct {
 	ObjectClass          []string `json:"objectClass"`
@@ -13,6 +16,11 @@ type DSE struct {
 type Domain struct {
 	EntryUUID       string   `json:"entryUUID,omitempty" ldap:"operational"`
 	HasSubordinates string   `json:"hasSubordinates,omitempty" ldap:"operational"`
+	CreateTimestamp string   `json:"createTimestamp,omitempty" ldap:"operational,generalized_time"`
+	ModifyTimestamp string   `json:"modifyTimestamp,omitempty" ldap:"operational,generalized_time"`
+	CreatorsName    string   `json:"creatorsName,omitempty" ldap:"operational,dn"`
+	ModifiersName   string   `json:"modifiersName,omitempty" ldap:"operational,dn"`
+	EntryCSN        string   `json:"entryCSN,omitempty" ldap:"operational"`
 	ObjectClass     []string `json:"objectClass,omitempty"`
 	DC              string   `ldap:"skip"`
 }
@@ -20,43 +28,250 @@ type Domain struct {
 type OU struct {
 	EntryUUID       string   `json:"entryUUID,omitempty" ldap:"operational"`
 	HasSubordinates string   `json:"hasSubordinates,omitempty" ldap:"operational"`
+	CreateTimestamp string   `json:"createTimestamp,omitempty" ldap:"operational,generalized_time"`
+	ModifyTimestamp string   `json:"modifyTimestamp,omitempty" ldap:"operational,generalized_time"`
+	CreatorsName    string   `json:"creatorsName,omitempty" ldap:"operational,dn"`
+	ModifiersName   string   `json:"modifiersName,omitempty" ldap:"operational,dn"`
+	EntryCSN        string   `json:"entryCSN,omitempty" ldap:"operational"`
 	ObjectClass     []string `json:"objectClass,omitempty"`
 	OU              string   `ldap:"skip"`
+	Parent          string   `ldap:"skip"` // parent ous relative to base dn, e.g. ou=eu
 }
 
 type User struct {
-	LDAPAdmin       bool     `json:"ldapAdmin,omitempty" ldap:"skip"`
+	LDAPAdmin         bool           `json:"ldapAdmin,omitempty" ldap:"skip"`
+	AccountDisabled   bool           `json:"accountDisabled,omitempty" ldap:"skip"`
+	EntryUUID         string         `json:"entryUUID,omitempty" ldap:"operational"`
+	HasSubordinates   string         `json:"hasSubordinates,omitempty" ldap:"operational"`
+	CreateTimestamp   string         `json:"createTimestamp,omitempty" ldap:"operational,generalized_time"`
+	ModifyTimestamp   string         `json:"modifyTimestamp,omitempty" ldap:"operational,generalized_time"`
+	CreatorsName      string         `json:"creatorsName,omitempty" ldap:"operational,dn"`
+	ModifiersName     string         `json:"modifiersName,omitempty" ldap:"operational,dn"`
+	EntryCSN          string         `json:"entryCSN,omitempty" ldap:"operational"`
+	ObjectClass       []string       `json:"objectClass,omitempty"`
+	CN                string         `json:"cn,omitempty"`
+	UIDNumber         uint           `json:"uidNumber,omitempty"`
+	UserPassword      string         `json:"userPassword,omitempty" ldap:"case_sensitive_value"`
+	GIDNumber         uint           `json:"gidNumber,omitempty"`
+	UID               string         `json:"uid,omitempty"`
+	DisplayName       string         `json:"displayName,omitempty"`
+	GivenName         string         `json:"givenName,omitempty"`
+	SN                string         `json:"sn,omitempty"`
+	Mail              string         `json:"mail,omitempty"`
+	HomeDirectory     string         `json:"homeDirectory,omitempty"`
+	LoginShell        string         `json:"loginShell,omitempty"`
+	ShadowLastChange  *int           `json:"shadowLastChange,omitempty"` // days since epoch
+	ShadowMax         *int           `json:"shadowMax,omitempty"`
+	ShadowWarning     *int           `json:"shadowWarning,omitempty"`
+	ShadowInactive    *int           `json:"shadowInactive,omitempty"`
+	ShadowExpire      *int           `json:"shadowExpire,omitempty"` // days since epoch
+	SSHPublicKey      []string       `json:"sshPublicKey,omitempty" ldap:"case_sensitive_value"`
+	MemberOf          []string       `json:"memberOf,omitempty" ldap:"dn"`
+	ParentOU          string         `json:"parentOU,omitempty" ldap:"skip"`
+	BackendMemberOf   []string       `json:"-" ldap:"skip"`
+	BackendObjClass   []string       `json:"-" ldap:"skip"`
+	BackendHasSubs    string         `json:"-" ldap:"skip"`
+	BackendUUID       string         `json:"-" ldap:"skip"`
+	BackendTimestamps Timestamps     `json:"-" ldap:"skip"`
+	Attributes        Attributes     `json:"-" ldap:"skip"`
+	InvalidAttributes []RawAttribute `json:"-" ldap:"skip"`
+}
+
+type Group struct {
+	EntryUUID         string         `json:"entryUUID,omitempty" ldap:"operational"`
+	HasSubordinates   string         `json:"hasSubordinates,omitempty" ldap:"operational"`
+	CreateTimestamp   string         `json:"createTimestamp,omitempty" ldap:"operational,generalized_time"`
+	ModifyTimestamp   string         `json:"modifyTimestamp,omitempty" ldap:"operational,generalized_time"`
+	CreatorsName      string         `json:"creatorsName,omitempty" ldap:"operational,dn"`
+	ModifiersName     string         `json:"modifiersName,omitempty" ldap:"operational,dn"`
+	EntryCSN          string         `json:"entryCSN,omitempty" ldap:"operational"`
+	ObjectClass       []string       `json:"objectClass,omitempty"`
+	CN                string         `json:"cn,omitempty"`
+	GIDNumber         uint           `json:"gidNumber,omitempty"`
+	Description       string         `json:"description,omitempty"`
+	MemberUID         []string       `json:"memberUid,omitempty"`
+	MemberGroup       []string       `json:"memberGroup,omitempty"`
+	MemberURL         []string       `json:"memberURL,omitempty"`
+	Member            []string       `json:"member,omitempty" ldap:"virtual,dn" chain:"TransitiveMember"`
+	UniqueMember      []string       `json:"uniqueMember,omitempty" ldap:"virtual,dn" chain:"TransitiveMember"`
+	MemberOf          []string       `json:"memberOf,omitempty" ldap:"virtual,dn"`
+	ParentOU          string         `json:"parentOU,omitempty" ldap:"skip"`
+	TransitiveMember  []string       `json:"-" ldap:"skip"`
+	BackendMemberUID  []string       `json:"-" ldap:"skip"`
+	BackendObjClass   []string       `json:"-" ldap:"skip"`
+	BackendHasSubs    string         `json:"-" ldap:"skip"`
+	BackendUUID       string         `json:"-" ldap:"skip"`
+	BackendTimestamps Timestamps     `json:"-" ldap:"skip"`
+	Attributes        Attributes     `json:"-" ldap:"skip"`
+	InvalidAttributes []RawAttribute `json:"-" ldap:"skip"`
+}
+
+type SudoRole struct {
 	EntryUUID       string   `json:"entryUUID,omitempty" ldap:"operational"`
 	HasSubordinates string   `json:"hasSubordinates,omitempty" ldap:"operational"`
+	CreateTimestamp string   `json:"createTimestamp,omitempty" ldap:"operational,generalized_time"`
+	ModifyTimestamp string   `json:"modifyTimestamp,omitempty" ldap:"operational,generalized_time"`
+	CreatorsName    string   `json:"creatorsName,omitempty" ldap:"operational,dn"`
+	ModifiersName   string   `json:"modifiersName,omitempty" ldap:"operational,dn"`
+	EntryCSN        string   `json:"entryCSN,omitempty" ldap:"operational"`
 	ObjectClass     []string `json:"objectClass,omitempty"`
 	CN              string   `json:"cn,omitempty"`
-	UIDNumber       uint     `json:"uidNumber,omitempty"`
-	UserPassword    string   `json:"userPassword,omitempty" ldap:"case_sensitive_value"`
-	GIDNumber       uint     `json:"gidNumber,omitempty"`
-	UID             string   `json:"uid,omitempty"`
-	DisplayName     string   `json:"displayName,omitempty"`
-	GivenName       string   `json:"givenName,omitempty"`
-	SN              string   `json:"sn,omitempty"`
-	Mail            string   `json:"mail,omitempty"`
-	HomeDirectory   string   `json:"homeDirectory,omitempty"`
-	LoginShell      string   `json:"loginShell,omitempty"`
-	MemberOf        []string `json:"memberOf,omitempty"`
+	Description     string   `json:"description,omitempty"`
+	SudoUser        []string `json:"sudoUser,omitempty"`
+	SudoHost        []string `json:"sudoHost,omitempty"`
+	SudoCommand     []string `json:"sudoCommand,omitempty" ldap:"case_sensitive_value"`
+	SudoRunAsUser   []string `json:"sudoRunAsUser,omitempty"`
+	SudoRunAsGroup  []string `json:"sudoRunAsGroup,omitempty"`
+	SudoOption      []string `json:"sudoOption,omitempty" ldap:"case_sensitive_value"`
+	SudoOrder       uint     `json:"sudoOrder,omitempty"`
+	ParentOU        string   `json:"parentOU,omitempty" ldap:"skip"`
 }
 
-type Group struct {
+type Netgroup struct {
+	EntryUUID         string   `json:"entryUUID,omitempty" ldap:"operational"`
+	HasSubordinates   string   `json:"hasSubordinates,omitempty" ldap:"operational"`
+	CreateTimestamp   string   `json:"createTimestamp,omitempty" ldap:"operational,generalized_time"`
+	ModifyTimestamp   string   `json:"modifyTimestamp,omitempty" ldap:"operational,generalized_time"`
+	CreatorsName      string   `json:"creatorsName,omitempty" ldap:"operational,dn"`
+	ModifiersName     string   `json:"modifiersName,omitempty" ldap:"operational,dn"`
+	EntryCSN          string   `json:"entryCSN,omitempty" ldap:"operational"`
+	ObjectClass       []string `json:"objectClass,omitempty"`
+	CN                string   `json:"cn,omitempty"`
+	Description       string   `json:"description,omitempty"`
+	NisNetgroupTriple []string `json:"nisNetgroupTriple,omitempty" ldap:"case_sensitive_value"`
+	MemberNisNetgroup []string `json:"memberNisNetgroup,omitempty"`
+	ParentOU          string   `json:"parentOU,omitempty" ldap:"skip"`
+}
+
+type Host struct {
 	EntryUUID       string   `json:"entryUUID,omitempty" ldap:"operational"`
 	HasSubordinates string   `json:"hasSubordinates,omitempty" ldap:"operational"`
+	CreateTimestamp string   `json:"createTimestamp,omitempty" ldap:"operational,generalized_time"`
+	ModifyTimestamp string   `json:"modifyTimestamp,omitempty" ldap:"operational,generalized_time"`
+	CreatorsName    string   `json:"creatorsName,omitempty" ldap:"operational,dn"`
+	ModifiersName   string   `json:"modifiersName,omitempty" ldap:"operational,dn"`
+	EntryCSN        string   `json:"entryCSN,omitempty" ldap:"operational"`
 	ObjectClass     []string `json:"objectClass,omitempty"`
-	CN              string   `json:"cn,omitempty"`
-	GIDNumber       uint     `json:"gidNumber,omitempty"`
+	CN              []string `json:"cn,omitempty"`
+	IPHostNumber    []string `json:"ipHostNumber,omitempty"`
 	Description     string   `json:"description,omitempty"`
-	MemberUID       []string `json:"memberUid,omitempty"`
+	ParentOU        string   `json:"parentOU,omitempty" ldap:"skip"`
+}
+
+type AutomountMap struct {
+	EntryUUID        string      `json:"entryUUID,omitempty" ldap:"operational"`
+	HasSubordinates  string      `json:"hasSubordinates,omitempty" ldap:"operational"`
+	CreateTimestamp  string      `json:"createTimestamp,omitempty" ldap:"operational,generalized_time"`
+	ModifyTimestamp  string      `json:"modifyTimestamp,omitempty" ldap:"operational,generalized_time"`
+	CreatorsName     string      `json:"creatorsName,omitempty" ldap:"operational,dn"`
+	ModifiersName    string      `json:"modifiersName,omitempty" ldap:"operational,dn"`
+	EntryCSN         string      `json:"entryCSN,omitempty" ldap:"operational"`
+	ObjectClass      []string    `json:"objectClass,omitempty"`
+	AutomountMapName string      `json:"automountMapName,omitempty"`
+	Description      string      `json:"description,omitempty"`
+	Automounts       []Automount `json:"automounts,omitempty" ldap:"skip"`
+	ParentOU         string      `json:"parentOU,omitempty" ldap:"skip"`
+}
+
+type Automount struct {
+	EntryUUID            string   `json:"entryUUID,omitempty" ldap:"operational"`
+	HasSubordinates      string   `json:"hasSubordinates,omitempty" ldap:"operational"`
+	CreateTimestamp      string   `json:"createTimestamp,omitempty" ldap:"operational,generalized_time"`
+	ModifyTimestamp      string   `json:"modifyTimestamp,omitempty" ldap:"operational,generalized_time"`
+	CreatorsName         string   `json:"creatorsName,omitempty" ldap:"operational,dn"`
+	ModifiersName        string   `json:"modifiersName,omitempty" ldap:"operational,dn"`
+	EntryCSN             string   `json:"entryCSN,omitempty" ldap:"operational"`
+	ObjectClass          []string `json:"objectClass,omitempty"`
+	AutomountKey         string   `json:"automountKey,omitempty" ldap:"case_sensitive_value"`
+	AutomountInformation string   `json:"automountInformation,omitempty" ldap:"case_sensitive_value"`
+	Description          string   `json:"description,omitempty"`
+	Parent               string   `json:"-" ldap:"skip"` // map dn relative to base dn
+}
+
+// Timestamps are operational attributes of entry changes
+type Timestamps struct {
+	CreateTimestamp string
+	ModifyTimestamp string
+	CreatorsName    string
+	ModifiersName   string
+	EntryCSN        string
+}
+
+// SavedTimestamps are timestamps of entry saved with hash of its values, they are kept after restart if entry is not changed
+type SavedTimestamps struct {
+	Hash string
+	Timestamps
+}
+
+// Index is a lookup index of entries, it is built on every data load
+type Index struct {
+	// entries with their dns in listing order
+	Names   []string
+	Objects []interface{}
+	// positions of entries by normalized dn and by attribute and its normalized value
+	ByDN    map[string]int
+	ByValue map[string]map[string][]int
 }
 
 type Entries struct {
-	Domain Domain
-	OUs    []OU
-	Users  []User
-	Groups []Group
-	sync.RWMutex
+	Domain        Domain
+	OUs           []OU
+	Users         []User
+	Groups        []Group
+	SudoRoles     []SudoRole
+	Netgroups     []Netgroup
+	Hosts         []Host
+	AutomountMaps []AutomountMap
+	Automounts    []Automount
+	Index         *Index `json:"-"`
+	// version of backend data users and groups are loaded from, empty if backend provides no changes
+	Token string
+	// entries are loaded from cache as backend data could not be loaded
+	Stale bool `json:"-"`
+	// time backend data was loaded, time cache was saved for stale entries
+	LoadedAt time.Time `json:"-"`
+}
+
+// Copy returns a copy of entries with copied lists, so entries of the copy may be changed without changing 'e'
+func (e *Entries) Copy() *Entries {
+	c := *e
+	c.OUs = append([]OU(nil), e.OUs...)
+	c.Users = append([]User(nil), e.Users...)
+	c.Groups = append([]Group(nil), e.Groups...)
+	c.SudoRoles = append([]SudoRole(nil), e.SudoRoles...)
+	c.Netgroups = append([]Netgroup(nil), e.Netgroups...)
+	c.Hosts = append([]Host(nil), e.Hosts...)
+	c.AutomountMaps = append([]AutomountMap(nil), e.AutomountMaps...)
+	c.Automounts = append([]Automount(nil), e.Automounts...)
+	return &c
+}
+
+// Changes are users and groups changed and deleted since backend data version set by token, deleted entries are set by their cn
+// all users and groups are returned with Full set, e.g. for empty token or token which is not known by backend anymore
+type Changes struct {
+	Users         []User
+	Groups        []Group
+	DeletedUsers  []string
+	DeletedGroups []string
+	Full          bool
+	// version of backend data with changes applied
+	Token string
+}
+
+// Snapshot holds current entries of naming context, entries are never modified after they are stored,
+// data loads store new entries instead, so readers keep consistent view of entries they have loaded
+type Snapshot struct {
+	v atomic.Value
+}
+
+// Load returns current entries
+func (s *Snapshot) Load() *Entries {
+	e, _ := s.v.Load().(*Entries)
+	return e
+}
+
+// Store replaces current entries with 'e' and returns previous ones
+func (s *Snapshot) Store(e *Entries) *Entries {
+	prev, _ := s.v.Swap(e).(*Entries)
+	return prev
 }
@@ -1,11 +1,13 @@
 package http
 
 import (
+	"fmt"
 	"path"
 	"strings"
 	"time"
 
 	"github.com/fasthttp/router"
+	"github.com/ps78674/gorestldap/internal/ldap"
 	"github.com/ps78674/gorestldap/internal/ticker"
 	"github.com/sirupsen/logrus"
 	"github.com/valyala/fasthttp"
@@ -14,21 +16,22 @@ import (
 
 const (
 	callbackPath = "/callback"
+	metricsPath  = "/metrics"
 	pprofPath    = "/debug/pprof"
 )
 
 // NewServer resturn new fasthttp server
-func NewServer(callbackAuthToken string, ticker *ticker.Ticker, logger *logrus.Logger) *fasthttp.Server {
+func NewServer(callbackAuthToken string, tickers []*ticker.Ticker, contexts []*ldap.NamingContext, logger *logrus.Logger) *fasthttp.Server {
 	return &fasthttp.Server{
 		ReadTimeout:  5 * time.Second,
 		WriteTimeout: 15 * time.Second,
 		IdleTimeout:  120 * time.Second,
-		Handler:      newRouter(callbackAuthToken, ticker, logger).Handler,
+		Handler:      newRouter(callbackAuthToken, tickers, contexts, logger).Handler,
 	}
 }
 
-// newRouter creates new router for callback & pprof
-func newRouter(callbackAuthToken string, ticker *ticker.Ticker, logger *logrus.Logger) *router.Router {
+// newRouter creates new router for callback, metrics & pprof
+func newRouter(callbackAuthToken string, tickers []*ticker.Ticker, contexts []*ldap.NamingContext, logger *logrus.Logger) *router.Router {
 	r := router.New()
 	r.MethodNotAllowed = func(ctx *fasthttp.RequestCtx) {
 		ctx.Response.SetStatusCode(fasthttp.StatusMethodNotAllowed)
@@ -39,7 +42,11 @@ func newRouter(callbackAuthToken string, ticker *ticker.Ticker, logger *logrus.L
 		logRequest(ctx, logger)
 	}
 	r.HEAD(callbackPath, func(ctx *fasthttp.RequestCtx) {
-		handleCallback(ctx, callbackAuthToken, ticker)
+		handleCallback(ctx, callbackAuthToken, tickers)
+		logRequest(ctx, logger)
+	})
+	r.GET(metricsPath, func(ctx *fasthttp.RequestCtx) {
+		handleMetrics(ctx, contexts)
 		logRequest(ctx, logger)
 	})
 	r.GET(path.Join(pprofPath, "{profile:*}"), func(ctx *fasthttp.RequestCtx) {
@@ -49,7 +56,7 @@ func newRouter(callbackAuthToken string, ticker *ticker.Ticker, logger *logrus.L
 	return r
 }
 
-func handleCallback(ctx *fasthttp.RequestCtx, callbackAuthToken string, ticker *ticker.Ticker) {
+func handleCallback(ctx *fasthttp.RequestCtx, callbackAuthToken string, tickers []*ticker.Ticker) {
 	if !ctx.IsHead() {
 		ctx.Response.SetStatusCode(fasthttp.StatusBadRequest)
 		return
@@ -62,7 +69,33 @@ func handleCallback(ctx *fasthttp.RequestCtx, callbackAuthToken string, ticker *
 		return
 	}
 
-	ticker.Reset()
+	// update data of all naming contexts
+	for _, t := range tickers {
+		t.Reset()
+	}
+
+	ctx.Response.SetStatusCode(fasthttp.StatusOK)
+}
+
+// handleMetrics writes data state of naming contexts in Prometheus text format
+func handleMetrics(ctx *fasthttp.RequestCtx, contexts []*ldap.NamingContext) {
+	ctx.SetContentType("text/plain; version=0.0.4; charset=utf-8")
+
+	fmt.Fprintln(ctx, "# HELP gorestldap_data_stale Whether data is served from cache as backend data could not be loaded.")
+	fmt.Fprintln(ctx, "# TYPE gorestldap_data_stale gauge")
+	for _, nc := range contexts {
+		var stale int
+		if nc.Entries.Load().Stale {
+			stale = 1
+		}
+		fmt.Fprintf(ctx, "gorestldap_data_stale{base_dn=%q} %d\n", nc.BaseDN, stale)
+	}
+
+	fmt.Fprintln(ctx, "# HELP gorestldap_data_last_load_timestamp_seconds Time of last successful backend data load.")
+	fmt.Fprintln(ctx, "# TYPE gorestldap_data_last_load_timestamp_seconds gauge")
+	for _, nc := range contexts {
+		fmt.Fprintf(ctx, "gorestldap_data_last_load_timestamp_seconds{base_dn=%q} %d\n", nc.BaseDN, nc.Entries.Load().LoadedAt.Unix())
+	}
 
 	ctx.Response.SetStatusCode(fasthttp.StatusOK)
 }
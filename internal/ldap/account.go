@@ -0,0 +1,59 @@
+package ldap
+
+import (
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/ps78674/gorestldap/internal/data"
+)
+
+// shadowAccount attributes which only admins can modify, lowercase
+var accountPolicyAttributes = []string{
+	"shadowmax",
+	"shadowwarning",
+	"shadowinactive",
+	"shadowexpire",
+	"nsaccountlock",
+}
+
+// isAccountPolicyAttribute returns true if attribute 'attrName' sets account expiry
+func isAccountPolicyAttribute(attrName string) bool {
+	attrType := getAttributeType(attrName)
+	for _, a := range accountPolicyAttributes {
+		if strings.EqualFold(attrType, a) {
+			return true
+		}
+	}
+	return false
+}
+
+// checkAccount returns error if account of user 'user' is disabled or expired at time 'now', see shadow(5)
+// account is disabled with accountDisabled field or nsAccountLock attribute (389-ds style) set to TRUE
+func checkAccount(user data.User, now time.Time) error {
+	if user.AccountDisabled {
+		return errors.New("account is disabled")
+	}
+	if _, values, ok := user.Attributes.Get("nsAccountLock"); ok && len(values) > 0 && strings.EqualFold(values[0], "TRUE") {
+		return errors.New("account is locked")
+	}
+
+	// shadow dates are days since epoch
+	today := int(now.Unix() / 86400)
+
+	// 0 and -1 mean account never expires
+	if user.ShadowExpire != nil && *user.ShadowExpire > 0 && today >= *user.ShadowExpire {
+		return errors.New("account is expired")
+	}
+
+	// account is locked when inactivity period after password expiry is over, -1 disables the check
+	if user.ShadowLastChange == nil || user.ShadowMax == nil || user.ShadowInactive == nil {
+		return nil
+	}
+	lastChange, maxAge, inactive := *user.ShadowLastChange, *user.ShadowMax, *user.ShadowInactive
+	if lastChange > 0 && maxAge >= 0 && inactive >= 0 && today >= lastChange+maxAge+inactive {
+		return errors.New("account is inactive since password expiry")
+	}
+
+	return nil
+}
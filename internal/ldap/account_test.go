@@ -0,0 +1,57 @@
+package ldap
+
+import (
+	"testing"
+	"time"
+
+	"github.com/ps78674/gorestldap/internal/data"
+)
+
+func TestCheckAccount(t *testing.T) {
+	now := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+	today := int(now.Unix() / 86400)
+	days := func(n int) *int { return &n }
+
+	tests := []struct {
+		name    string
+		user    data.User
+		wantErr bool
+	}{
+		{"active", data.User{}, false},
+		{"disabled", data.User{AccountDisabled: true}, true},
+		{"locked", data.User{Attributes: data.Attributes{"nsAccountLock": {"TRUE"}}}, true},
+		{"locked lowercase", data.User{Attributes: data.Attributes{"nsaccountlock": {"true"}}}, true},
+		{"not locked", data.User{Attributes: data.Attributes{"nsAccountLock": {"FALSE"}}}, false},
+		{"expired", data.User{ShadowExpire: days(today)}, true},
+		{"expires tomorrow", data.User{ShadowExpire: days(today + 1)}, false},
+		{"never expires", data.User{ShadowExpire: days(-1)}, false},
+		{"expiry disabled", data.User{ShadowExpire: days(0)}, false},
+		{"inactive", data.User{ShadowLastChange: days(today - 40), ShadowMax: days(30), ShadowInactive: days(10)}, true},
+		{"in inactivity period", data.User{ShadowLastChange: days(today - 35), ShadowMax: days(30), ShadowInactive: days(10)}, false},
+		{"inactivity disabled", data.User{ShadowLastChange: days(today - 400), ShadowMax: days(30), ShadowInactive: days(-1)}, false},
+		{"no inactivity period", data.User{ShadowLastChange: days(today - 400), ShadowMax: days(30)}, false},
+		{"password change required", data.User{ShadowLastChange: days(0), ShadowMax: days(30), ShadowInactive: days(0)}, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := checkAccount(tt.user, now); (err != nil) != tt.wantErr {
+				t.Errorf("err = %v, want error %t", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestIsAccountPolicyAttribute(t *testing.T) {
+	for attr, want := range map[string]bool{
+		"shadowExpire":       true,
+		"SHADOWMAX;x-option": true,
+		"nsAccountLock":      true,
+		"shadowLastChange":   false,
+		"sshPublicKey":       false,
+	} {
+		if got := isAccountPolicyAttribute(attr); got != want {
+			t.Errorf("isAccountPolicyAttribute(%q) = %t, want %t", attr, got, want)
+		}
+	}
+}
@@ -0,0 +1,367 @@
+package ldap
+
+import (
+	"fmt"
+	"reflect"
+	"regexp"
+	"strconv"
+	"strings"
+
+	ldap "github.com/ps78674/goldap/message"
+	"github.com/ps78674/gorestldap/internal/config"
+	"github.com/ps78674/gorestldap/internal/data"
+	"github.com/ps78674/gorestldap/internal/ldaputils"
+	"github.com/ps78674/gorestldap/internal/sshkey"
+)
+
+// schema of users and groups attributes which have no struct fields
+var attributesSchema config.Schema
+
+// attribute name (RFC 4512 descr)
+var attributeNameRegexp = regexp.MustCompile(`^[a-zA-Z][a-zA-Z0-9-]*$`)
+
+// syntax checks of attribute values on modify, keyed by lowercase attribute name
+var attributeValidators = map[string]func(string) error{
+	"sshpublickey": sshkey.ValidatePublicKey,
+}
+
+// SetSchema sets schema of users and groups attributes which have no struct fields
+func SetSchema(schema config.Schema) {
+	attributesSchema = schema
+}
+
+// CheckSchema returns errors of users and groups attributes which are not served according to schema or have non scalar values
+func CheckSchema(users []data.User, groups []data.Group) []error {
+	var errs []error
+	reported := make(map[string]struct{})
+	check := func(attrs data.Attributes) {
+		for _, name := range attrs.Names() {
+			key := strings.ToLower(name)
+			var err error
+			t, ok := attributesSchema.Lookup(name)
+			switch {
+			case !attributeNameRegexp.MatchString(name):
+				err = fmt.Errorf("attribute '%s' is not served: wrong attribute name", name)
+			case !ok:
+				err = fmt.Errorf("attribute '%s' is not served: attribute is not defined in schema", name)
+			case t.SingleValue && len(attrs[name]) > 1:
+				key += ";single"
+				err = fmt.Errorf("attribute '%s' is single valued, only first value is served", name)
+			}
+			if err == nil {
+				continue
+			}
+			if _, ok := reported[key]; ok {
+				continue
+			}
+			reported[key] = struct{}{}
+			errs = append(errs, err)
+		}
+	}
+
+	checkInvalid := func(invalid []data.RawAttribute) {
+		for _, a := range invalid {
+			key := strings.ToLower(a.Name) + ";invalid"
+			if _, ok := reported[key]; ok {
+				continue
+			}
+			reported[key] = struct{}{}
+			errs = append(errs, fmt.Errorf("attribute '%s' is not served: values must be strings, numbers, booleans or arrays of them", a.Name))
+		}
+	}
+
+	for _, user := range users {
+		check(user.Attributes)
+		checkInvalid(user.InvalidAttributes)
+	}
+	for _, group := range groups {
+		check(group.Attributes)
+		checkInvalid(group.InvalidAttributes)
+	}
+
+	return errs
+}
+
+// attribute is an entry attribute with its values and properties
+type attribute struct {
+	// name as it is served
+	name   string
+	values []string
+	// struct field name, empty for attributes without struct fields
+	field           string
+	numeric         bool
+	caseSensitive   bool
+	dn              bool
+	singleValue     bool
+	generalizedTime bool
+}
+
+// getAttribute returns attribute 'attrName' of object 'o'
+func getAttribute(o interface{}, attrName string) (attribute, bool) {
+	field, found := reflect.TypeOf(o).FieldByNameFunc(func(s string) bool { return strings.EqualFold(s, attrName) })
+	if found {
+		if tagValueContains(field.Tag, "ldap", "skip") {
+			return attribute{}, false
+		}
+		return newFieldAttribute(o, field), true
+	}
+
+	attrs, ok := getAttributes(o)
+	if !ok {
+		return attribute{}, false
+	}
+	name, values, found := attrs.Get(attrName)
+	if !found {
+		return attribute{}, false
+	}
+	return newSchemaAttribute(name, values)
+}
+
+// listAttributes returns attributes of object 'o', operational attributes are returned only if 'operational' is set
+func listAttributes(o interface{}, operational bool) []attribute {
+	var list []attribute
+
+	t := reflect.TypeOf(o)
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		if tagValueContains(field.Tag, "ldap", "skip") {
+			continue
+		}
+		if tagValueContains(field.Tag, "ldap", "operational") != operational {
+			continue
+		}
+		list = append(list, newFieldAttribute(o, field))
+	}
+
+	// attributes without struct fields are not operational
+	if attrs, ok := getAttributes(o); ok && !operational {
+		for _, name := range attrs.Names() {
+			if a, ok := newSchemaAttribute(name, attrs[name]); ok {
+				list = append(list, a)
+			}
+		}
+	}
+
+	return list
+}
+
+// newFieldAttribute returns attribute of object's 'o' struct field 'field'
+func newFieldAttribute(o interface{}, field reflect.StructField) attribute {
+	name, _, _ := strings.Cut(field.Tag.Get("json"), ",")
+	a := attribute{
+		name:            name,
+		field:           field.Name,
+		numeric:         field.Type.Kind() == reflect.Uint || field.Type == reflect.TypeOf((*int)(nil)),
+		caseSensitive:   tagValueContains(field.Tag, "ldap", "case_sensitive_value"),
+		dn:              tagValueContains(field.Tag, "ldap", "dn"),
+		singleValue:     field.Type.Kind() != reflect.Slice,
+		generalizedTime: tagValueContains(field.Tag, "ldap", "generalized_time"),
+	}
+	for _, v := range newLDAPAttributeValues(reflect.ValueOf(o).FieldByIndex(field.Index).Interface()) {
+		a.values = append(a.values, string(v))
+	}
+	return a
+}
+
+// newSchemaAttribute returns attribute 'name' without struct field if it is served according to schema
+func newSchemaAttribute(name string, values []string) (attribute, bool) {
+	if !attributeNameRegexp.MatchString(name) {
+		return attribute{}, false
+	}
+	t, ok := attributesSchema.Lookup(name)
+	if !ok {
+		return attribute{}, false
+	}
+	if t.SingleValue && len(values) > 1 {
+		values = values[:1]
+	}
+	return attribute{
+		name:          name,
+		values:        values,
+		caseSensitive: t.CaseSensitive,
+		singleValue:   t.SingleValue,
+	}, true
+}
+
+// getAttributes returns attributes of object 'o' which have no struct fields
+func getAttributes(o interface{}) (data.Attributes, bool) {
+	fieldValue := reflect.ValueOf(o).FieldByName("Attributes")
+	if !fieldValue.IsValid() {
+		return nil, false
+	}
+	attrs, ok := fieldValue.Interface().(data.Attributes)
+	return attrs, ok
+}
+
+// normalize returns value 'v' in form used for matching
+func (a attribute) normalize(v string) string {
+	if a.dn {
+		v = ldaputils.NormalizeEntry(v)
+	}
+	if !a.caseSensitive {
+		v = strings.ToLower(v)
+	}
+	return v
+}
+
+// hasValue returns true if attribute has value 'v'
+func (a attribute) hasValue(v string) bool {
+	v = a.normalize(v)
+	for _, value := range a.values {
+		if a.normalize(value) == v {
+			return true
+		}
+	}
+	return false
+}
+
+// matchOrdering returns true if any attribute value is greater or equal ('greater' is set) or less or equal to value 'v',
+// generalized time and numeric values are compared as such
+func (a attribute) matchOrdering(v string, greater bool) bool {
+	for _, value := range a.values {
+		c, ok := a.compare(value, v)
+		if !ok {
+			continue
+		}
+		if (greater && c >= 0) || (!greater && c <= 0) {
+			return true
+		}
+	}
+	return false
+}
+
+// compare returns -1, 0 or 1 if value 'x' is less, equal or greater than value 'y', false if values can not be compared
+func (a attribute) compare(x, y string) (int, bool) {
+	switch {
+	case a.generalizedTime:
+		tx, err := parseGeneralizedTime(x)
+		if err != nil {
+			return 0, false
+		}
+		ty, err := parseGeneralizedTime(y)
+		if err != nil {
+			return 0, false
+		}
+		switch {
+		case tx.Before(ty):
+			return -1, true
+		case tx.After(ty):
+			return 1, true
+		}
+		return 0, true
+	case a.numeric:
+		nx, err := strconv.ParseInt(x, 10, 64)
+		if err != nil {
+			return 0, false
+		}
+		ny, err := strconv.ParseInt(y, 10, 64)
+		if err != nil {
+			return 0, false
+		}
+		switch {
+		case nx < ny:
+			return -1, true
+		case nx > ny:
+			return 1, true
+		}
+		return 0, true
+	}
+	return strings.Compare(a.normalize(x), a.normalize(y)), true
+}
+
+// matchSubstrings returns true if any attribute value matches substrings 'substrings'
+func (a attribute) matchSubstrings(substrings []ldap.Substring) bool {
+	for _, value := range a.values {
+		value = a.normalize(value)
+
+		matched := true
+		for _, sub := range substrings {
+			switch sub := sub.(type) {
+			case ldap.SubstringInitial:
+				s := a.normalize(string(sub))
+				if !strings.HasPrefix(value, s) {
+					matched = false
+				}
+				value = strings.TrimPrefix(value, s)
+			case ldap.SubstringAny:
+				s := a.normalize(string(sub))
+				i := strings.Index(value, s)
+				if i < 0 {
+					matched = false
+					break
+				}
+				value = value[i+len(s):]
+			case ldap.SubstringFinal:
+				if !strings.HasSuffix(value, a.normalize(string(sub))) {
+					matched = false
+				}
+			}
+			if !matched {
+				break
+			}
+		}
+
+		if matched {
+			return true
+		}
+	}
+	return false
+}
+
+// matchRule returns true if any attribute value matches value 'v' by equality matching rule 'rule',
+// attribute own equality is used if rule is not set, other rules are undefined
+func (a attribute) matchRule(rule, v string) bool {
+	var match func(value string) bool
+	switch strings.ToLower(rule) {
+	case "":
+		return a.hasValue(v)
+	case "caseexactmatch", "2.5.13.5":
+		match = func(value string) bool { return value == v }
+	case "caseignorematch", "2.5.13.2":
+		match = func(value string) bool { return strings.EqualFold(value, v) }
+	case "distinguishednamematch", "2.5.13.1":
+		v = ldaputils.NormalizeEntry(v)
+		match = func(value string) bool { return ldaputils.NormalizeEntry(value) == v }
+	case "integermatch", "2.5.13.14":
+		n, err := strconv.ParseInt(v, 10, 64)
+		if err != nil {
+			return false
+		}
+		match = func(value string) bool {
+			nv, err := strconv.ParseInt(value, 10, 64)
+			return err == nil && nv == n
+		}
+	default:
+		return false
+	}
+
+	for _, value := range a.values {
+		if match(value) {
+			return true
+		}
+	}
+	return false
+}
+
+// applyAttributeFilter returns true if attribute 'a' fits simple filter 'f'
+func applyAttributeFilter(a attribute, f ldap.Filter) bool {
+	switch filter := f.(type) {
+	case ldap.FilterEqualityMatch:
+		return a.hasValue(string(filter.AssertionValue()))
+	case ldap.FilterSubstrings:
+		return a.matchSubstrings(filter.Substrings())
+	case ldap.FilterGreaterOrEqual:
+		return a.matchOrdering(string(filter.AssertionValue()), true)
+	case ldap.FilterLessOrEqual:
+		return a.matchOrdering(string(filter.AssertionValue()), false)
+	case ldap.FilterPresent:
+		return true
+	case ldap.FilterApproxMatch:
+		// there are no approximate matching rules -> equality is used instead
+		return a.hasValue(string(filter.AssertionValue()))
+	case ldap.FilterExtensibleMatch:
+		rule, _, value := readExtensibleMatch(filter)
+		return a.matchRule(rule, value)
+	}
+	return false
+}
@@ -2,7 +2,7 @@ package ldap
 
 import (
 	"fmt"
-	"reflect"
+	"time"
 
 	"github.com/ps78674/gorestldap/internal/data"
 	"github.com/ps78674/gorestldap/internal/ldaputils"
@@ -11,9 +11,8 @@ import (
 	"github.com/sirupsen/logrus"
 )
 
-func handleBind(w ldapserver.ResponseWriter, m *ldapserver.Message, entries *data.Entries, baseDN, usersOUName string, logger *logrus.Logger) {
-	entries.RLock()
-	defer entries.RUnlock()
+func handleBind(w ldapserver.ResponseWriter, m *ldapserver.Message, snapshot *data.Snapshot, baseDN, usersOUName, groupsOUName string, logger *logrus.Logger) {
+	entries := snapshot.Load()
 
 	r := m.GetBindRequest()
 	logger.Infof("client [%d]: bind dn='%s'", m.Client.Numero(), r.Name())
@@ -37,30 +36,10 @@ func handleBind(w ldapserver.ResponseWriter, m *ldapserver.Message, entries *dat
 		return
 	}
 
-	bindEntryAttr, bindEntryName, bindEntrySuffix := getEntryAttrValueSuffix(bindEntry)
-
-	userData := data.User{}
-	if bindEntrySuffix != "ou="+usersOUName+","+baseDN {
-		goto userNotFound
-	}
-
-	for _, user := range entries.Users {
-		var cmpValue string
-		switch bindEntryAttr {
-		case "cn":
-			cmpValue = user.CN
-		case "uid":
-			cmpValue = user.UID
-		}
-		if cmpValue != bindEntryName {
-			continue
-		}
-		userData = user
-	}
-
-userNotFound:
-	// got empty struct -> user not found
-	if reflect.DeepEqual(userData, data.User{}) {
+	// only users may bind
+	e, _ := findEntry(entries, bindEntry, baseDN, usersOUName, groupsOUName)
+	userData, ok := e.o.(data.User)
+	if !ok {
 		res := ldapserver.NewBindResponse(ldapserver.LDAPResultNoSuchObject)
 		w.Write(res)
 
@@ -83,15 +62,26 @@ userNotFound:
 		return
 	}
 
+	// expired and disabled accounts can not bind
+	if err := checkAccount(userData, time.Now()); err != nil {
+		res := ldapserver.NewBindResponse(ldapserver.LDAPResultInvalidCredentials)
+		res.SetDiagnosticMessage(err.Error())
+		w.Write(res)
+
+		logger.Errorf("client [%d]: bind error: dn '%s': %s", m.Client.Numero(), r.Name(), err)
+		return
+	}
+
 	// set ACLs
 	acl := clientACL{
 		bindEntry: bindEntry,
 	}
 	if userData.LDAPAdmin {
 		acl = clientACL{
-			search:  true,
-			compare: true,
-			modify:  true,
+			bindEntry: bindEntry,
+			search:    true,
+			compare:   true,
+			modify:    true,
 		}
 	}
 
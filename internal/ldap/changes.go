@@ -0,0 +1,79 @@
+package ldap
+
+import (
+	"strings"
+
+	"github.com/ps78674/gorestldap/internal/data"
+)
+
+// ApplyChanges returns backend users and groups of entries 'entries' with changes 'changes' applied
+// changed entries replace entries with the same cn or are added, deleted entries are removed
+// copies of users and groups of changes are returned if all entries are changed or there are no entries,
+// so computed attributes do not modify data kept by backend
+func ApplyChanges(entries *data.Entries, changes data.Changes) ([]data.User, []data.Group) {
+	if changes.Full || entries == nil {
+		return append([]data.User{}, changes.Users...), append([]data.Group{}, changes.Groups...)
+	}
+
+	changedUsers := make(map[string]data.User, len(changes.Users))
+	for _, user := range changes.Users {
+		changedUsers[strings.ToLower(user.CN)] = user
+	}
+	deletedUsers := make(map[string]struct{}, len(changes.DeletedUsers))
+	for _, cn := range changes.DeletedUsers {
+		deletedUsers[strings.ToLower(cn)] = struct{}{}
+	}
+
+	users := make([]data.User, 0, len(entries.Users)+len(changes.Users))
+	for _, user := range entries.Users {
+		key := strings.ToLower(user.CN)
+		if _, ok := deletedUsers[key]; ok {
+			continue
+		}
+		if changed, ok := changedUsers[key]; ok {
+			users = append(users, changed)
+			delete(changedUsers, key)
+			continue
+		}
+		users = append(users, getBackendEntry(user).(data.User))
+	}
+	for _, user := range changes.Users {
+		key := strings.ToLower(user.CN)
+		if changed, ok := changedUsers[key]; ok {
+			users = append(users, changed)
+			delete(changedUsers, key)
+		}
+	}
+
+	changedGroups := make(map[string]data.Group, len(changes.Groups))
+	for _, group := range changes.Groups {
+		changedGroups[strings.ToLower(group.CN)] = group
+	}
+	deletedGroups := make(map[string]struct{}, len(changes.DeletedGroups))
+	for _, cn := range changes.DeletedGroups {
+		deletedGroups[strings.ToLower(cn)] = struct{}{}
+	}
+
+	groups := make([]data.Group, 0, len(entries.Groups)+len(changes.Groups))
+	for _, group := range entries.Groups {
+		key := strings.ToLower(group.CN)
+		if _, ok := deletedGroups[key]; ok {
+			continue
+		}
+		if changed, ok := changedGroups[key]; ok {
+			groups = append(groups, changed)
+			delete(changedGroups, key)
+			continue
+		}
+		groups = append(groups, getBackendEntry(group).(data.Group))
+	}
+	for _, group := range changes.Groups {
+		key := strings.ToLower(group.CN)
+		if changed, ok := changedGroups[key]; ok {
+			groups = append(groups, changed)
+			delete(changedGroups, key)
+		}
+	}
+
+	return users, groups
+}
@@ -1,10 +1,9 @@
 package ldap
 
 import (
-	"fmt"
-	"reflect"
 	"strings"
 
+	ldap "github.com/ps78674/goldap/message"
 	"github.com/ps78674/gorestldap/internal/data"
 	"github.com/ps78674/gorestldap/internal/ldaputils"
 	ldapserver "github.com/ps78674/ldapserver"
@@ -12,13 +11,57 @@ import (
 )
 
 // handle compare
-func handleCompare(w ldapserver.ResponseWriter, m *ldapserver.Message, entries *data.Entries, baseDN, usersOUName, groupsOUName string, logger *logrus.Logger) {
-	entries.RLock()
-	defer entries.RUnlock()
+func handleCompare(w ldapserver.ResponseWriter, m *ldapserver.Message, snapshot *data.Snapshot, baseDN, usersOUName, groupsOUName string, respectCritical bool, logger *logrus.Logger) {
+	entries := snapshot.Load()
 
 	r := m.GetCompareRequest()
-	attrName := string(r.Ava().AttributeDesc())
-	logger.Infof("client [%d]: compare dn='%s' attr='%s'", m.Client.Numero(), r.Entry(), attrName)
+	attrDesc := string(r.Ava().AttributeDesc())
+	logger.Infof("client [%d]: compare dn='%s' attr='%s'", m.Client.Numero(), r.Entry(), attrDesc)
+
+	// attribute options are ignored as in search
+	attrName := getAttributeType(attrDesc)
+
+	// check requested controls
+	var controls []string
+	var assertion ldap.Filter
+	var gotUCControl bool
+	if m.Controls() != nil {
+		for _, c := range *m.Controls() {
+			switch c.ControlType() {
+			// 1.3.6.1.1.12 (assertion)
+			case assertionControlOID:
+				controls = append(controls, c.ControlType().String())
+				f, err := readAssertionControl(c.ControlValue())
+				if err != nil {
+					res := ldapserver.NewCompareResponse(ldapserver.LDAPResultProtocolError)
+					res.SetDiagnosticMessage(err.Error())
+					w.Write(res)
+
+					logger.Errorf("client [%d]: error decoding assertionControl: %s", m.Client.Numero(), err)
+					return
+				}
+				assertion = f
+			default:
+				if c.Criticality().Bool() {
+					controls = append(controls, c.ControlType().String()+"(U,C)")
+					gotUCControl = true
+				} else {
+					controls = append(controls, c.ControlType().String()+"(U)")
+				}
+			}
+		}
+	}
+
+	logger.Infof("client [%d]: compare ctrl=%s", m.Client.Numero(), strings.Join(controls, " "))
+
+	// check for unsupported critical controls
+	if gotUCControl && respectCritical {
+		res := ldapserver.NewCompareResponse(ldapserver.LDAPResultUnavailableCriticalExtension)
+		w.Write(res)
+
+		logger.Errorf("client [%d]: compare error: got unsupported critical controls, aborting", m.Client.Numero())
+		return
+	}
 
 	// check compare entry dn
 	compareEntry := ldaputils.NormalizeEntry(string(r.Entry()))
@@ -42,10 +85,7 @@ func handleCompare(w ldapserver.ResponseWriter, m *ldapserver.Message, entries *
 	}
 
 	// get ACLs
-	acl := clientACL{}
-	if addData := m.Client.GetAddData(); addData != nil {
-		acl = addData.(additionalData).acl
-	}
+	acl := getClientACL(m, baseDN)
 
 	// non-admin can only compare by own entry
 	if !acl.compare && compareEntry != acl.bindEntry {
@@ -57,68 +97,8 @@ func handleCompare(w ldapserver.ResponseWriter, m *ldapserver.Message, entries *
 	}
 
 	var entry interface{}
-	compareEntryAttr, compareEntryName, compareEntrySuffix := getEntryAttrValueSuffix(compareEntry)
-	switch {
-	case compareEntry == baseDN:
-		entry = entries.Domain
-	case strings.HasPrefix(compareEntry, "ou=") && compareEntrySuffix == baseDN:
-		for _, ou := range entries.OUs {
-			// handle stop signal
-			select {
-			case <-m.Done:
-				logger.Infof("client [%d]: leaving handleCompare...", m.Client.Numero())
-				return
-			default:
-			}
-
-			if ou.OU != compareEntryName {
-				continue
-			}
-
-			entry = ou
-			break
-		}
-	case compareEntrySuffix == "ou="+usersOUName+","+baseDN:
-		for _, user := range entries.Users {
-			// handle stop signal
-			select {
-			case <-m.Done:
-				logger.Infof("client [%d]: leaving handleCompare...", m.Client.Numero())
-				return
-			default:
-			}
-
-			var cmpValue string
-			switch compareEntryAttr {
-			case "cn":
-				cmpValue = user.CN
-			case "uid":
-				cmpValue = user.UID
-			}
-			if cmpValue != compareEntryName {
-				continue
-			}
-
-			entry = user
-			break
-		}
-	case strings.HasPrefix(compareEntry, "cn=") && compareEntrySuffix == "ou="+groupsOUName+","+baseDN:
-		for _, group := range entries.Groups {
-			// handle stop signal
-			select {
-			case <-m.Done:
-				logger.Infof("client [%d]: leaving handleCompare...", m.Client.Numero())
-				return
-			default:
-			}
-
-			if group.CN != compareEntryName {
-				continue
-			}
-
-			entry = group
-			break
-		}
+	if e, ok := findEntry(entries, compareEntry, baseDN, usersOUName, groupsOUName); ok {
+		entry = e.o
 	}
 
 	// entry not found
@@ -130,6 +110,20 @@ func handleCompare(w ldapserver.ResponseWriter, m *ldapserver.Message, entries *
 		return
 	}
 
+	// check assertion over entry
+	if assertion != nil {
+		if result, err := checkAssertion(entry, assertion); err != nil {
+			res := ldapserver.NewCompareResponse(result)
+			if err != errAssertionFailed {
+				res.SetDiagnosticMessage(err.Error())
+			}
+			w.Write(res)
+
+			logger.Errorf("client [%d]: compare error: %s", m.Client.Numero(), err)
+			return
+		}
+	}
+
 	// compare
 	ok, err := doCompare(entry, attrName, string(r.Ava().AssertionValue()))
 	if err != nil {
@@ -156,43 +150,10 @@ func handleCompare(w ldapserver.ResponseWriter, m *ldapserver.Message, entries *
 
 // doCompare checks if object 'o' have attr 'attrName' with value 'attrValue'
 func doCompare(o interface{}, attrName string, attrValue string) (bool, error) {
-	field, found := reflect.TypeOf(o).FieldByNameFunc(func(s string) bool { return strings.EqualFold(s, attrName) })
+	attr, found := getAttribute(o, attrName)
 	if !found {
 		return false, errLDAPNoAttr
 	}
-	if tagValueContains(field.Tag, "ldap", "skip") {
-		return false, errLDAPNoAttr
-	}
-
-	fieldValue := reflect.ValueOf(o).FieldByName(field.Name)
-	if !fieldValue.IsValid() {
-		return false, errLDAPNoAttr
-	}
-
-	switch val := fieldValue.Interface().(type) {
-	case uint:
-		if fmt.Sprint(val) == attrValue {
-			return true, nil
-		}
-	case string:
-		if !tagValueContains(field.Tag, "ldap", "case_sensitive_value") {
-			val = strings.ToLower(val)
-			attrValue = strings.ToLower(attrValue)
-		}
-		if val == attrValue {
-			return true, nil
-		}
-	case []string:
-		for _, v := range val {
-			if !tagValueContains(field.Tag, "ldap", "case_sensitive_value") {
-				v = strings.ToLower(v)
-				attrValue = strings.ToLower(attrValue)
-			}
-			if v == attrValue {
-				return true, nil
-			}
-		}
-	}
 
-	return false, nil
+	return attr.hasValue(attrValue), nil
 }
@@ -0,0 +1,495 @@
+package ldap
+
+import (
+	"errors"
+	"fmt"
+
+	ldap "github.com/ps78674/goldap/message"
+	"github.com/ps78674/gorestldap/internal/ber"
+)
+
+const (
+	sortRequestControlOID  ldap.LDAPOID = "1.2.840.113556.1.4.473"
+	sortResponseControlOID ldap.LDAPOID = "1.2.840.113556.1.4.474"
+	vlvRequestControlOID   ldap.LDAPOID = "2.16.840.1.113730.3.4.9"
+	vlvResponseControlOID  ldap.LDAPOID = "2.16.840.1.113730.3.4.10"
+
+	persistentSearchControlOID        ldap.LDAPOID = "2.16.840.1.113730.3.4.3"
+	entryChangeNotificationControlOID ldap.LDAPOID = "2.16.840.1.113730.3.4.7"
+
+	syncRequestControlOID ldap.LDAPOID = "1.3.6.1.4.1.4203.1.9.1.1"
+	syncStateControlOID   ldap.LDAPOID = "1.3.6.1.4.1.4203.1.9.1.2"
+	syncDoneControlOID    ldap.LDAPOID = "1.3.6.1.4.1.4203.1.9.1.3"
+	syncInfoMessageOID    ldap.LDAPOID = "1.3.6.1.4.1.4203.1.9.1.4"
+
+	assertionControlOID ldap.LDAPOID = "1.3.6.1.1.12"
+	preReadControlOID   ldap.LDAPOID = "1.3.6.1.1.13.1"
+	postReadControlOID  ldap.LDAPOID = "1.3.6.1.1.13.2"
+
+	matchedValuesControlOID ldap.LDAPOID = "1.2.826.0.1.3344810.2.3"
+)
+
+// assertionFailed result code (RFC 4528)
+const ldapResultAssertionFailed = 122
+
+// sortKey is a single key of server side sort request control (RFC 2891)
+type sortKey struct {
+	attr         string
+	orderingRule string
+	reverse      bool
+}
+
+// vlvRequest is a virtual list view request control (draft-ietf-ldapext-ldapv3-vlv-09)
+type vlvRequest struct {
+	beforeCount    int
+	afterCount     int
+	byOffset       bool
+	offset         int
+	contentCount   int
+	assertionValue string
+	contextID      string
+}
+
+// persistentSearch is a persistent search control (draft-ietf-ldapext-psearch-03)
+type persistentSearch struct {
+	changeTypes int
+	changesOnly bool
+	returnECs   bool
+}
+
+// syncRequest is a content synchronization request control (RFC 4533)
+type syncRequest struct {
+	mode       int
+	cookie     string
+	reloadHint bool
+}
+
+// decodeControlValue decodes control value as BER element
+func decodeControlValue(v *ldap.OCTETSTRING) (*ber.Packet, error) {
+	if v == nil {
+		return nil, errors.New("control value is missing")
+	}
+	return ber.Decode(v.Bytes())
+}
+
+// newControl creates control with BER encoded value 'p'
+func newControl(oid ldap.LDAPOID, critical bool, p *ber.Packet) ldap.Control {
+	return ldap.NewControl(oid, ldap.BOOLEAN(critical), ldap.OCTETSTRING(p.Bytes()))
+}
+
+// readSortRequestControl decodes
+//
+//	SortKeyList ::= SEQUENCE OF SEQUENCE {
+//	     attributeType   AttributeDescription,
+//	     orderingRule    [0] MatchingRuleId OPTIONAL,
+//	     reverseOrder    [1] BOOLEAN DEFAULT FALSE }
+func readSortRequestControl(v *ldap.OCTETSTRING) ([]sortKey, error) {
+	p, err := decodeControlValue(v)
+	if err != nil {
+		return nil, err
+	}
+	if !p.Is(ber.ClassUniversal, ber.TagSequence) || len(p.Children) == 0 {
+		return nil, errors.New("wrong sort key list")
+	}
+
+	var keys []sortKey
+	for _, pKey := range p.Children {
+		if !pKey.Is(ber.ClassUniversal, ber.TagSequence) || len(pKey.Children) == 0 {
+			return nil, errors.New("wrong sort key")
+		}
+
+		key := sortKey{attr: pKey.Children[0].String()}
+		for _, c := range pKey.Children[1:] {
+			switch {
+			case c.Is(ber.ClassContext, 0):
+				key.orderingRule = c.String()
+			case c.Is(ber.ClassContext, 1):
+				if key.reverse, err = c.Bool(); err != nil {
+					return nil, fmt.Errorf("wrong reverse order value: %s", err)
+				}
+			default:
+				return nil, fmt.Errorf("unexpected sort key element with tag %d", c.Tag)
+			}
+		}
+		keys = append(keys, key)
+	}
+
+	return keys, nil
+}
+
+// newSortResponseControl encodes
+//
+//	SortResult ::= SEQUENCE {
+//	     sortResult     ENUMERATED,
+//	     attributeType  [0] AttributeDescription OPTIONAL }
+func newSortResponseControl(result int, attr string) ldap.Control {
+	p := ber.NewSequence(ber.NewEnumerated(int64(result)))
+	if len(attr) > 0 {
+		p.Append(ber.NewPrimitive(ber.ClassContext, 0, []byte(attr)))
+	}
+	return newControl(sortResponseControlOID, false, p)
+}
+
+// readVLVRequestControl decodes
+//
+//	VirtualListViewRequest ::= SEQUENCE {
+//	     beforeCount    INTEGER (0..maxInt),
+//	     afterCount     INTEGER (0..maxInt),
+//	     target       CHOICE {
+//	          byOffset        [0] SEQUENCE {
+//	               offset          INTEGER (1 .. maxInt),
+//	               contentCount    INTEGER (0 .. maxInt) },
+//	          greaterThanOrEqual [1] AssertionValue },
+//	     contextID     OCTET STRING OPTIONAL }
+func readVLVRequestControl(v *ldap.OCTETSTRING) (req vlvRequest, err error) {
+	p, err := decodeControlValue(v)
+	if err != nil {
+		return req, err
+	}
+	if !p.Is(ber.ClassUniversal, ber.TagSequence) || len(p.Children) < 3 {
+		return req, errors.New("wrong virtual list view request")
+	}
+
+	before, err := p.Children[0].Int()
+	if err != nil {
+		return req, fmt.Errorf("wrong beforeCount: %s", err)
+	}
+	after, err := p.Children[1].Int()
+	if err != nil {
+		return req, fmt.Errorf("wrong afterCount: %s", err)
+	}
+	if before < 0 || after < 0 {
+		return req, errors.New("negative beforeCount or afterCount")
+	}
+	req.beforeCount = int(before)
+	req.afterCount = int(after)
+
+	target := p.Children[2]
+	switch {
+	case target.Is(ber.ClassContext, 0) && len(target.Children) == 2:
+		offset, err := target.Children[0].Int()
+		if err != nil {
+			return req, fmt.Errorf("wrong offset: %s", err)
+		}
+		contentCount, err := target.Children[1].Int()
+		if err != nil {
+			return req, fmt.Errorf("wrong contentCount: %s", err)
+		}
+		req.byOffset = true
+		req.offset = int(offset)
+		req.contentCount = int(contentCount)
+	case target.Is(ber.ClassContext, 1):
+		req.assertionValue = target.String()
+	default:
+		return req, errors.New("wrong virtual list view target")
+	}
+
+	if len(p.Children) > 3 {
+		req.contextID = p.Children[3].String()
+	}
+
+	return req, nil
+}
+
+// newVLVResponseControl encodes
+//
+//	VirtualListViewResponse ::= SEQUENCE {
+//	     targetPosition    INTEGER (0 .. maxInt),
+//	     contentCount      INTEGER (0 .. maxInt),
+//	     virtualListViewResult ENUMERATED,
+//	     contextID     OCTET STRING OPTIONAL }
+func newVLVResponseControl(targetPosition, contentCount, result int, contextID string) ldap.Control {
+	p := ber.NewSequence(
+		ber.NewInteger(int64(targetPosition)),
+		ber.NewInteger(int64(contentCount)),
+		ber.NewEnumerated(int64(result)),
+	)
+	if len(contextID) > 0 {
+		p.Append(ber.NewOctetString(contextID))
+	}
+	return newControl(vlvResponseControlOID, false, p)
+}
+
+// readPersistentSearchControl decodes
+//
+//	PersistentSearch ::= SEQUENCE {
+//	     changeTypes INTEGER,
+//	     changesOnly BOOLEAN,
+//	     returnECs BOOLEAN }
+func readPersistentSearchControl(v *ldap.OCTETSTRING) (ps persistentSearch, err error) {
+	p, err := decodeControlValue(v)
+	if err != nil {
+		return ps, err
+	}
+	if !p.Is(ber.ClassUniversal, ber.TagSequence) || len(p.Children) != 3 {
+		return ps, errors.New("wrong persistent search request")
+	}
+
+	changeTypes, err := p.Children[0].Int()
+	if err != nil {
+		return ps, fmt.Errorf("wrong changeTypes: %s", err)
+	}
+	if changeTypes < 1 || changeTypes > changeTypeAdd|changeTypeDelete|changeTypeModify|changeTypeModDN {
+		return ps, fmt.Errorf("wrong changeTypes value %d", changeTypes)
+	}
+	ps.changeTypes = int(changeTypes)
+
+	if ps.changesOnly, err = p.Children[1].Bool(); err != nil {
+		return ps, fmt.Errorf("wrong changesOnly: %s", err)
+	}
+	if ps.returnECs, err = p.Children[2].Bool(); err != nil {
+		return ps, fmt.Errorf("wrong returnECs: %s", err)
+	}
+
+	return ps, nil
+}
+
+// newEntryChangeNotificationControl encodes
+//
+//	EntryChangeNotification ::= SEQUENCE {
+//	     changeType ENUMERATED {
+//	          add             (1),
+//	          delete          (2),
+//	          modify          (4),
+//	          modDN           (8) },
+//	     previousDN   LDAPDN OPTIONAL,     -- modifyDN ops. only
+//	     changeNumber INTEGER OPTIONAL }   -- if supported
+func newEntryChangeNotificationControl(changeType int, previousDN string, changeNumber int) ldap.Control {
+	p := ber.NewSequence(ber.NewEnumerated(int64(changeType)))
+	if changeType == changeTypeModDN {
+		p.Append(ber.NewOctetString(previousDN))
+	}
+	if changeNumber > 0 {
+		p.Append(ber.NewInteger(int64(changeNumber)))
+	}
+	return newControl(entryChangeNotificationControlOID, false, p)
+}
+
+// readSyncRequestControl decodes
+//
+//	syncRequestValue ::= SEQUENCE {
+//	     mode ENUMERATED {
+//	          -- 0 unused
+//	          refreshOnly       (1),
+//	          -- 2 reserved
+//	          refreshAndPersist (3) },
+//	     cookie     syncCookie OPTIONAL,
+//	     reloadHint BOOLEAN DEFAULT FALSE }
+func readSyncRequestControl(v *ldap.OCTETSTRING) (req syncRequest, err error) {
+	p, err := decodeControlValue(v)
+	if err != nil {
+		return req, err
+	}
+	if !p.Is(ber.ClassUniversal, ber.TagSequence) || len(p.Children) == 0 || len(p.Children) > 3 {
+		return req, errors.New("wrong sync request")
+	}
+
+	mode, err := p.Children[0].Int()
+	if err != nil {
+		return req, fmt.Errorf("wrong mode: %s", err)
+	}
+	if mode != syncModeRefreshOnly && mode != syncModeRefreshAndPersist {
+		return req, fmt.Errorf("wrong mode value %d", mode)
+	}
+	req.mode = int(mode)
+
+	for _, c := range p.Children[1:] {
+		switch {
+		case c.Is(ber.ClassUniversal, ber.TagOctetString):
+			req.cookie = c.String()
+		case c.Is(ber.ClassUniversal, ber.TagBoolean):
+			if req.reloadHint, err = c.Bool(); err != nil {
+				return req, fmt.Errorf("wrong reloadHint: %s", err)
+			}
+		default:
+			return req, fmt.Errorf("unexpected sync request element with tag %d", c.Tag)
+		}
+	}
+
+	return req, nil
+}
+
+// newSyncStateControl encodes
+//
+//	syncStateValue ::= SEQUENCE {
+//	     state ENUMERATED {
+//	          present (0),
+//	          add (1),
+//	          modify (2),
+//	          delete (3) },
+//	     entryUUID syncUUID,
+//	     cookie    syncCookie OPTIONAL }
+func newSyncStateControl(state int, entryUUID []byte, cookie string) ldap.Control {
+	p := ber.NewSequence(
+		ber.NewEnumerated(int64(state)),
+		ber.NewPrimitive(ber.ClassUniversal, ber.TagOctetString, entryUUID),
+	)
+	if len(cookie) > 0 {
+		p.Append(ber.NewOctetString(cookie))
+	}
+	return newControl(syncStateControlOID, false, p)
+}
+
+// newSyncDoneControl encodes
+//
+//	syncDoneValue ::= SEQUENCE {
+//	     cookie          syncCookie OPTIONAL,
+//	     refreshDeletes  BOOLEAN DEFAULT FALSE }
+func newSyncDoneControl(cookie string, refreshDeletes bool) ldap.Control {
+	p := ber.NewSequence()
+	if len(cookie) > 0 {
+		p.Append(ber.NewOctetString(cookie))
+	}
+	if refreshDeletes {
+		p.Append(ber.NewBoolean(true))
+	}
+	return newControl(syncDoneControlOID, false, p)
+}
+
+// readAssertionControl decodes assertion control value, which is a BER encoded Filter
+func readAssertionControl(v *ldap.OCTETSTRING) (ldap.Filter, error) {
+	p, err := decodeControlValue(v)
+	if err != nil {
+		return nil, fmt.Errorf("wrong assertion filter: %s", err)
+	}
+
+	f, err := decodeFilter(p)
+	if err != nil {
+		return nil, fmt.Errorf("wrong assertion filter: %s", err)
+	}
+
+	return f, nil
+}
+
+// readMatchedValuesControl decodes
+//
+//	ValuesReturnFilter ::= SEQUENCE OF SimpleFilterItem
+//
+//	SimpleFilterItem ::= CHOICE {
+//	     equalityMatch   [3] AttributeValueAssertion,
+//	     substrings      [4] SubstringFilter,
+//	     greaterOrEqual  [5] AttributeValueAssertion,
+//	     lessOrEqual     [6] AttributeValueAssertion,
+//	     present         [7] AttributeDescription,
+//	     approxMatch     [8] AttributeValueAssertion,
+//	     extensibleMatch [9] SimpleMatchingAssertion }
+func readMatchedValuesControl(v *ldap.OCTETSTRING) ([]ldap.Filter, error) {
+	p, err := decodeControlValue(v)
+	if err != nil {
+		return nil, err
+	}
+	if !p.Is(ber.ClassUniversal, ber.TagSequence) || len(p.Children) == 0 {
+		return nil, errors.New("wrong values return filter")
+	}
+
+	var filters []ldap.Filter
+	for _, c := range p.Children {
+		// simple matching assertion has no dnAttributes, but goldap reads them unconditionally -> add ignored ones
+		if c.Is(ber.ClassContext, 9) && len(c.Children) > 0 && !c.Children[len(c.Children)-1].Is(ber.ClassContext, 4) {
+			children := append([]*ber.Packet{}, c.Children...)
+			c = ber.NewConstructed(ber.ClassContext, 9, append(children, ber.NewPrimitive(ber.ClassContext, 4, []byte{0xff}))...)
+		}
+
+		f, err := decodeFilter(c)
+		if err != nil {
+			return nil, fmt.Errorf("wrong values return filter item: %s", err)
+		}
+		switch f.(type) {
+		case ldap.FilterEqualityMatch, ldap.FilterSubstrings, ldap.FilterGreaterOrEqual, ldap.FilterLessOrEqual,
+			ldap.FilterPresent, ldap.FilterApproxMatch, ldap.FilterExtensibleMatch:
+		default:
+			return nil, fmt.Errorf("unsupported values return filter item '%T'", f)
+		}
+		filters = append(filters, f)
+	}
+
+	return filters, nil
+}
+
+// decodeFilter decodes BER encoded Filter
+func decodeFilter(filter *ber.Packet) (f ldap.Filter, err error) {
+	// goldap panics on some malformed filters, e.g. equality match without value
+	defer func() {
+		if r := recover(); r != nil {
+			f, err = nil, fmt.Errorf("malformed filter: %v", r)
+		}
+	}()
+
+	// goldap can not decode bare filter -> decode it within search request
+	//	SearchRequest ::= [APPLICATION 3] SEQUENCE {
+	//	     baseObject      LDAPDN,
+	//	     scope           ENUMERATED,
+	//	     derefAliases    ENUMERATED,
+	//	     sizeLimit       INTEGER (0 ..  maxInt),
+	//	     timeLimit       INTEGER (0 ..  maxInt),
+	//	     typesOnly       BOOLEAN,
+	//	     filter          Filter,
+	//	     attributes      AttributeSelection }
+	msg := ber.NewSequence(
+		ber.NewInteger(0),
+		ber.NewConstructed(ber.ClassApplication, 3,
+			ber.NewOctetString(""),
+			ber.NewEnumerated(0),
+			ber.NewEnumerated(0),
+			ber.NewInteger(0),
+			ber.NewInteger(0),
+			ber.NewBoolean(false),
+			filter,
+			ber.NewSequence(),
+		),
+	)
+
+	decoded, err := ldap.ReadLDAPMessage(ldap.NewBytes(0, msg.Bytes()))
+	if err != nil {
+		return nil, err
+	}
+	r, ok := decoded.ProtocolOp().(ldap.SearchRequest)
+	if !ok {
+		return nil, errors.New("not a filter")
+	}
+
+	return r.Filter(), nil
+}
+
+// readReadEntryControl decodes pre-read or post-read control value
+//
+//	AttributeSelection ::= SEQUENCE OF selector LDAPString
+func readReadEntryControl(v *ldap.OCTETSTRING) ([]string, error) {
+	p, err := decodeControlValue(v)
+	if err != nil {
+		return nil, err
+	}
+	if !p.Is(ber.ClassUniversal, ber.TagSequence) {
+		return nil, errors.New("wrong attribute selection")
+	}
+
+	attrs := []string{}
+	for _, c := range p.Children {
+		if !c.Is(ber.ClassUniversal, ber.TagOctetString) {
+			return nil, errors.New("wrong attribute selector")
+		}
+		attrs = append(attrs, c.String())
+	}
+
+	return attrs, nil
+}
+
+// newReadEntryControl encodes pre-read or post-read response control with entry 'e'
+//
+//	SearchResultEntry ::= [APPLICATION 4] SEQUENCE {
+//	     objectName      LDAPDN,
+//	     attributes      PartialAttributeList }
+func newReadEntryControl(oid ldap.LDAPOID, e ldap.SearchResultEntry) (ldap.Control, error) {
+	msg, err := ldap.NewLDAPMessageWithProtocolOp(e).Write()
+	if err != nil {
+		return ldap.Control{}, fmt.Errorf("error encoding entry: %s", err)
+	}
+
+	// LDAPMessage ::= SEQUENCE { messageID, protocolOp, controls }
+	p, err := ber.Decode(msg.Bytes())
+	if err != nil || len(p.Children) < 2 {
+		return ldap.Control{}, fmt.Errorf("error encoding entry: %v", err)
+	}
+
+	return newControl(oid, false, p.Children[1]), nil
+}
@@ -0,0 +1,284 @@
+package ldap
+
+import (
+	"reflect"
+	"testing"
+
+	ldap "github.com/ps78674/goldap/message"
+	"github.com/ps78674/gorestldap/internal/ber"
+)
+
+// controlValue returns control value of BER element 'p'
+func controlValue(p *ber.Packet) *ldap.OCTETSTRING {
+	v := ldap.OCTETSTRING(p.Bytes())
+	return &v
+}
+
+func TestReadSortRequestControl(t *testing.T) {
+	tests := []struct {
+		name    string
+		value   *ldap.OCTETSTRING
+		want    []sortKey
+		wantErr bool
+	}{
+		{
+			"keys",
+			controlValue(ber.NewSequence(
+				ber.NewSequence(ber.NewOctetString("cn")),
+				ber.NewSequence(
+					ber.NewOctetString("uidNumber"),
+					ber.NewPrimitive(ber.ClassContext, 0, []byte("integerOrderingMatch")),
+					ber.NewPrimitive(ber.ClassContext, 1, []byte{0xff}),
+				),
+			)),
+			[]sortKey{{attr: "cn"}, {attr: "uidNumber", orderingRule: "integerOrderingMatch", reverse: true}},
+			false,
+		},
+		{"missing value", nil, nil, true},
+		{"empty list", controlValue(ber.NewSequence()), nil, true},
+		{"empty key", controlValue(ber.NewSequence(ber.NewSequence())), nil, true},
+		{"wrong reverse", controlValue(ber.NewSequence(ber.NewSequence(ber.NewOctetString("cn"), ber.NewPrimitive(ber.ClassContext, 1, nil)))), nil, true},
+		{"unexpected element", controlValue(ber.NewSequence(ber.NewSequence(ber.NewOctetString("cn"), ber.NewInteger(1)))), nil, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			keys, err := readSortRequestControl(tt.value)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("err = %v, want error %t", err, tt.wantErr)
+			}
+			if !reflect.DeepEqual(keys, tt.want) {
+				t.Errorf("keys = %+v, want %+v", keys, tt.want)
+			}
+		})
+	}
+}
+
+func TestReadVLVRequestControl(t *testing.T) {
+	tests := []struct {
+		name    string
+		value   *ldap.OCTETSTRING
+		want    vlvRequest
+		wantErr bool
+	}{
+		{
+			"by offset",
+			controlValue(ber.NewSequence(
+				ber.NewInteger(1),
+				ber.NewInteger(2),
+				ber.NewConstructed(ber.ClassContext, 0, ber.NewInteger(10), ber.NewInteger(100)),
+				ber.NewOctetString("ctx"),
+			)),
+			vlvRequest{beforeCount: 1, afterCount: 2, byOffset: true, offset: 10, contentCount: 100, contextID: "ctx"},
+			false,
+		},
+		{
+			"greater than or equal",
+			controlValue(ber.NewSequence(
+				ber.NewInteger(0),
+				ber.NewInteger(5),
+				ber.NewPrimitive(ber.ClassContext, 1, []byte("user05")),
+			)),
+			vlvRequest{afterCount: 5, assertionValue: "user05"},
+			false,
+		},
+		{"missing target", controlValue(ber.NewSequence(ber.NewInteger(0), ber.NewInteger(0))), vlvRequest{}, true},
+		{"negative count", controlValue(ber.NewSequence(ber.NewInteger(-1), ber.NewInteger(0), ber.NewPrimitive(ber.ClassContext, 1, nil))), vlvRequest{}, true},
+		{"wrong target", controlValue(ber.NewSequence(ber.NewInteger(0), ber.NewInteger(0), ber.NewOctetString("x"))), vlvRequest{}, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req, err := readVLVRequestControl(tt.value)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("err = %v, want error %t", err, tt.wantErr)
+			}
+			if !tt.wantErr && req != tt.want {
+				t.Errorf("request = %+v, want %+v", req, tt.want)
+			}
+		})
+	}
+}
+
+func TestReadPersistentSearchControl(t *testing.T) {
+	tests := []struct {
+		name    string
+		value   *ldap.OCTETSTRING
+		want    persistentSearch
+		wantErr bool
+	}{
+		{
+			"all changes",
+			controlValue(ber.NewSequence(ber.NewInteger(15), ber.NewBoolean(true), ber.NewBoolean(false))),
+			persistentSearch{changeTypes: 15, changesOnly: true},
+			false,
+		},
+		{
+			"modify with entry change notifications",
+			controlValue(ber.NewSequence(ber.NewInteger(changeTypeModify), ber.NewBoolean(false), ber.NewBoolean(true))),
+			persistentSearch{changeTypes: changeTypeModify, returnECs: true},
+			false,
+		},
+		{"missing value", nil, persistentSearch{}, true},
+		{"missing element", controlValue(ber.NewSequence(ber.NewInteger(1), ber.NewBoolean(true))), persistentSearch{}, true},
+		{"no change types", controlValue(ber.NewSequence(ber.NewInteger(0), ber.NewBoolean(true), ber.NewBoolean(true))), persistentSearch{}, true},
+		{"unknown change types", controlValue(ber.NewSequence(ber.NewInteger(16), ber.NewBoolean(true), ber.NewBoolean(true))), persistentSearch{}, true},
+		{"wrong boolean", controlValue(ber.NewSequence(ber.NewInteger(1), ber.NewOctetString(""), ber.NewBoolean(true))), persistentSearch{}, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ps, err := readPersistentSearchControl(tt.value)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("err = %v, want error %t", err, tt.wantErr)
+			}
+			if !tt.wantErr && ps != tt.want {
+				t.Errorf("control = %+v, want %+v", ps, tt.want)
+			}
+		})
+	}
+}
+
+func TestReadSyncRequestControl(t *testing.T) {
+	tests := []struct {
+		name    string
+		value   *ldap.OCTETSTRING
+		want    syncRequest
+		wantErr bool
+	}{
+		{"refresh only", controlValue(ber.NewSequence(ber.NewEnumerated(syncModeRefreshOnly))), syncRequest{mode: syncModeRefreshOnly}, false},
+		{
+			"refresh and persist with cookie",
+			controlValue(ber.NewSequence(ber.NewEnumerated(syncModeRefreshAndPersist), ber.NewOctetString("rid=000,csn=1"), ber.NewBoolean(true))),
+			syncRequest{mode: syncModeRefreshAndPersist, cookie: "rid=000,csn=1", reloadHint: true},
+			false,
+		},
+		{"missing value", nil, syncRequest{}, true},
+		{"empty", controlValue(ber.NewSequence()), syncRequest{}, true},
+		{"reserved mode", controlValue(ber.NewSequence(ber.NewEnumerated(2))), syncRequest{}, true},
+		{"unexpected element", controlValue(ber.NewSequence(ber.NewEnumerated(syncModeRefreshOnly), ber.NewInteger(1))), syncRequest{}, true},
+		{"too many elements", controlValue(ber.NewSequence(ber.NewEnumerated(1), ber.NewOctetString(""), ber.NewBoolean(true), ber.NewBoolean(true))), syncRequest{}, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req, err := readSyncRequestControl(tt.value)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("err = %v, want error %t", err, tt.wantErr)
+			}
+			if !tt.wantErr && req != tt.want {
+				t.Errorf("request = %+v, want %+v", req, tt.want)
+			}
+		})
+	}
+}
+
+// equalityFilter returns BER encoded equality match filter item
+func equalityFilter(attr, value string) *ber.Packet {
+	return ber.NewConstructed(ber.ClassContext, 3, ber.NewOctetString(attr), ber.NewOctetString(value))
+}
+
+func TestReadAssertionControl(t *testing.T) {
+	f, err := readAssertionControl(controlValue(ber.NewConstructed(ber.ClassContext, 0,
+		equalityFilter("uid", "user01"),
+		ber.NewPrimitive(ber.ClassContext, 7, []byte("mail")),
+	)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	and, ok := f.(ldap.FilterAnd)
+	if !ok || len(and) != 2 {
+		t.Fatalf("filter = %#v, want and filter with 2 items", f)
+	}
+	eq, ok := and[0].(ldap.FilterEqualityMatch)
+	if !ok || string(eq.AttributeDesc()) != "uid" || string(eq.AssertionValue()) != "user01" {
+		t.Errorf("first item = %#v, want (uid=user01)", and[0])
+	}
+	if _, ok := and[1].(ldap.FilterPresent); !ok {
+		t.Errorf("second item = %#v, want (mail=*)", and[1])
+	}
+
+	for name, value := range map[string]*ldap.OCTETSTRING{
+		"missing value": nil,
+		"not a filter":  controlValue(ber.NewOctetString("uid=user01")),
+		"wrong item":    controlValue(ber.NewConstructed(ber.ClassContext, 3, ber.NewOctetString("uid"))),
+	} {
+		if _, err := readAssertionControl(value); err == nil {
+			t.Errorf("%s: expected error", name)
+		}
+	}
+}
+
+func TestReadReadEntryControl(t *testing.T) {
+	tests := []struct {
+		name    string
+		value   *ldap.OCTETSTRING
+		want    []string
+		wantErr bool
+	}{
+		{"attributes", controlValue(ber.NewSequence(ber.NewOctetString("cn"), ber.NewOctetString("+"))), []string{"cn", "+"}, false},
+		{"all attributes", controlValue(ber.NewSequence()), []string{}, false},
+		{"missing value", nil, nil, true},
+		{"not a sequence", controlValue(ber.NewOctetString("cn")), nil, true},
+		{"wrong selector", controlValue(ber.NewSequence(ber.NewInteger(1))), nil, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			attrs, err := readReadEntryControl(tt.value)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("err = %v, want error %t", err, tt.wantErr)
+			}
+			if !reflect.DeepEqual(attrs, tt.want) {
+				t.Errorf("attributes = %v, want %v", attrs, tt.want)
+			}
+		})
+	}
+}
+
+func TestReadMatchedValuesControl(t *testing.T) {
+	filters, err := readMatchedValuesControl(controlValue(ber.NewSequence(
+		equalityFilter("mail", "user01@example.com"),
+		ber.NewConstructed(ber.ClassContext, 4,
+			ber.NewOctetString("sshPublicKey"),
+			ber.NewSequence(ber.NewPrimitive(ber.ClassContext, 0, []byte("ssh-ed25519"))),
+		),
+		ber.NewPrimitive(ber.ClassContext, 7, []byte("memberOf")),
+		ber.NewConstructed(ber.ClassContext, 5, ber.NewOctetString("uidNumber"), ber.NewOctetString("1000")),
+		// simple matching assertion without dnAttributes
+		ber.NewConstructed(ber.ClassContext, 9, ber.NewPrimitive(ber.ClassContext, 2, []byte("cn")), ber.NewPrimitive(ber.ClassContext, 3, []byte("user01"))),
+	)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(filters) != 5 {
+		t.Fatalf("got %d filters, want 5", len(filters))
+	}
+	if _, ok := filters[0].(ldap.FilterEqualityMatch); !ok {
+		t.Errorf("first item = %#v, want equality match", filters[0])
+	}
+	if _, ok := filters[1].(ldap.FilterSubstrings); !ok {
+		t.Errorf("second item = %#v, want substrings", filters[1])
+	}
+	if _, ok := filters[2].(ldap.FilterPresent); !ok {
+		t.Errorf("third item = %#v, want present", filters[2])
+	}
+	if _, ok := filters[3].(ldap.FilterGreaterOrEqual); !ok {
+		t.Errorf("fourth item = %#v, want greater or equal", filters[3])
+	}
+	if _, ok := filters[4].(ldap.FilterExtensibleMatch); !ok {
+		t.Errorf("fifth item = %#v, want extensible match", filters[4])
+	}
+
+	for name, value := range map[string]*ldap.OCTETSTRING{
+		"missing value": nil,
+		"empty list":    controlValue(ber.NewSequence()),
+		"not a list":    controlValue(equalityFilter("uid", "user01")),
+		"wrong item":    controlValue(ber.NewSequence(ber.NewConstructed(ber.ClassContext, 3, ber.NewOctetString("uid")))),
+		"not simple":    controlValue(ber.NewSequence(ber.NewConstructed(ber.ClassContext, 0, equalityFilter("uid", "user01")))),
+	} {
+		if _, err := readMatchedValuesControl(value); err == nil {
+			t.Errorf("%s: expected error", name)
+		}
+	}
+}
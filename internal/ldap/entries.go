@@ -1,15 +1,21 @@
 package ldap
 
 import (
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+
 	"github.com/google/uuid"
 	"github.com/ps78674/gorestldap/internal/data"
+	"github.com/ps78674/gorestldap/internal/ldaputils"
 )
 
 // GetEntries returns configured entries
-func GetEntries(baseDN, usersOUName, groupsOUName string) *data.Entries {
+func GetEntries(baseDN string) *data.Entries {
 	_, dc, _ := getEntryAttrValueSuffix(baseDN)
 	var domain = data.Domain{
-		EntryUUID:       newEntryUUID(dc),
+		EntryUUID:       newEntryUUID(ldaputils.NormalizeEntry(baseDN)),
 		HasSubordinates: "TRUE",
 		ObjectClass: []string{
 			"top",
@@ -18,34 +24,295 @@ func GetEntries(baseDN, usersOUName, groupsOUName string) *data.Entries {
 		DC: dc,
 	}
 
-	var ous = []data.OU{
-		{
-			EntryUUID:       newEntryUUID(usersOUName),
-			HasSubordinates: "TRUE",
-			ObjectClass: []string{
-				"top",
-				"organizationalUnit",
-			},
-			OU: usersOUName,
-		},
-		{
-			EntryUUID:       newEntryUUID(groupsOUName),
-			HasSubordinates: "TRUE",
+	return &data.Entries{
+		Domain: domain,
+	}
+}
+
+// GetOUs returns organizational units: users and groups ous, configured ones 'ous' and ones containing entries 'entries',
+// parent ous are created as well, parents are returned before their children
+// hasSubordinates of ous and entries is computed from the tree
+// returns errors of users and groups with wrong parentOU, such entries are placed into users or groups ou
+func GetOUs(entries *data.Entries, baseDN, usersOUName, groupsOUName string, ous []string) ([]data.OU, []error) {
+	var errs []error
+	users, groups := entries.Users, entries.Groups
+
+	// paths of ous relative to base dn -> has subordinates
+	paths := make(map[string]bool)
+	addPath := func(path string, hasSubordinates bool) {
+		for p := path; len(p) > 0; {
+			paths[p] = paths[p] || hasSubordinates
+			hasSubordinates = true
+			p = ldaputils.ParentEntry(p)
+		}
+	}
+
+	addPath(ldaputils.OUPath(usersOUName), false)
+	addPath(ldaputils.OUPath(groupsOUName), false)
+	for _, path := range ous {
+		addPath(path, false)
+	}
+	for i := range users {
+		path, err := getParentOU(users[i], baseDN, usersOUName, groupsOUName)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("user '%s' is placed into ou '%s': %s", users[i].CN, usersOUName, err))
+		}
+		addPath(path, true)
+		users[i].BackendHasSubs = users[i].HasSubordinates
+		users[i].HasSubordinates = "FALSE"
+	}
+	for i := range groups {
+		path, err := getParentOU(groups[i], baseDN, usersOUName, groupsOUName)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("group '%s' is placed into ou '%s': %s", groups[i].CN, groupsOUName, err))
+		}
+		addPath(path, true)
+		groups[i].BackendHasSubs = groups[i].HasSubordinates
+		groups[i].HasSubordinates = "FALSE"
+	}
+	for i := range entries.SudoRoles {
+		addPath(entries.SudoRoles[i].ParentOU, true)
+		entries.SudoRoles[i].HasSubordinates = "FALSE"
+	}
+	for _, netgroup := range entries.Netgroups {
+		addPath(netgroup.ParentOU, true)
+	}
+	for _, host := range entries.Hosts {
+		addPath(host.ParentOU, true)
+	}
+	for _, m := range entries.AutomountMaps {
+		addPath(m.ParentOU, true)
+	}
+
+	// parents first
+	sorted := make([]string, 0, len(paths))
+	for path := range paths {
+		sorted = append(sorted, path)
+	}
+	depth := make(map[string]int, len(sorted))
+	for _, path := range sorted {
+		dn, _ := ldaputils.ParseDN(path)
+		depth[path] = len(dn)
+	}
+	sort.Slice(sorted, func(i, j int) bool {
+		di, dj := depth[sorted[i]], depth[sorted[j]]
+		if di != dj {
+			return di < dj
+		}
+		return sorted[i] < sorted[j]
+	})
+
+	list := make([]data.OU, 0, len(sorted))
+	for _, path := range sorted {
+		_, name, parent := getEntryAttrValueSuffix(path)
+
+		hasSubordinates := "FALSE"
+		if paths[path] {
+			hasSubordinates = "TRUE"
+		}
+
+		list = append(list, data.OU{
+			EntryUUID:       newEntryUUID(ldaputils.NormalizeEntry(path + "," + baseDN)),
+			HasSubordinates: hasSubordinates,
 			ObjectClass: []string{
 				"top",
 				"organizationalUnit",
 			},
-			OU: groupsOUName,
-		},
+			OU:     name,
+			Parent: parent,
+		})
 	}
 
-	return &data.Entries{
-		Domain: domain,
-		OUs:    ous,
+	return list, errs
+}
+
+// SetSudoRoles places sudo roles without parentOU into ou 'sudoersOUName' and sets objectClass of roles without it
+// returns errors of roles with wrong parentOU, such roles are placed into sudoers ou
+func SetSudoRoles(sudoRoles []data.SudoRole, baseDN, sudoersOUName string) []error {
+	var errs []error
+	for i := range sudoRoles {
+		setDefaultObjClass(&sudoRoles[i].ObjectClass, "top", "sudoRole")
+		if err := setParentOU(&sudoRoles[i].ParentOU, baseDN, sudoersOUName); err != nil {
+			errs = append(errs, fmt.Errorf("sudo role '%s' is placed into ou '%s': %s", sudoRoles[i].CN, sudoersOUName, err))
+		}
+	}
+	return errs
+}
+
+// SetNSSEntries places netgroups, hosts and automount maps of entries 'entries' without parentOU into
+// ous 'netgroupsOUName', 'hostsOUName' and 'automountOUName' and sets objectClass of entries without it
+// automount entries of maps are moved to entries automounts
+// returns errors of entries with wrong parentOU, such entries are placed into default ous
+func SetNSSEntries(entries *data.Entries, baseDN, netgroupsOUName, hostsOUName, automountOUName string) []error {
+	var errs []error
+
+	for i := range entries.Netgroups {
+		netgroup := &entries.Netgroups[i]
+		setDefaultObjClass(&netgroup.ObjectClass, "top", "nisNetgroup")
+		if err := setParentOU(&netgroup.ParentOU, baseDN, netgroupsOUName); err != nil {
+			errs = append(errs, fmt.Errorf("netgroup '%s' is placed into ou '%s': %s", netgroup.CN, netgroupsOUName, err))
+		}
+		netgroup.HasSubordinates = "FALSE"
+	}
+
+	for i := range entries.Hosts {
+		host := &entries.Hosts[i]
+		setDefaultObjClass(&host.ObjectClass, "top", "device", "ipHost")
+		if err := setParentOU(&host.ParentOU, baseDN, hostsOUName); err != nil {
+			errs = append(errs, fmt.Errorf("host '%s' is placed into ou '%s': %s", strings.Join(host.CN, ","), hostsOUName, err))
+		}
+		host.HasSubordinates = "FALSE"
+	}
+
+	entries.Automounts = nil
+	for i := range entries.AutomountMaps {
+		m := &entries.AutomountMaps[i]
+		setDefaultObjClass(&m.ObjectClass, "top", "automountMap")
+		if err := setParentOU(&m.ParentOU, baseDN, automountOUName); err != nil {
+			errs = append(errs, fmt.Errorf("automount map '%s' is placed into ou '%s': %s", m.AutomountMapName, automountOUName, err))
+		}
+
+		m.HasSubordinates = "FALSE"
+		if len(m.Automounts) > 0 {
+			m.HasSubordinates = "TRUE"
+		}
+
+		for _, automount := range m.Automounts {
+			setDefaultObjClass(&automount.ObjectClass, "top", "automount")
+			automount.HasSubordinates = "FALSE"
+			automount.Parent = fmt.Sprintf("automountMapName=%s,%s", ldaputils.EscapeValue(m.AutomountMapName), m.ParentOU)
+			entries.Automounts = append(entries.Automounts, automount)
+		}
+		m.Automounts = nil
+	}
+
+	return errs
+}
+
+// setDefaultObjClass sets objectClass 'objectClass' to 'values' if it is empty
+func setDefaultObjClass(objectClass *[]string, values ...string) {
+	if len(*objectClass) == 0 {
+		*objectClass = values
+	}
+}
+
+// setParentOU normalizes entry's parentOU 'parentOU', it is set to ou 'defaultOUName' if empty or wrong
+func setParentOU(parentOU *string, baseDN, defaultOUName string) error {
+	defaultPath := ldaputils.OUPath(defaultOUName)
+	if len(*parentOU) == 0 {
+		*parentOU = defaultPath
+		return nil
+	}
+	path, err := ldaputils.NormalizeOUPath(*parentOU, baseDN)
+	if err != nil {
+		*parentOU = defaultPath
+		return err
+	}
+	*parentOU = path
+	return nil
+}
+
+// getParentOU returns path of ous containing entry 'o' relative to base dn
+// default users or groups ou path is returned with error if entry's parentOU is wrong
+func getParentOU(o interface{}, baseDN, usersOUName, groupsOUName string) (string, error) {
+	var parentOU, defaultPath string
+	switch o := o.(type) {
+	case data.User:
+		parentOU, defaultPath = o.ParentOU, ldaputils.OUPath(usersOUName)
+	case data.Group:
+		parentOU, defaultPath = o.ParentOU, ldaputils.OUPath(groupsOUName)
+	default:
+		return "", nil
+	}
+
+	if len(parentOU) == 0 {
+		return defaultPath, nil
+	}
+	path, err := ldaputils.NormalizeOUPath(parentOU, baseDN)
+	if err != nil {
+		return defaultPath, err
+	}
+	return path, nil
+}
+
+// findEntry returns entry with name 'name', users are also found by their uid, e.g. uid=admin,ou=users,dc=example,dc=com
+func findEntry(entries *data.Entries, name, baseDN, usersOUName, groupsOUName string) (searchEntry, bool) {
+	name = ldaputils.NormalizeEntry(name)
+	attr, value, suffix := getEntryAttrValueSuffix(name)
+
+	if idx := entries.Index; idx != nil {
+		if i, ok := idx.ByDN[name]; ok {
+			return searchEntry{name: idx.Names[i], o: idx.Objects[i]}, true
+		}
+		if attr != "uid" {
+			return searchEntry{}, false
+		}
+		for _, i := range idx.ByValue["uid"][getIndexKey(value)] {
+			user, ok := idx.Objects[i].(data.User)
+			if !ok || !strings.EqualFold(user.UID, value) {
+				continue
+			}
+			if _, _, entrySuffix := getEntryAttrValueSuffix(ldaputils.NormalizeEntry(idx.Names[i])); entrySuffix == suffix {
+				return searchEntry{name: idx.Names[i], o: idx.Objects[i]}, true
+			}
+		}
+		return searchEntry{}, false
 	}
+
+	for _, e := range listEntries(entries, baseDN, usersOUName, groupsOUName) {
+		entryName := ldaputils.NormalizeEntry(e.name)
+		if entryName == name {
+			return e, true
+		}
+		if user, ok := e.o.(data.User); ok && attr == "uid" && strings.EqualFold(user.UID, value) {
+			if _, _, entrySuffix := getEntryAttrValueSuffix(entryName); entrySuffix == suffix {
+				return e, true
+			}
+		}
+	}
+	return searchEntry{}, false
+}
+
+// SetEntryUUIDs sets entryUUID of entries 'entries' which have none, uuid is generated from entry dn
+// returns errors of entries having the same entryUUID
+func SetEntryUUIDs(entries *data.Entries, baseDN, usersOUName, groupsOUName string) []error {
+	v := reflect.ValueOf(entries).Elem()
+	for i := 0; i < v.NumField(); i++ {
+		list := v.Field(i)
+		if list.Kind() != reflect.Slice {
+			continue
+		}
+		for j := 0; j < list.Len(); j++ {
+			e := list.Index(j)
+			uuidField := e.FieldByName("EntryUUID")
+			if f := e.FieldByName("BackendUUID"); f.IsValid() {
+				f.SetString(uuidField.String())
+			}
+			if len(uuidField.String()) == 0 {
+				name := getEntryName(e.Interface(), baseDN, usersOUName, groupsOUName)
+				uuidField.SetString(newEntryUUID(ldaputils.NormalizeEntry(name)))
+			}
+		}
+	}
+
+	var errs []error
+	names := make(map[string]string)
+	for _, e := range listEntries(entries, baseDN, usersOUName, groupsOUName) {
+		uuid := strings.ToLower(getEntryUUID(e.o))
+		if len(uuid) == 0 {
+			continue
+		}
+		if name, ok := names[uuid]; ok {
+			errs = append(errs, fmt.Errorf("entries '%s' and '%s' have the same entryUUID '%s'", name, e.name, uuid))
+			continue
+		}
+		names[uuid] = e.name
+	}
+
+	return errs
 }
 
-// newEntryUUID creates uuid5 from NameSpaceOID and entry name
+// newEntryUUID creates uuid5 from NameSpaceOID and normalized entry dn 'name'
 func newEntryUUID(name string) string {
 	return uuid.NewSHA1(uuid.NameSpaceOID, []byte(name)).String()
 }
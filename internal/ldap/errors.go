@@ -21,4 +21,30 @@ var (
 		ldap.ResultCodeInvalidAttributeSyntax,
 		errors.New("attempt to set multiple values on single value attribute"),
 	}
+
+	errLDAPNoValue error = LDAPError{
+		ldap.ResultCodeNoSuchAttribute,
+		errors.New("target entry does not have requested attribute value"),
+	}
+
+	errLDAPValueExists error = LDAPError{
+		ldap.ResultCodeAttributeOrValueExists,
+		errors.New("target entry already has requested attribute value"),
+	}
+
+	errLDAPVirtualAttr error = LDAPError{
+		ldap.ResultCodeConstraintViolation,
+		errors.New("attribute is computed and can not be modified"),
+	}
+
+	errLDAPOperationalAttr error = LDAPError{
+		ldap.ResultCodeConstraintViolation,
+		errors.New("attribute is operational and can not be modified"),
+	}
+)
+
+var (
+	errSearchAbandoned   = errors.New("search abandoned")
+	errTimeLimitExceeded = errors.New("time limit exceeded")
+	errAssertionFailed   = errors.New("assertion failed")
 )
@@ -0,0 +1,263 @@
+package ldap
+
+import (
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strings"
+
+	ldap "github.com/ps78674/goldap/message"
+	"github.com/ps78674/gorestldap/internal/ber"
+)
+
+// filter choices (RFC 4511)
+const (
+	filterAnd             = 0
+	filterOr              = 1
+	filterNot             = 2
+	filterEqualityMatch   = 3
+	filterSubstrings      = 4
+	filterGreaterOrEqual  = 5
+	filterLessOrEqual     = 6
+	filterPresent         = 7
+	filterApproxMatch     = 8
+	filterExtensibleMatch = 9
+)
+
+var errFilterSyntax = errors.New("filter syntax error")
+
+// compileFilter returns filter from its string representation 's' (RFC 4515)
+func compileFilter(s string) (ldap.Filter, error) {
+	s = strings.TrimSpace(s)
+	// parentheses are optional around outermost filter
+	if !strings.HasPrefix(s, "(") {
+		s = "(" + s + ")"
+	}
+
+	p, n, err := compileFilterPacket(s)
+	if err != nil {
+		return nil, fmt.Errorf("error compiling filter '%s': %s", s, err)
+	}
+	if n != len(s) {
+		return nil, fmt.Errorf("error compiling filter '%s': %s", s, errFilterSyntax)
+	}
+
+	return decodeFilter(p)
+}
+
+// compileFilterPacket returns BER packet of filter at the beginning of 's' and its length
+//
+//	filter         = LPAREN filtercomp RPAREN
+//	filtercomp     = and / or / not / item
+//	and            = AMPERSAND filterlist
+//	or             = VERTBAR filterlist
+//	not            = EXCLAMATION filter
+//	filterlist     = 1*filter
+func compileFilterPacket(s string) (*ber.Packet, int, error) {
+	if len(s) < 2 || s[0] != '(' {
+		return nil, 0, errFilterSyntax
+	}
+
+	switch s[1] {
+	case '&', '|':
+		tag := filterAnd
+		if s[1] == '|' {
+			tag = filterOr
+		}
+		p := ber.NewConstructed(ber.ClassContext, tag)
+		pos := 2
+		for pos < len(s) && s[pos] == '(' {
+			child, n, err := compileFilterPacket(s[pos:])
+			if err != nil {
+				return nil, 0, err
+			}
+			p.Append(child)
+			pos += n
+		}
+		if len(p.Children) == 0 || pos >= len(s) || s[pos] != ')' {
+			return nil, 0, errFilterSyntax
+		}
+		return p, pos + 1, nil
+	case '!':
+		child, n, err := compileFilterPacket(s[2:])
+		if err != nil {
+			return nil, 0, err
+		}
+		pos := 2 + n
+		if pos >= len(s) || s[pos] != ')' {
+			return nil, 0, errFilterSyntax
+		}
+		return ber.NewConstructed(ber.ClassContext, filterNot, child), pos + 1, nil
+	}
+
+	end := strings.IndexByte(s, ')')
+	if end < 0 {
+		return nil, 0, errFilterSyntax
+	}
+	p, err := compileFilterItem(s[1:end])
+	if err != nil {
+		return nil, 0, err
+	}
+
+	return p, end + 1, nil
+}
+
+// compileFilterItem returns BER packet of filter item 's'
+//
+//	item           = simple / present / substring / extensible
+//	simple         = attr filtertype assertionvalue
+//	filtertype     = equal / approx / greaterorequal / lessorequal
+//	extensible     = ( attr [dnattrs] [matchingrule] COLON EQUALS assertionvalue )
+//	                 / ( [dnattrs] matchingrule COLON EQUALS assertionvalue )
+//	present        = attr EQUALS ASTERISK
+//	substring      = attr EQUALS [initial] any [final]
+func compileFilterItem(s string) (*ber.Packet, error) {
+	i := strings.IndexByte(s, '=')
+	if i < 1 {
+		return nil, errFilterSyntax
+	}
+	attr, value := s[:i], s[i+1:]
+
+	tag := filterEqualityMatch
+	switch attr[len(attr)-1] {
+	case '~':
+		tag = filterApproxMatch
+	case '>':
+		tag = filterGreaterOrEqual
+	case '<':
+		tag = filterLessOrEqual
+	case ':':
+		tag = filterExtensibleMatch
+	}
+	if tag != filterEqualityMatch {
+		attr = attr[:len(attr)-1]
+		if len(attr) == 0 && tag != filterExtensibleMatch {
+			return nil, errFilterSyntax
+		}
+	}
+
+	if tag == filterExtensibleMatch {
+		return compileExtensibleMatch(attr, value)
+	}
+
+	if tag == filterEqualityMatch {
+		if value == "*" {
+			return ber.NewPrimitive(ber.ClassContext, filterPresent, []byte(attr)), nil
+		}
+		if strings.Contains(value, "*") {
+			return compileSubstrings(attr, value)
+		}
+	}
+
+	v, err := unescapeFilterValue(value)
+	if err != nil {
+		return nil, err
+	}
+
+	return ber.NewConstructed(ber.ClassContext, tag, ber.NewOctetString(attr), ber.NewOctetString(v)), nil
+}
+
+// compileSubstrings returns BER packet of substrings filter item
+//
+//	SubstringFilter ::= SEQUENCE {
+//	     type           AttributeDescription,
+//	     substrings     SEQUENCE SIZE (1..MAX) OF substring CHOICE {
+//	          initial [0] AssertionValue,  -- can occur at most once
+//	          any     [1] AssertionValue,
+//	          final   [2] AssertionValue } -- can occur at most once
+//	     }
+func compileSubstrings(attr, value string) (*ber.Packet, error) {
+	substrings := ber.NewSequence()
+	parts := strings.Split(value, "*")
+	for i, part := range parts {
+		if len(part) == 0 {
+			continue
+		}
+		v, err := unescapeFilterValue(part)
+		if err != nil {
+			return nil, err
+		}
+		choice := 1
+		switch i {
+		case 0:
+			choice = 0
+		case len(parts) - 1:
+			choice = 2
+		}
+		substrings.Append(ber.NewPrimitive(ber.ClassContext, choice, []byte(v)))
+	}
+	if len(substrings.Children) == 0 {
+		return nil, errFilterSyntax
+	}
+
+	return ber.NewConstructed(ber.ClassContext, filterSubstrings, ber.NewOctetString(attr), substrings), nil
+}
+
+// compileExtensibleMatch returns BER packet of extensible match filter item with description 'desc', e.g. 'member:dn:1.2.3'
+//
+//	MatchingRuleAssertion ::= SEQUENCE {
+//	     matchingRule    [1] MatchingRuleId OPTIONAL,
+//	     type            [2] AttributeDescription OPTIONAL,
+//	     matchValue      [3] AssertionValue,
+//	     dnAttributes    [4] BOOLEAN DEFAULT FALSE }
+func compileExtensibleMatch(desc, value string) (*ber.Packet, error) {
+	parts := strings.Split(desc, ":")
+
+	var attr, rule string
+	var dnAttributes bool
+	attr, parts = parts[0], parts[1:]
+	if len(parts) > 0 && strings.EqualFold(parts[0], "dn") {
+		dnAttributes = true
+		parts = parts[1:]
+	}
+	if len(parts) > 0 {
+		rule, parts = parts[0], parts[1:]
+	}
+	if len(parts) > 0 || (len(attr) == 0 && len(rule) == 0) {
+		return nil, errFilterSyntax
+	}
+
+	v, err := unescapeFilterValue(value)
+	if err != nil {
+		return nil, err
+	}
+
+	p := ber.NewConstructed(ber.ClassContext, filterExtensibleMatch)
+	if len(rule) > 0 {
+		p.Append(ber.NewPrimitive(ber.ClassContext, 1, []byte(rule)))
+	}
+	if len(attr) > 0 {
+		p.Append(ber.NewPrimitive(ber.ClassContext, 2, []byte(attr)))
+	}
+	p.Append(ber.NewPrimitive(ber.ClassContext, 3, []byte(v)))
+	// goldap fails to decode assertion without dnAttributes -> always set it
+	p.Append(ber.NewPrimitive(ber.ClassContext, 4, ber.NewBoolean(dnAttributes).Value))
+
+	return p, nil
+}
+
+// unescapeFilterValue returns assertion value 's' with '\XX' escapes replaced by bytes
+func unescapeFilterValue(s string) (string, error) {
+	if strings.ContainsAny(s, "()*") {
+		return "", errFilterSyntax
+	}
+
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		if s[i] != '\\' {
+			b.WriteByte(s[i])
+			continue
+		}
+		if i+3 > len(s) {
+			return "", errFilterSyntax
+		}
+		c, err := hex.DecodeString(s[i+1 : i+3])
+		if err != nil {
+			return "", errFilterSyntax
+		}
+		b.Write(c)
+		i += 2
+	}
+
+	return b.String(), nil
+}
@@ -0,0 +1,117 @@
+package ldap
+
+import (
+	"sort"
+	"strings"
+
+	ldap "github.com/ps78674/goldap/message"
+	"github.com/ps78674/gorestldap/internal/data"
+	"github.com/ps78674/gorestldap/internal/ldaputils"
+)
+
+// attributes which values are indexed, lowercase
+var indexedAttributes = []string{
+	"uid",
+	"cn",
+	"uidnumber",
+	"gidnumber",
+	"mail",
+	"memberuid",
+	"member",
+	"uniquemember",
+	"memberof",
+}
+
+// IndexEntries builds lookup index of entries 'entries', it must be rebuilt when entries are changed
+func IndexEntries(entries *data.Entries, baseDN, usersOUName, groupsOUName string) {
+	entries.Index = nil
+	list := listEntries(entries, baseDN, usersOUName, groupsOUName)
+
+	idx := &data.Index{
+		Names:   make([]string, 0, len(list)),
+		Objects: make([]interface{}, 0, len(list)),
+		ByDN:    make(map[string]int, len(list)),
+		ByValue: make(map[string]map[string][]int, len(indexedAttributes)),
+	}
+	for _, attrName := range indexedAttributes {
+		idx.ByValue[attrName] = make(map[string][]int)
+	}
+
+	for i, e := range list {
+		idx.Names = append(idx.Names, e.name)
+		idx.Objects = append(idx.Objects, e.o)
+
+		// first entry wins as with linear lookup
+		name := ldaputils.NormalizeEntry(e.name)
+		if _, ok := idx.ByDN[name]; !ok {
+			idx.ByDN[name] = i
+		}
+
+		for _, attrName := range indexedAttributes {
+			attr, found := getAttribute(e.o, attrName)
+			if !found {
+				continue
+			}
+			byValue := idx.ByValue[attrName]
+			for _, v := range attr.values {
+				key := getIndexKey(v)
+				if positions := byValue[key]; len(positions) == 0 || positions[len(positions)-1] != i {
+					byValue[key] = append(positions, i)
+				}
+			}
+		}
+	}
+
+	entries.Index = idx
+}
+
+// getIndexKey returns index key of attribute value 'v', values equal under any attribute matching rule have the same key
+func getIndexKey(v string) string {
+	return ldaputils.NormalizeEntry(v)
+}
+
+// getIndexCandidates returns sorted positions of indexed entries which may match filter 'f',
+// false is returned if filter can not be resolved with index and all entries have to be checked
+func getIndexCandidates(idx *data.Index, f ldap.Filter) ([]int, bool) {
+	switch filter := f.(type) {
+	case ldap.FilterEqualityMatch:
+		attrName := getAttributeType(string(filter.AttributeDesc()))
+		byValue, ok := idx.ByValue[strings.ToLower(attrName)]
+		if !ok {
+			return nil, false
+		}
+		return byValue[getIndexKey(string(filter.AssertionValue()))], true
+	case ldap.FilterAnd:
+		// most selective indexed filter, other filters are checked on its candidates
+		var candidates []int
+		var found bool
+		for _, _filter := range filter {
+			positions, ok := getIndexCandidates(idx, _filter)
+			if !ok {
+				continue
+			}
+			if !found || len(positions) < len(candidates) {
+				candidates, found = positions, true
+			}
+		}
+		return candidates, found
+	case ldap.FilterOr:
+		seen := make(map[int]struct{})
+		var candidates []int
+		for _, _filter := range filter {
+			positions, ok := getIndexCandidates(idx, _filter)
+			if !ok {
+				return nil, false
+			}
+			for _, i := range positions {
+				if _, ok := seen[i]; !ok {
+					seen[i] = struct{}{}
+					candidates = append(candidates, i)
+				}
+			}
+		}
+		sort.Ints(candidates)
+		return candidates, true
+	}
+	return nil, false
+}
@@ -0,0 +1,123 @@
+package ldap
+
+import (
+	"reflect"
+	"testing"
+
+	ldap "github.com/ps78674/goldap/message"
+	"github.com/ps78674/gorestldap/internal/ber"
+	"github.com/ps78674/gorestldap/internal/data"
+	"github.com/ps78674/gorestldap/internal/ldaputils"
+)
+
+// newIndexTestEntries returns entries with users, groups and their ous, users and groups are nested by dn values
+func newIndexTestEntries() *data.Entries {
+	entries := GetEntries(testBaseDN)
+	entries.Users = []data.User{
+		{CN: "user01", UID: "user01", UIDNumber: 1000, GIDNumber: 1000, Mail: "User01@example.com", MemberOf: []string{"cn=admins,ou=groups,dc=example,dc=com"}},
+		{CN: "user02", UID: "user02", UIDNumber: 1001, GIDNumber: 1000, Mail: "user02@example.com", MemberOf: []string{"CN=Admins, OU=groups,dc=example,dc=com", "cn=Smith\\2C John,ou=groups,dc=example,dc=com"}},
+		{CN: "Smith, John", UID: "jsmith", UIDNumber: 1002, GIDNumber: 1001, ParentOU: "ou=people"},
+	}
+	entries.Groups = []data.Group{
+		{CN: "admins", GIDNumber: 1000, MemberUID: []string{"user01", "user02"}, Member: []string{"cn=user01,ou=users,dc=example,dc=com", "cn=user02,ou=users,dc=example,dc=com"}},
+		{CN: "Smith, John", GIDNumber: 1001, MemberUID: []string{"jsmith"}, Member: []string{"cn=Smith\\, John,ou=people,dc=example,dc=com"}},
+	}
+	entries.OUs, _ = GetOUs(entries, testBaseDN, "users", "groups", nil)
+	return entries
+}
+
+// searchNames returns normalized names of entries within scope 'scope' of 'baseObject' matching filter 'f'
+func searchNames(t *testing.T, entries *data.Entries, baseObject string, scope int, f ldap.Filter) []string {
+	t.Helper()
+	baseObject = ldaputils.NormalizeEntry(baseObject)
+	var names []string
+	for _, e := range getSearchCandidates(entries, baseObject, testBaseDN, "users", "groups", scope, f) {
+		name := ldaputils.NormalizeEntry(e.name)
+		if !isInScope(name, baseObject, scope) {
+			continue
+		}
+		ok, err := applySearchFilter(e.o, f)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if ok {
+			names = append(names, name)
+		}
+	}
+	return names
+}
+
+func TestIndexSearchCandidates(t *testing.T) {
+	indexed, scanned := newIndexTestEntries(), newIndexTestEntries()
+	IndexEntries(indexed, testBaseDN, "users", "groups")
+
+	and := func(items ...*ber.Packet) *ber.Packet { return ber.NewConstructed(ber.ClassContext, 0, items...) }
+	or := func(items ...*ber.Packet) *ber.Packet { return ber.NewConstructed(ber.ClassContext, 1, items...) }
+	present := func(attr string) *ber.Packet { return ber.NewPrimitive(ber.ClassContext, 7, []byte(attr)) }
+
+	filters := []struct {
+		name   string
+		filter *ber.Packet
+	}{
+		{"equality", equalityFilter("uid", "user01")},
+		{"equality case", equalityFilter("UID", "USER01")},
+		{"equality cn with comma", equalityFilter("cn", "smith, john")},
+		{"equality mail", equalityFilter("mail", "user01@EXAMPLE.com")},
+		{"equality number", equalityFilter("gidNumber", "1000")},
+		{"equality number leading zero", equalityFilter("uidNumber", "01000")},
+		{"equality dn", equalityFilter("member", "CN=user01, OU=Users,DC=example,DC=com")},
+		{"equality escaped dn", equalityFilter("memberOf", "cn=smith\\, john,ou=groups,dc=example,dc=com")},
+		{"equality hex escaped dn", equalityFilter("member", "cn=Smith\\2c John,ou=people,dc=example,dc=com")},
+		{"equality not indexed", equalityFilter("objectClass", "posixAccount")},
+		{"equality no match", equalityFilter("uid", "user03")},
+		{"and", and(equalityFilter("gidNumber", "1000"), equalityFilter("uidNumber", "1001"))},
+		{"and not indexed", and(equalityFilter("objectClass", "posixAccount"), equalityFilter("memberOf", "cn=admins,ou=groups,dc=example,dc=com"))},
+		{"and no indexed", and(present("objectClass"), equalityFilter("objectClass", "posixGroup"))},
+		{"or", or(equalityFilter("uid", "user01"), equalityFilter("cn", "admins"), equalityFilter("member", "cn=user02,ou=users,dc=example,dc=com"))},
+		{"or not indexed", or(equalityFilter("uid", "user01"), equalityFilter("objectClass", "organizationalUnit"))},
+		{"or in and", and(equalityFilter("objectClass", "posixAccount"), or(equalityFilter("uid", "jsmith"), equalityFilter("uid", "user02")))},
+		{"present", present("objectClass")},
+	}
+
+	bases := []string{
+		testBaseDN,
+		"ou=users,dc=example,dc=com",
+		"OU=People, dc=example,dc=com",
+		"cn=user01,ou=users,dc=example,dc=com",
+		"cn=Smith\\2C John,ou=people,dc=example,dc=com",
+		"cn=admins,ou=groups,dc=example,dc=com",
+		"uid=user01,ou=users,dc=example,dc=com",
+		"uid=jsmith,ou=people,dc=example,dc=com",
+		"ou=missing,dc=example,dc=com",
+	}
+
+	scopes := []struct {
+		name  string
+		scope int
+	}{
+		{"base", ldap.SearchRequestScopeBaseObject},
+		{"one", ldap.SearchRequestScopeOneLevel},
+		{"sub", ldap.SearchRequestScopeSubtree},
+		{"children", ldap.SearchRequestScopeChildren},
+	}
+
+	for _, ft := range filters {
+		f := mustDecodeFilter(t, ft.filter)
+		for _, base := range bases {
+			for _, st := range scopes {
+				t.Run(ft.name+"/"+st.name+"/"+base, func(t *testing.T) {
+					got := searchNames(t, indexed, base, st.scope, f)
+					want := searchNames(t, scanned, base, st.scope, f)
+					if !reflect.DeepEqual(got, want) {
+						t.Errorf("indexed search = %v, full scan = %v", got, want)
+					}
+				})
+			}
+		}
+	}
+
+	// comparison is not vacuous
+	if got := searchNames(t, indexed, testBaseDN, ldap.SearchRequestScopeSubtree, mustDecodeFilter(t, equalityFilter("member", "cn=Smith\\2c John,ou=people,dc=example,dc=com"))); len(got) != 1 {
+		t.Errorf("search of escaped member dn = %v, want 1 entry", got)
+	}
+}
@@ -0,0 +1,496 @@
+package ldap
+
+import (
+	"fmt"
+	"net/url"
+	"reflect"
+	"strings"
+
+	ldap "github.com/ps78674/goldap/message"
+	"github.com/ps78674/gorestldap/internal/config"
+	"github.com/ps78674/gorestldap/internal/data"
+	"github.com/ps78674/gorestldap/internal/ldaputils"
+)
+
+// SetMembership computes virtual membership attributes: memberOf of users and groups, member and uniqueMember of groups
+// membership is taken both from groups memberUid and users memberOf, values are entry names or dns if 'dnValues' is set
+// groups are nested with groups memberGroup or with memberUid values which are group dns, memberOf is transitive
+// dynamic groups members are users matching groups memberURL, groups are not evaluated against memberURL
+// returns errors of groups which are members of themselves (nesting cycles) or have wrong memberURL
+func SetMembership(users []data.User, groups []data.Group, baseDN, usersOUName, groupsOUName string, dnValues bool) []error {
+	userByUID := make(map[string]int, len(users))
+	userByDN := make(map[string]int, len(users))
+	for i, user := range users {
+		userByUID[strings.ToLower(user.UID)] = i
+		userByDN[ldaputils.NormalizeEntry(getEntryName(user, baseDN, usersOUName, groupsOUName))] = i
+	}
+	groupByCN := make(map[string]int, len(groups))
+	groupByDN := make(map[string]int, len(groups))
+	for i, group := range groups {
+		groupByCN[strings.ToLower(group.CN)] = i
+		groupByDN[ldaputils.NormalizeEntry(getEntryName(group, baseDN, usersOUName, groupsOUName))] = i
+	}
+
+	userValue := func(uid string) string {
+		if i, ok := userByUID[strings.ToLower(uid)]; ok {
+			if dnValues {
+				return getEntryName(users[i], baseDN, usersOUName, groupsOUName)
+			}
+			return users[i].UID
+		}
+		if dnValues {
+			return getEntryName(data.User{CN: uid}, baseDN, usersOUName, groupsOUName)
+		}
+		return uid
+	}
+	groupValue := func(cn string) string {
+		group := data.Group{CN: cn}
+		if i, ok := groupByCN[strings.ToLower(cn)]; ok {
+			group = groups[i]
+		}
+		if dnValues {
+			return getEntryName(group, baseDN, usersOUName, groupsOUName)
+		}
+		return group.CN
+	}
+	// groupName returns cn of group referenced by name or dn 'v'
+	groupName := func(v string) string {
+		if i, ok := groupByDN[ldaputils.NormalizeEntry(v)]; ok {
+			return groups[i].CN
+		}
+		return v
+	}
+
+	// user uid -> group cns, group cn -> user uids
+	memberOf := make(map[string]*orderedSet)
+	members := make(map[string]*orderedSet)
+	addMembership := func(uid, cn string) {
+		uidKey, cnKey := strings.ToLower(uid), strings.ToLower(cn)
+		if memberOf[uidKey] == nil {
+			memberOf[uidKey] = newOrderedSet()
+		}
+		memberOf[uidKey].add(cn)
+		if members[cnKey] == nil {
+			members[cnKey] = newOrderedSet()
+		}
+		members[cnKey].add(uid)
+	}
+
+	// group cn -> nested group cns, group cn -> parent group cns
+	memberGroups := make(map[string]*orderedSet)
+	parentGroups := make(map[string]*orderedSet)
+	addNesting := func(cn, parentCN string) {
+		cnKey, parentKey := strings.ToLower(cn), strings.ToLower(parentCN)
+		if memberGroups[parentKey] == nil {
+			memberGroups[parentKey] = newOrderedSet()
+		}
+		memberGroups[parentKey].add(cn)
+		if parentGroups[cnKey] == nil {
+			parentGroups[cnKey] = newOrderedSet()
+		}
+		parentGroups[cnKey].add(parentCN)
+	}
+
+	for _, group := range groups {
+		for _, uid := range group.MemberUID {
+			// dn valued member
+			if i, ok := groupByDN[ldaputils.NormalizeEntry(uid)]; ok {
+				addNesting(groups[i].CN, group.CN)
+				continue
+			}
+			if i, ok := userByDN[ldaputils.NormalizeEntry(uid)]; ok {
+				uid = users[i].UID
+			}
+			addMembership(uid, group.CN)
+		}
+		for _, cn := range group.MemberGroup {
+			addNesting(groupName(cn), group.CN)
+		}
+	}
+	for _, user := range users {
+		for _, cn := range user.MemberOf {
+			addMembership(user.UID, groupName(cn))
+		}
+	}
+
+	// dynamic groups
+	var errs []error
+	for _, group := range groups {
+		for _, memberURL := range group.MemberURL {
+			u, err := parseMemberURL(memberURL, baseDN)
+			if err != nil {
+				errs = append(errs, fmt.Errorf("group '%s': %s", group.CN, err))
+				continue
+			}
+			for _, user := range users {
+				if !isInScope(ldaputils.NormalizeEntry(getEntryName(user, baseDN, usersOUName, groupsOUName)), u.baseObject, u.scope) {
+					continue
+				}
+				ok, err := applySearchFilter(user, u.filter)
+				if err != nil {
+					errs = append(errs, fmt.Errorf("group '%s': error applying memberURL '%s': %s", group.CN, memberURL, err))
+					break
+				}
+				if ok {
+					addMembership(user.UID, group.CN)
+				}
+			}
+		}
+	}
+
+	// transitive parents and members of group 'cn', cycles are stopped by visited groups
+	var walk func(cn string, edges map[string]*orderedSet, visited *orderedSet)
+	walk = func(cn string, edges map[string]*orderedSet, visited *orderedSet) {
+		set := edges[strings.ToLower(cn)]
+		if set == nil {
+			return
+		}
+		for _, next := range set.values {
+			if visited.has(next) {
+				continue
+			}
+			visited.add(next)
+			walk(next, edges, visited)
+		}
+	}
+	ancestors := make(map[string]*orderedSet)
+	getAncestors := func(cn string) *orderedSet {
+		key := strings.ToLower(cn)
+		if set, ok := ancestors[key]; ok {
+			return set
+		}
+		set := newOrderedSet()
+		walk(cn, parentGroups, set)
+		ancestors[key] = set
+		return set
+	}
+
+	for i := range users {
+		// keep backend value for updates
+		users[i].BackendMemberOf = users[i].MemberOf
+		users[i].MemberOf = nil
+		if set := memberOf[strings.ToLower(users[i].UID)]; set != nil {
+			transitive := newOrderedSet()
+			for _, cn := range set.values {
+				transitive.add(cn)
+				for _, parentCN := range getAncestors(cn).values {
+					transitive.add(parentCN)
+				}
+			}
+			for _, cn := range transitive.values {
+				users[i].MemberOf = append(users[i].MemberOf, groupValue(cn))
+			}
+		}
+	}
+
+	for i := range groups {
+		groups[i].MemberOf = nil
+		for _, cn := range getAncestors(groups[i].CN).values {
+			if strings.EqualFold(cn, groups[i].CN) {
+				errs = append(errs, fmt.Errorf("group '%s' is nested within itself", groups[i].CN))
+				continue
+			}
+			groups[i].MemberOf = append(groups[i].MemberOf, groupValue(cn))
+		}
+
+		// keep backend value for updates
+		groups[i].BackendMemberUID = groups[i].MemberUID
+		groups[i].MemberUID = nil
+		groups[i].Member = nil
+		if set := members[strings.ToLower(groups[i].CN)]; set != nil {
+			for _, uid := range set.values {
+				groups[i].MemberUID = append(groups[i].MemberUID, uid)
+				groups[i].Member = append(groups[i].Member, userValue(uid))
+			}
+		}
+		if set := memberGroups[strings.ToLower(groups[i].CN)]; set != nil {
+			for _, cn := range set.values {
+				groups[i].Member = append(groups[i].Member, groupValue(cn))
+			}
+		}
+		groups[i].UniqueMember = groups[i].Member
+
+		// members of nested groups
+		groups[i].TransitiveMember = nil
+		descendants := newOrderedSet()
+		descendants.add(groups[i].CN)
+		walk(groups[i].CN, memberGroups, descendants)
+		transitive := newOrderedSet()
+		for _, cn := range descendants.values {
+			if set := members[strings.ToLower(cn)]; set != nil {
+				for _, uid := range set.values {
+					transitive.add(userValue(uid))
+				}
+			}
+			if !strings.EqualFold(cn, groups[i].CN) {
+				transitive.add(groupValue(cn))
+			}
+		}
+		groups[i].TransitiveMember = transitive.values
+	}
+
+	return errs
+}
+
+// memberURL is a dynamic group members search (RFC 4516 LDAP URL)
+type memberURL struct {
+	baseObject string
+	scope      int
+	filter     ldap.Filter
+}
+
+// parseMemberURL parses dynamic group memberURL 's', e.g. 'ldap:///ou=users,dc=example,dc=com??sub?(loginShell=/bin/bash)'
+// empty base dn is set to 'baseDN', empty filter matches all entries
+//
+//	ldapurl     = scheme COLON SLASH SLASH [host [COLON port]] [SLASH dn [QUESTION [attributes] [QUESTION [scope] [QUESTION [filter]]]]]
+func parseMemberURL(s, baseDN string) (memberURL, error) {
+	u := memberURL{scope: ldap.SearchRequestScopeBaseObject}
+
+	if !strings.HasPrefix(strings.ToLower(s), "ldap://") {
+		return u, fmt.Errorf("wrong memberURL '%s': scheme must be 'ldap'", s)
+	}
+	rest := s[len("ldap://"):]
+	// host is ignored, entries are searched on this server
+	_, rest, _ = strings.Cut(rest, "/")
+
+	parts := strings.SplitN(rest, "?", 4)
+	for len(parts) < 4 {
+		parts = append(parts, "")
+	}
+
+	dn, err := url.PathUnescape(parts[0])
+	if err != nil {
+		return u, fmt.Errorf("wrong memberURL '%s': %s", s, err)
+	}
+	u.baseObject = ldaputils.NormalizeEntry(dn)
+	if len(u.baseObject) == 0 {
+		u.baseObject = baseDN
+	}
+
+	switch strings.ToLower(parts[2]) {
+	case "", "base":
+	case "one":
+		u.scope = ldap.SearchRequestScopeOneLevel
+	case "sub":
+		u.scope = ldap.SearchRequestScopeSubtree
+	default:
+		return u, fmt.Errorf("wrong memberURL '%s': unknown scope '%s'", s, parts[2])
+	}
+
+	filter, err := url.PathUnescape(parts[3])
+	if err != nil {
+		return u, fmt.Errorf("wrong memberURL '%s': %s", s, err)
+	}
+	if len(filter) == 0 {
+		filter = "(objectClass=*)"
+	}
+	if u.filter, err = compileFilter(filter); err != nil {
+		return u, fmt.Errorf("wrong memberURL '%s': %s", s, err)
+	}
+
+	return u, nil
+}
+
+// SetGroupSchema sets objectClasses and computed membership attributes of groups according to schema 'schema'
+// memberUid is set from backend if it is not computed, other attributes which are not computed are removed
+func SetGroupSchema(groups []data.Group, schema config.GroupSchema) {
+	for i := range groups {
+		if !schema.HasAttribute("memberUid") {
+			groups[i].MemberUID = groups[i].BackendMemberUID
+		}
+		if !schema.HasAttribute("member") {
+			groups[i].Member = nil
+		}
+		if !schema.HasAttribute("uniqueMember") {
+			groups[i].UniqueMember = nil
+		}
+		if !schema.HasAttribute("member") && !schema.HasAttribute("uniqueMember") {
+			groups[i].TransitiveMember = nil
+		}
+		if !schema.HasAttribute("memberOf") {
+			groups[i].MemberOf = nil
+		}
+
+		groups[i].BackendObjClass = groups[i].ObjectClass
+		if len(schema.ObjectClasses) > 0 {
+			groups[i].ObjectClass = getGroupObjectClasses(groups[i], schema.ObjectClasses)
+		}
+	}
+}
+
+// getGroupObjectClasses returns objectClasses 'objectClasses' of group 'group' without groupOfNames and groupOfUniqueNames
+// if group has no member or uniqueMember values, which these objectClasses require
+func getGroupObjectClasses(group data.Group, objectClasses []string) []string {
+	var ret []string
+	for _, oc := range objectClasses {
+		if strings.EqualFold(oc, "groupOfNames") && len(group.Member) == 0 {
+			continue
+		}
+		if strings.EqualFold(oc, "groupOfUniqueNames") && len(group.UniqueMember) == 0 {
+			continue
+		}
+		ret = append(ret, oc)
+	}
+	return ret
+}
+
+// checkComputedAttribute returns error if attribute 'attrName' of entry 'o' is computed, users memberOf is computed if 'computedMemberOf' is set
+// and groups attributes with group schema 'schema', such attributes are not stored in backend and can not be modified
+func checkComputedAttribute(o interface{}, attrName string, computedMemberOf bool, schema config.GroupSchema) error {
+	attrType := getAttributeType(attrName)
+	switch o.(type) {
+	case data.User:
+		if strings.EqualFold(attrType, "memberOf") && computedMemberOf {
+			return errLDAPVirtualAttr
+		}
+	case data.Group:
+		if strings.EqualFold(attrType, "memberUid") && schema.HasAttribute("memberUid") {
+			return errLDAPVirtualAttr
+		}
+		if strings.EqualFold(attrType, "objectClass") && len(schema.ObjectClasses) > 0 {
+			return errLDAPVirtualAttr
+		}
+	}
+	return nil
+}
+
+// setBackendAttributes copies modified attributes of entry 'newEntry' which are not computed to its backend fields,
+// so getBackendEntry returns them instead of unchanged backend values of entry 'oldEntry'
+func setBackendAttributes(oldEntry, newEntry interface{}) interface{} {
+	switch oldEntry := oldEntry.(type) {
+	case data.User:
+		user := newEntry.(data.User)
+		if !reflect.DeepEqual(oldEntry.MemberOf, user.MemberOf) {
+			user.BackendMemberOf = user.MemberOf
+		}
+		return user
+	case data.Group:
+		group := newEntry.(data.Group)
+		if !reflect.DeepEqual(oldEntry.MemberUID, group.MemberUID) {
+			group.BackendMemberUID = group.MemberUID
+		}
+		if !reflect.DeepEqual(oldEntry.ObjectClass, group.ObjectClass) {
+			group.BackendObjClass = group.ObjectClass
+		}
+		return group
+	}
+	return newEntry
+}
+
+// getBackendEntry returns entry 'o' without computed attributes, as it is stored in backend
+func getBackendEntry(o interface{}) interface{} {
+	switch o := o.(type) {
+	case data.User:
+		o.MemberOf = o.BackendMemberOf
+		o.BackendMemberOf = nil
+		o.ObjectClass = getBackendUserObjClass(o.ObjectClass, o.BackendObjClass)
+		o.BackendObjClass = nil
+		o.HasSubordinates = o.BackendHasSubs
+		o.BackendHasSubs = ""
+		o.EntryUUID = o.BackendUUID
+		o.BackendUUID = ""
+		o.CreateTimestamp = o.BackendTimestamps.CreateTimestamp
+		o.ModifyTimestamp = o.BackendTimestamps.ModifyTimestamp
+		o.CreatorsName = o.BackendTimestamps.CreatorsName
+		o.ModifiersName = o.BackendTimestamps.ModifiersName
+		o.EntryCSN = o.BackendTimestamps.EntryCSN
+		o.BackendTimestamps = data.Timestamps{}
+		return o
+	case data.Group:
+		o.ObjectClass = o.BackendObjClass
+		o.BackendObjClass = nil
+		o.MemberUID = o.BackendMemberUID
+		o.BackendMemberUID = nil
+		o.HasSubordinates = o.BackendHasSubs
+		o.BackendHasSubs = ""
+		o.EntryUUID = o.BackendUUID
+		o.BackendUUID = ""
+		o.CreateTimestamp = o.BackendTimestamps.CreateTimestamp
+		o.ModifyTimestamp = o.BackendTimestamps.ModifyTimestamp
+		o.CreatorsName = o.BackendTimestamps.CreatorsName
+		o.ModifiersName = o.BackendTimestamps.ModifiersName
+		o.EntryCSN = o.BackendTimestamps.EntryCSN
+		o.BackendTimestamps = data.Timestamps{}
+		o.MemberOf = nil
+		o.Member = nil
+		o.UniqueMember = nil
+		o.TransitiveMember = nil
+		return o
+	}
+	return o
+}
+
+// auxiliary objectClass of users holding sshPublicKey (openssh-lpk)
+const ldapPublicKeyObjClass = "ldapPublicKey"
+
+// SetUserSchema adds ldapPublicKey objectClass to users, so they may hold sshPublicKey values
+// memberOf is set from backend if 'computedMemberOf' is not set
+func SetUserSchema(users []data.User, computedMemberOf bool) {
+	for i := range users {
+		if !computedMemberOf {
+			users[i].MemberOf = users[i].BackendMemberOf
+		}
+
+		users[i].BackendObjClass = users[i].ObjectClass
+		if containsFold(users[i].ObjectClass, ldapPublicKeyObjClass) {
+			continue
+		}
+		objectClass := append([]string{}, users[i].ObjectClass...)
+		users[i].ObjectClass = append(objectClass, ldapPublicKeyObjClass)
+	}
+}
+
+// getBackendUserObjClass returns user objectClass 'objectClass' without ldapPublicKey added by SetUserSchema
+// backend objectClass 'backendObjClass' is returned if objectClass was not modified
+func getBackendUserObjClass(objectClass, backendObjClass []string) []string {
+	if containsFold(backendObjClass, ldapPublicKeyObjClass) {
+		return objectClass
+	}
+
+	var list []string
+	for _, v := range objectClass {
+		if !strings.EqualFold(v, ldapPublicKeyObjClass) {
+			list = append(list, v)
+		}
+	}
+	if reflect.DeepEqual(list, backendObjClass) || (len(list) == 0 && len(backendObjClass) == 0) {
+		return backendObjClass
+	}
+	return list
+}
+
+// containsFold returns true if 'list' contains value 'v' in any case
+func containsFold(list []string, v string) bool {
+	for _, s := range list {
+		if strings.EqualFold(s, v) {
+			return true
+		}
+	}
+	return false
+}
+
+// orderedSet is a case insensitive set of strings keeping insertion order
+type orderedSet struct {
+	values []string
+	index  map[string]struct{}
+}
+
+func newOrderedSet() *orderedSet {
+	return &orderedSet{index: make(map[string]struct{})}
+}
+
+// add adds value 'v' if it is not in set
+func (s *orderedSet) add(v string) {
+	key := strings.ToLower(v)
+	if _, ok := s.index[key]; ok {
+		return
+	}
+	s.index[key] = struct{}{}
+	s.values = append(s.values, v)
+}
+
+// has returns true if value 'v' is in set
+func (s *orderedSet) has(v string) bool {
+	_, ok := s.index[strings.ToLower(v)]
+	return ok
+}
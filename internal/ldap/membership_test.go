@@ -0,0 +1,124 @@
+package ldap
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/ps78674/gorestldap/internal/config"
+	"github.com/ps78674/gorestldap/internal/data"
+)
+
+func TestSetGroupSchema(t *testing.T) {
+	schema := config.GroupSchema{
+		Mode:          config.GroupSchemaRFC2307bis,
+		ObjectClasses: []string{"top", "posixGroup", "groupOfNames"},
+		Attributes:    []string{"memberUid", "member", "memberOf"},
+	}
+
+	groups := []data.Group{
+		{CN: "group01", ObjectClass: []string{"posixGroup"}, BackendMemberUID: []string{"user01"}, MemberUID: []string{"user01"}, Member: []string{"cn=user01,ou=users,dc=example,dc=com"}},
+		{CN: "group02", ObjectClass: []string{"posixGroup"}},
+	}
+	SetGroupSchema(groups, schema)
+
+	if want := []string{"top", "posixGroup", "groupOfNames"}; !reflect.DeepEqual(groups[0].ObjectClass, want) {
+		t.Errorf("objectClass = %v, want %v", groups[0].ObjectClass, want)
+	}
+	// groupOfNames requires member
+	if want := []string{"top", "posixGroup"}; !reflect.DeepEqual(groups[1].ObjectClass, want) {
+		t.Errorf("objectClass of empty group = %v, want %v", groups[1].ObjectClass, want)
+	}
+	for _, group := range groups {
+		if !reflect.DeepEqual(group.BackendObjClass, []string{"posixGroup"}) {
+			t.Errorf("%s: backend objectClass = %v", group.CN, group.BackendObjClass)
+		}
+	}
+}
+
+func TestSetMembership(t *testing.T) {
+	newEntries := func() ([]data.User, []data.Group) {
+		users := []data.User{
+			{CN: "user01", UID: "user01"},
+			{CN: "user02", UID: "user02", MemberOf: []string{"group03"}},
+			{CN: "user03", UID: "user03", LoginShell: "/bin/bash"},
+		}
+		groups := []data.Group{
+			{CN: "group01", MemberUID: []string{"user01"}, MemberGroup: []string{"group02"}},
+			{CN: "group02", MemberUID: []string{"cn=user02,ou=users," + testBaseDN}},
+			{CN: "group03"},
+			// nesting cycle
+			{CN: "group04", MemberGroup: []string{"group05"}},
+			{CN: "group05", MemberUID: []string{"cn=group04,ou=groups," + testBaseDN}},
+			// dynamic groups
+			{CN: "group06", MemberURL: []string{"ldap:///ou=users," + testBaseDN + "??one?(loginShell=/bin/bash)"}},
+			{CN: "group07", MemberURL: []string{"ldap:///ou=groups," + testBaseDN + "??one?(cn=group01)"}},
+			{CN: "group08", MemberURL: []string{"ftp:///" + testBaseDN}},
+		}
+		return users, groups
+	}
+
+	users, groups := newEntries()
+	errs := SetMembership(users, groups, testBaseDN, "users", "groups", false)
+	if len(errs) != 3 {
+		t.Errorf("got errors %v, want cycle errors of group04 and group05 and memberURL error of group08", errs)
+	}
+
+	userTests := []struct {
+		user data.User
+		want []string
+	}{
+		{users[0], []string{"group01"}},
+		// memberOf is transitive and is also taken from users memberOf
+		{users[1], []string{"group02", "group01", "group03"}},
+		{users[2], []string{"group06"}},
+	}
+	for _, tt := range userTests {
+		if !reflect.DeepEqual(tt.user.MemberOf, tt.want) {
+			t.Errorf("%s: memberOf = %v, want %v", tt.user.CN, tt.user.MemberOf, tt.want)
+		}
+	}
+
+	groupTests := []struct {
+		group           data.Group
+		wantMemberUID   []string
+		wantMember      []string
+		wantTransitive  []string
+		wantMemberOf    []string
+		wantBackendUIDs []string
+	}{
+		{groups[0], []string{"user01"}, []string{"user01", "group02"}, []string{"user01", "user02", "group02"}, nil, []string{"user01"}},
+		{groups[1], []string{"user02"}, []string{"user02"}, []string{"user02"}, []string{"group01"}, []string{"cn=user02,ou=users," + testBaseDN}},
+		{groups[2], []string{"user02"}, []string{"user02"}, []string{"user02"}, nil, nil},
+		// groups of cycle are not members of themselves
+		{groups[3], nil, []string{"group05"}, []string{"group05"}, []string{"group05"}, nil},
+		{groups[4], nil, []string{"group04"}, []string{"group04"}, []string{"group04"}, []string{"cn=group04,ou=groups," + testBaseDN}},
+		{groups[5], []string{"user03"}, []string{"user03"}, []string{"user03"}, nil, nil},
+		// memberURL is evaluated against users only
+		{groups[6], nil, nil, nil, nil, nil},
+	}
+	for _, tt := range groupTests {
+		g := tt.group
+		if !reflect.DeepEqual(g.MemberUID, tt.wantMemberUID) || !reflect.DeepEqual(g.Member, tt.wantMember) || !reflect.DeepEqual(g.TransitiveMember, tt.wantTransitive) {
+			t.Errorf("%s: memberUid = %v, member = %v, transitive members = %v, want %v, %v, %v", g.CN, g.MemberUID, g.Member, g.TransitiveMember, tt.wantMemberUID, tt.wantMember, tt.wantTransitive)
+		}
+		if !reflect.DeepEqual(g.MemberOf, tt.wantMemberOf) {
+			t.Errorf("%s: memberOf = %v, want %v", g.CN, g.MemberOf, tt.wantMemberOf)
+		}
+		if !reflect.DeepEqual(g.BackendMemberUID, tt.wantBackendUIDs) {
+			t.Errorf("%s: backend memberUid = %v, want %v", g.CN, g.BackendMemberUID, tt.wantBackendUIDs)
+		}
+	}
+
+	// dn values
+	users, groups = newEntries()
+	SetMembership(users, groups, testBaseDN, "users", "groups", true)
+	if want := []string{"cn=group02,ou=groups," + testBaseDN, "cn=group01,ou=groups," + testBaseDN, "cn=group03,ou=groups," + testBaseDN}; !reflect.DeepEqual(users[1].MemberOf, want) {
+		t.Errorf("memberOf = %v, want %v", users[1].MemberOf, want)
+	}
+	if want := []string{"cn=user01,ou=users," + testBaseDN, "cn=group02,ou=groups," + testBaseDN}; !reflect.DeepEqual(groups[0].Member, want) {
+		t.Errorf("member = %v, want %v", groups[0].Member, want)
+	}
+	if want := []string{"user01"}; !reflect.DeepEqual(groups[0].MemberUID, want) {
+		t.Errorf("memberUid = %v, want %v", groups[0].MemberUID, want)
+	}
+}
@@ -8,6 +8,7 @@ import (
 
 	ldap "github.com/ps78674/goldap/message"
 	"github.com/ps78674/gorestldap/internal/backend"
+	"github.com/ps78674/gorestldap/internal/config"
 	"github.com/ps78674/gorestldap/internal/data"
 	"github.com/ps78674/gorestldap/internal/ldaputils"
 	"github.com/ps78674/gorestldap/internal/ticker"
@@ -16,39 +17,78 @@ import (
 )
 
 // handle modify
-func handleModify(w ldapserver.ResponseWriter, m *ldapserver.Message, entries *data.Entries, baseDN, usersOUName, groupsOUName string, b backend.Backend, ticker *ticker.Ticker, logger *logrus.Logger) {
-	entries.RLock()
-	defer entries.RUnlock()
+func handleModify(w ldapserver.ResponseWriter, m *ldapserver.Message, snapshot *data.Snapshot, baseDN, usersOUName, groupsOUName string, respectCritical bool, computedMemberOf bool, groupSchema config.GroupSchema, b backend.Backend, ticker *ticker.Ticker, logger *logrus.Logger) {
+	entries := snapshot.Load()
 
 	r := m.GetModifyRequest()
 	logger.Infof("client [%d]: modify dn='%s'", m.Client.Numero(), r.Object())
 
-	// check modify entry dn
-	modifyEntry := ldaputils.NormalizeEntry(string(r.Object()))
-	if !isCorrectDn(modifyEntry) {
-		res := ldapserver.NewModifyResponse(ldapserver.LDAPResultInvalidDNSyntax)
+	// check requested controls
+	var controls []string
+	var assertion ldap.Filter
+	var preReadAttrs, postReadAttrs []string
+	var preRead, postRead bool
+	var gotUCControl bool
+	if m.Controls() != nil {
+		for _, c := range *m.Controls() {
+			var err error
+			switch c.ControlType() {
+			// 1.3.6.1.1.12 (assertion)
+			case assertionControlOID:
+				controls = append(controls, c.ControlType().String())
+				assertion, err = readAssertionControl(c.ControlValue())
+			// 1.3.6.1.1.13.1 (pre-read)
+			case preReadControlOID:
+				controls = append(controls, c.ControlType().String())
+				preReadAttrs, err = readReadEntryControl(c.ControlValue())
+				preRead = true
+			// 1.3.6.1.1.13.2 (post-read)
+			case postReadControlOID:
+				controls = append(controls, c.ControlType().String())
+				postReadAttrs, err = readReadEntryControl(c.ControlValue())
+				postRead = true
+			default:
+				if c.Criticality().Bool() {
+					controls = append(controls, c.ControlType().String()+"(U,C)")
+					gotUCControl = true
+				} else {
+					controls = append(controls, c.ControlType().String()+"(U)")
+				}
+			}
+			if err != nil {
+				res := ldapserver.NewModifyResponse(ldapserver.LDAPResultProtocolError)
+				res.SetDiagnosticMessage(err.Error())
+				w.Write(res)
+
+				logger.Errorf("client [%d]: modify error: error decoding control %s: %s", m.Client.Numero(), c.ControlType(), err)
+				return
+			}
+		}
+	}
+
+	logger.Infof("client [%d]: modify ctrl=%s", m.Client.Numero(), strings.Join(controls, " "))
+
+	// check for unsupported critical controls
+	if gotUCControl && respectCritical {
+		res := ldapserver.NewModifyResponse(ldapserver.LDAPResultUnavailableCriticalExtension)
 		w.Write(res)
 
-		logger.Errorf("client [%d]: modify error: wrong dn '%s'", m.Client.Numero(), r.Object())
+		logger.Errorf("client [%d]: modify error: got unsupported critical controls, aborting", m.Client.Numero())
 		return
 	}
 
-	// modify of domain or ou is not supported
-	if modifyEntry == baseDN || modifyEntry == "ou="+usersOUName+","+baseDN || modifyEntry == "ou="+groupsOUName+","+baseDN {
-		diagMessage := fmt.Sprintf("modify of '%s' is not supported", modifyEntry)
-		res := ldapserver.NewModifyResponse(ldapserver.LDAPResultUnwillingToPerform)
-		res.SetDiagnosticMessage(diagMessage)
+	// check modify entry dn
+	modifyEntry := ldaputils.NormalizeEntry(string(r.Object()))
+	if !isCorrectDn(modifyEntry) {
+		res := ldapserver.NewModifyResponse(ldapserver.LDAPResultInvalidDNSyntax)
 		w.Write(res)
 
-		logger.Errorf("client [%d]: modify error: %s", m.Client.Numero(), diagMessage)
+		logger.Errorf("client [%d]: modify error: wrong dn '%s'", m.Client.Numero(), r.Object())
 		return
 	}
 
 	// get ACLs
-	acl := clientACL{}
-	if addData := m.Client.GetAddData(); addData != nil {
-		acl = addData.(additionalData).acl
-	}
+	acl := getClientACL(m, baseDN)
 
 	// non-admin can modify only own entry
 	if !acl.modify && modifyEntry != acl.bindEntry {
@@ -59,62 +99,43 @@ func handleModify(w ldapserver.ResponseWriter, m *ldapserver.Message, entries *d
 		return
 	}
 
-	var oldEntry interface{}
-	modifyEntryAttr, modifyEntryName, modifyEntrySuffix := getEntryAttrValueSuffix(modifyEntry)
-	switch {
-	case modifyEntrySuffix == "ou="+usersOUName+","+baseDN:
-		for _, user := range entries.Users {
-			// handle stop signal
-			select {
-			case <-m.Done:
-				logger.Infof("client [%d]: leaving handleModify...", m.Client.Numero())
-				return
-			default:
-			}
+	e, found := findEntry(entries, modifyEntry, baseDN, usersOUName, groupsOUName)
+	if !found {
+		res := ldapserver.NewModifyResponse(ldapserver.LDAPResultNoSuchObject)
+		w.Write(res)
 
-			var cmpValue string
-			switch modifyEntryAttr {
-			case "cn":
-				cmpValue = user.CN
-			case "uid":
-				cmpValue = user.UID
-			}
+		logger.Errorf("client [%d]: modify error: target entry not found", m.Client.Numero())
+		return
+	}
 
-			if cmpValue != modifyEntryName {
-				continue
-			}
+	// only users and groups can be modified
+	oldEntry := e.o
+	switch oldEntry.(type) {
+	case data.User, data.Group:
+	default:
+		diagMessage := fmt.Sprintf("modify of '%s' is not supported", modifyEntry)
+		res := ldapserver.NewModifyResponse(ldapserver.LDAPResultUnwillingToPerform)
+		res.SetDiagnosticMessage(diagMessage)
+		w.Write(res)
 
-			oldEntry = user
-			break
-		}
-	case strings.HasPrefix(modifyEntry, "cn=") && modifyEntrySuffix == "ou="+groupsOUName+","+baseDN:
-		for _, group := range entries.Groups {
-			// handle stop signal
-			select {
-			case <-m.Done:
-				logger.Infof("client [%d]: leaving handleModify...", m.Client.Numero())
-				return
-			default:
-			}
+		logger.Errorf("client [%d]: modify error: %s", m.Client.Numero(), diagMessage)
+		return
+	}
 
-			if group.CN != modifyEntryName {
-				continue
+	// check assertion over entry before modify
+	if assertion != nil {
+		if result, err := checkAssertion(oldEntry, assertion); err != nil {
+			res := ldapserver.NewModifyResponse(result)
+			if err != errAssertionFailed {
+				res.SetDiagnosticMessage(err.Error())
 			}
+			w.Write(res)
 
-			oldEntry = group
-			break
+			logger.Errorf("client [%d]: modify error: %s", m.Client.Numero(), err)
+			return
 		}
 	}
 
-	// entry not found
-	if oldEntry == nil {
-		res := ldapserver.NewModifyResponse(ldapserver.LDAPResultNoSuchObject)
-		w.Write(res)
-
-		logger.Errorf("client [%d]: modify error: target entry not found", m.Client.Numero())
-		return
-	}
-
 	// copy entry for modify
 	newEntry := oldEntry
 
@@ -131,8 +152,10 @@ func handleModify(w ldapserver.ResponseWriter, m *ldapserver.Message, entries *d
 		attrName := string(c.Modification().Type_())
 		opType := c.Operation().Int()
 		logger.Infof("client [%d]: modify op=%d attr=%s", m.Client.Numero(), c.Operation(), attrName)
-		if c.Operation().Int() != ldap.ModifyRequestChangeOperationReplace {
-			diagMessage := fmt.Sprintf("wrong operation %d: only 2 (replace) is supported", opType)
+		switch opType {
+		case ldap.ModifyRequestChangeOperationAdd, ldap.ModifyRequestChangeOperationDelete, ldap.ModifyRequestChangeOperationReplace:
+		default:
+			diagMessage := fmt.Sprintf("wrong operation %d: only 0 (add), 1 (delete) and 2 (replace) are supported", opType)
 			res := ldapserver.NewModifyResponse(ldapserver.LDAPResultUnwillingToPerform)
 			res.SetDiagnosticMessage(diagMessage)
 			w.Write(res)
@@ -141,9 +164,29 @@ func handleModify(w ldapserver.ResponseWriter, m *ldapserver.Message, entries *d
 			return
 		}
 
-		// modify
-		if err := doModify(&newEntry, attrName, c.Modification().Vals()); err != nil {
+		// account expiry can not be changed by account owner
+		if !acl.modify && isAccountPolicyAttribute(attrName) {
+			diagMessage := fmt.Sprintf("attribute '%s' can be modified only by admins", attrName)
+			res := ldapserver.NewModifyResponse(ldapserver.LDAPResultInsufficientAccessRights)
+			res.SetDiagnosticMessage(diagMessage)
+			w.Write(res)
+
+			logger.Errorf("client [%d]: modify error: %s", m.Client.Numero(), diagMessage)
+			return
+		}
+
+		// add and delete values are applied to current ones, which are replaced then
+		var values []ldap.AttributeValue
+		err := checkComputedAttribute(newEntry, attrName, computedMemberOf, groupSchema)
+		if err == nil {
+			values, err = getModifiedValues(newEntry, attrName, opType, c.Modification().Vals())
+		}
+		if err == nil {
+			err = doModify(&newEntry, attrName, values)
+		}
+		if err != nil {
 			res := ldapserver.NewModifyResponse(err.(LDAPError).ResultCode)
+			res.SetDiagnosticMessage(err.Error())
 			w.Write(res)
 
 			logger.Errorf("client [%d]: modify error: %s", m.Client.Numero(), err)
@@ -152,7 +195,7 @@ func handleModify(w ldapserver.ResponseWriter, m *ldapserver.Message, entries *d
 	}
 
 	// update backend entry
-	if err := b.UpdateData(oldEntry, newEntry); err != nil {
+	if err := b.UpdateData(getBackendEntry(oldEntry), getBackendEntry(setBackendAttributes(oldEntry, newEntry))); err != nil {
 		diagMessage := fmt.Sprintf("error updating backend data: %s", err)
 		res := ldapserver.NewModifyResponse(ldapserver.LDAPResultUnwillingToPerform)
 		res.SetDiagnosticMessage(diagMessage)
@@ -162,29 +205,115 @@ func handleModify(w ldapserver.ResponseWriter, m *ldapserver.Message, entries *d
 		return
 	}
 
+	// modifiersName is set when modified entry is loaded from backend
+	setEntryModifier(getEntryName(newEntry, baseDN, usersOUName, groupsOUName), acl.bindEntry)
+
 	// get updated entries
 	ticker.Reset()
 
+	// return entry before and after modify
+	var newControls ldap.Controls
+	if preRead {
+		c, err := newReadEntryControl(preReadControlOID, createSearchEntry(oldEntry, preReadAttrs, getEntryName(oldEntry, baseDN, usersOUName, groupsOUName), false, nil))
+		if err != nil {
+			logger.Errorf("client [%d]: modify error: %s", m.Client.Numero(), err)
+		} else {
+			newControls = append(newControls, c)
+		}
+	}
+	if postRead {
+		c, err := newReadEntryControl(postReadControlOID, createSearchEntry(newEntry, postReadAttrs, getEntryName(newEntry, baseDN, usersOUName, groupsOUName), false, nil))
+		if err != nil {
+			logger.Errorf("client [%d]: modify error: %s", m.Client.Numero(), err)
+		} else {
+			newControls = append(newControls, c)
+		}
+	}
+
 	// modify OK
 	res := ldapserver.NewModifyResponse(ldapserver.LDAPResultSuccess)
-	w.Write(res)
+	if len(newControls) > 0 {
+		responseMessage := ldap.NewLDAPMessageWithProtocolOp(res)
+		ldap.SetMessageControls(responseMessage, newControls)
+		w.WriteMessage(responseMessage)
+	} else {
+		w.Write(res)
+	}
 
 	logger.Infof("client [%d]: modify result=OK", m.Client.Numero())
 }
 
+// getModifiedValues returns values of object's 'o' attribute 'attrName' after modify operation 'opType' with values 'values'
+func getModifiedValues(o interface{}, attrName string, opType int, values []ldap.AttributeValue) ([]ldap.AttributeValue, error) {
+	if opType == ldap.ModifyRequestChangeOperationReplace {
+		return values, nil
+	}
+
+	// empty values of single valued attributes are not set
+	attr, found := getAttribute(o, attrName)
+	var current []ldap.AttributeValue
+	for _, v := range attr.values {
+		if len(v) > 0 {
+			current = append(current, ldap.AttributeValue(v))
+		}
+	}
+
+	switch opType {
+	case ldap.ModifyRequestChangeOperationAdd:
+		for _, v := range values {
+			if attr.hasValue(string(v)) {
+				return nil, errLDAPValueExists
+			}
+			current = append(current, v)
+		}
+	case ldap.ModifyRequestChangeOperationDelete:
+		if !found || len(current) == 0 {
+			return nil, errLDAPNoValue
+		}
+		// delete without values removes attribute
+		if len(values) == 0 {
+			return nil, nil
+		}
+		for _, v := range values {
+			if !attr.hasValue(string(v)) {
+				return nil, errLDAPNoValue
+			}
+			var list []ldap.AttributeValue
+			for _, cv := range current {
+				if attr.normalize(string(cv)) != attr.normalize(string(v)) {
+					list = append(list, cv)
+				}
+			}
+			current = list
+		}
+	}
+
+	return current, nil
+}
+
 // doModify update object's 'o' attr 'attrName' with value 'attrValue'
 func doModify(o interface{}, attrName string, values []ldap.AttributeValue) error {
+	if err := validateValues(attrName, values); err != nil {
+		return err
+	}
+
 	root := reflect.ValueOf(o).Elem()
 	obj := root.Elem()
 	objType := obj.Type()
 
 	field, found := objType.FieldByNameFunc(func(s string) bool { return strings.EqualFold(s, attrName) })
 	if !found {
-		return errLDAPNoAttr
+		return doModifyAttributes(o, attrName, values)
 	}
 	if tagValueContains(field.Tag, "ldap", "skip") {
 		return errLDAPNoAttr
 	}
+	if tagValueContains(field.Tag, "ldap", "virtual") {
+		return errLDAPVirtualAttr
+	}
+	if tagValueContains(field.Tag, "ldap", "operational") {
+		return errLDAPOperationalAttr
+	}
 
 	objCopy := reflect.New(objType).Elem()
 	objCopy.Set(obj)
@@ -199,6 +328,10 @@ func doModify(o interface{}, attrName string, values []ldap.AttributeValue) erro
 		if len(values) > 1 {
 			return errLDAPMultiValue
 		}
+		if len(values) == 0 {
+			fieldValue.SetUint(0)
+			break
+		}
 		_uint, err := strconv.ParseUint(string(values[0]), 10, 32)
 		if err != nil {
 			return LDAPError{
@@ -207,18 +340,96 @@ func doModify(o interface{}, attrName string, values []ldap.AttributeValue) erro
 			}
 		}
 		fieldValue.SetUint(_uint)
+	case *int:
+		if len(values) > 1 {
+			return errLDAPMultiValue
+		}
+		if len(values) == 0 {
+			fieldValue.Set(reflect.Zero(fieldValue.Type()))
+			break
+		}
+		_int, err := strconv.Atoi(string(values[0]))
+		if err != nil {
+			return LDAPError{
+				ldap.ResultCodeUndefinedAttributeType,
+				fmt.Errorf("wrong attribute value: %s", err),
+			}
+		}
+		fieldValue.Set(reflect.ValueOf(&_int))
 	case string:
 		if len(values) > 1 {
 			return errLDAPMultiValue
 		}
+		if len(values) == 0 {
+			fieldValue.SetString("")
+			break
+		}
 		fieldValue.SetString(string(values[0]))
 	case []string:
+		var val []string
 		for _, v := range values {
-			fieldValue.SetString(string(v))
+			val = append(val, string(v))
 		}
+		fieldValue.Set(reflect.ValueOf(val))
 	}
 
 	root.Set(objCopy)
 
 	return nil
 }
+
+// doModifyAttributes replaces values of object's 'o' attribute 'attrName' which has no struct field,
+// attribute is removed if 'values' are empty
+func doModifyAttributes(o interface{}, attrName string, values []ldap.AttributeValue) error {
+	root := reflect.ValueOf(o).Elem()
+	obj := root.Elem()
+
+	objCopy := reflect.New(obj.Type()).Elem()
+	objCopy.Set(obj)
+
+	fieldValue := objCopy.FieldByName("Attributes")
+	if !fieldValue.IsValid() {
+		return errLDAPNoAttr
+	}
+	if !attributeNameRegexp.MatchString(attrName) {
+		return errLDAPNoAttr
+	}
+	t, ok := attributesSchema.Lookup(attrName)
+	if !ok {
+		return errLDAPNoAttr
+	}
+	if t.SingleValue && len(values) > 1 {
+		return errLDAPMultiValue
+	}
+
+	var val []string
+	for _, v := range values {
+		val = append(val, string(v))
+	}
+
+	// entries share attributes map -> modify a copy
+	attrs := fieldValue.Interface().(data.Attributes).Copy()
+	attrs.Set(attrName, val)
+	fieldValue.Set(reflect.ValueOf(attrs))
+
+	root.Set(objCopy)
+
+	return nil
+}
+
+// validateValues checks syntax of attribute 'attrName' values 'values'
+func validateValues(attrName string, values []ldap.AttributeValue) error {
+	validate, ok := attributeValidators[strings.ToLower(attrName)]
+	if !ok {
+		return nil
+	}
+	for _, v := range values {
+		if err := validate(string(v)); err != nil {
+			return LDAPError{
+				ldap.ResultCodeInvalidAttributeSyntax,
+				fmt.Errorf("wrong attribute value: %s", err),
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,262 @@
+package ldap
+
+import (
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/ps78674/gorestldap/internal/data"
+)
+
+// change types (draft-ietf-ldapext-psearch)
+const (
+	changeTypeAdd    = 1
+	changeTypeDelete = 2
+	changeTypeModify = 4
+	changeTypeModDN  = 8
+)
+
+// maximum number of pending change sets per subscriber
+const subscriberQueueSize = 16
+
+// number of change sets kept for incremental content synchronization
+const changeHistorySize = 1024
+
+// entryChange is a change of a single entry between two snapshots
+type entryChange struct {
+	changeType   int
+	changeNumber int
+	previousName string
+	entry        searchEntry
+	// object before modify or modDN
+	previous interface{}
+}
+
+// changeSet is a list of changes between two snapshot generations
+type changeSet struct {
+	generation int
+	changes    []entryChange
+}
+
+// Notifier delivers entries changes to persistent searches and content synchronization consumers
+type Notifier struct {
+	sync.Mutex
+	baseDN       string
+	usersOUName  string
+	groupsOUName string
+	// id differs between server runs, so cookies of previous runs are not accepted
+	id           string
+	generation   int
+	changeNumber int
+	history      []changeSet
+	subscribers  map[chan changeSet]struct{}
+}
+
+func NewNotifier(baseDN, usersOUName, groupsOUName string) *Notifier {
+	id, _ := newCookie()
+	return &Notifier{
+		baseDN:       baseDN,
+		usersOUName:  usersOUName,
+		groupsOUName: groupsOUName,
+		id:           id,
+		subscribers:  make(map[chan changeSet]struct{}),
+	}
+}
+
+// subscribe returns channel receiving entries changes and current snapshot generation
+// channel is closed if subscriber can not keep up with changes
+func (n *Notifier) subscribe() (chan changeSet, int) {
+	n.Lock()
+	defer n.Unlock()
+
+	ch := make(chan changeSet, subscriberQueueSize)
+	n.subscribers[ch] = struct{}{}
+
+	return ch, n.generation
+}
+
+// subscribeSince is the same as subscribe, but also returns change sets made after generation 'generation'
+// returns false if these changes are not available anymore
+func (n *Notifier) subscribeSince(generation int) (chan changeSet, int, []changeSet, bool) {
+	n.Lock()
+	defer n.Unlock()
+
+	ch := make(chan changeSet, subscriberQueueSize)
+	n.subscribers[ch] = struct{}{}
+
+	if generation > n.generation {
+		return ch, n.generation, nil, false
+	}
+	if generation == n.generation {
+		return ch, n.generation, nil, true
+	}
+	if len(n.history) == 0 || n.history[0].generation > generation+1 {
+		return ch, n.generation, nil, false
+	}
+
+	var missed []changeSet
+	for _, set := range n.history {
+		if set.generation > generation {
+			missed = append(missed, set)
+		}
+	}
+
+	return ch, n.generation, missed, true
+}
+
+// cookie returns content synchronization cookie for snapshot generation 'generation'
+func (n *Notifier) cookie(generation int) string {
+	return fmt.Sprintf("%s#%d", n.id, generation)
+}
+
+// parseCookie returns snapshot generation of cookie created by this notifier
+func (n *Notifier) parseCookie(cookie string) (int, bool) {
+	id, gen, found := strings.Cut(cookie, "#")
+	if !found || id != n.id {
+		return 0, false
+	}
+	generation, err := strconv.Atoi(gen)
+	if err != nil || generation < 0 {
+		return 0, false
+	}
+	return generation, true
+}
+
+// unsubscribe removes subscriber
+func (n *Notifier) unsubscribe(ch chan changeSet) {
+	n.Lock()
+	defer n.Unlock()
+
+	if _, ok := n.subscribers[ch]; ok {
+		delete(n.subscribers, ch)
+		close(ch)
+	}
+}
+
+// Publish sends changes between snapshots 'old' and 'new' to subscribers
+func (n *Notifier) Publish(old, new *data.Entries) {
+	n.Lock()
+	defer n.Unlock()
+
+	changes := diffEntries(listEntries(old, n.baseDN, n.usersOUName, n.groupsOUName), listEntries(new, n.baseDN, n.usersOUName, n.groupsOUName))
+	if len(changes) == 0 {
+		return
+	}
+
+	for i := range changes {
+		n.changeNumber++
+		changes[i].changeNumber = n.changeNumber
+	}
+
+	n.generation++
+	set := changeSet{generation: n.generation, changes: changes}
+
+	n.history = append(n.history, set)
+	if len(n.history) > changeHistorySize {
+		n.history = n.history[len(n.history)-changeHistorySize:]
+	}
+
+	for ch := range n.subscribers {
+		select {
+		case ch <- set:
+		default:
+			// slow subscriber -> drop it
+			delete(n.subscribers, ch)
+			close(ch)
+		}
+	}
+}
+
+// diffEntries returns changes between entries lists 'old' and 'new',
+// renamed entries are detected by entryUUID
+func diffEntries(old, new []searchEntry) []entryChange {
+	oldByName := make(map[string]searchEntry, len(old))
+	oldByUUID := make(map[string]searchEntry, len(old))
+	for _, e := range old {
+		oldByName[e.name] = e
+		if uuid := getEntryUUID(e.o); len(uuid) > 0 {
+			oldByUUID[uuid] = e
+		}
+	}
+
+	newByName := make(map[string]struct{}, len(new))
+	for _, e := range new {
+		newByName[e.name] = struct{}{}
+	}
+
+	var changes []entryChange
+	renamed := make(map[string]struct{})
+	for _, e := range new {
+		if o, ok := oldByName[e.name]; ok {
+			if !equalEntries(o.o, e.o) {
+				changes = append(changes, entryChange{changeType: changeTypeModify, entry: e, previous: o.o})
+			}
+			continue
+		}
+
+		// same entryUUID under a name which is gone -> entry renamed
+		if o, ok := oldByUUID[getEntryUUID(e.o)]; ok {
+			if _, exists := newByName[o.name]; !exists {
+				renamed[o.name] = struct{}{}
+				changes = append(changes, entryChange{changeType: changeTypeModDN, previousName: o.name, entry: e, previous: o.o})
+				continue
+			}
+		}
+
+		changes = append(changes, entryChange{changeType: changeTypeAdd, entry: e})
+	}
+
+	for _, e := range old {
+		if _, ok := newByName[e.name]; ok {
+			continue
+		}
+		if _, ok := renamed[e.name]; ok {
+			continue
+		}
+		changes = append(changes, entryChange{changeType: changeTypeDelete, entry: e})
+	}
+
+	return changes
+}
+
+// getEntryUUID returns entryUUID of object 'o'
+func getEntryUUID(o interface{}) string {
+	values, _, found := getAttrValues(o, "entryUUID")
+	if !found || len(values) == 0 {
+		return ""
+	}
+	return values[0]
+}
+
+// equalEntries returns true if objects 'a' and 'b' have equal attribute values,
+// nil and empty values are considered equal
+func equalEntries(a, b interface{}) bool {
+	va, vb := reflect.ValueOf(a), reflect.ValueOf(b)
+	if va.Type() != vb.Type() {
+		return false
+	}
+
+	for i := 0; i < va.NumField(); i++ {
+		x, y := va.Field(i).Interface(), vb.Field(i).Interface()
+		switch x.(type) {
+		case uint, *int, string, []string:
+			xValues, yValues := newLDAPAttributeValues(x), newLDAPAttributeValues(y)
+			if len(xValues) != len(yValues) {
+				return false
+			}
+			for j := range xValues {
+				if string(xValues[j]) != string(yValues[j]) {
+					return false
+				}
+			}
+		default:
+			if !reflect.DeepEqual(x, y) {
+				return false
+			}
+		}
+	}
+
+	return true
+}
@@ -0,0 +1,51 @@
+package ldap
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/ps78674/gorestldap/internal/data"
+)
+
+func TestDiffEntries(t *testing.T) {
+	old := []searchEntry{
+		{name: "cn=user01,ou=users,dc=example,dc=com", o: data.User{EntryUUID: "1", CN: "user01"}},
+		{name: "cn=user02,ou=users,dc=example,dc=com", o: data.User{EntryUUID: "2", CN: "user02"}},
+		{name: "cn=user03,ou=users,dc=example,dc=com", o: data.User{EntryUUID: "3", CN: "user03"}},
+		{name: "cn=user04,ou=users,dc=example,dc=com", o: data.User{EntryUUID: "4", CN: "user04", Mail: "user04@example.com"}},
+	}
+	new := []searchEntry{
+		// unchanged, nil and empty values are equal
+		{name: "cn=user01,ou=users,dc=example,dc=com", o: data.User{EntryUUID: "1", CN: "user01", MemberOf: []string{}}},
+		// renamed
+		{name: "cn=user22,ou=users,dc=example,dc=com", o: data.User{EntryUUID: "2", CN: "user22"}},
+		// modified
+		{name: "cn=user04,ou=users,dc=example,dc=com", o: data.User{EntryUUID: "4", CN: "user04", Mail: "user04@example.org"}},
+		// added
+		{name: "cn=user05,ou=users,dc=example,dc=com", o: data.User{EntryUUID: "5", CN: "user05"}},
+	}
+
+	type change struct {
+		changeType   int
+		name         string
+		previousName string
+	}
+	want := []change{
+		{changeTypeModDN, "cn=user22,ou=users,dc=example,dc=com", "cn=user02,ou=users,dc=example,dc=com"},
+		{changeTypeModify, "cn=user04,ou=users,dc=example,dc=com", ""},
+		{changeTypeAdd, "cn=user05,ou=users,dc=example,dc=com", ""},
+		{changeTypeDelete, "cn=user03,ou=users,dc=example,dc=com", ""},
+	}
+
+	var got []change
+	for _, c := range diffEntries(old, new) {
+		got = append(got, change{c.changeType, c.entry.name, c.previousName})
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("changes = %+v, want %+v", got, want)
+	}
+
+	if changes := diffEntries(old, old); len(changes) != 0 {
+		t.Errorf("got %d changes of equal entries", len(changes))
+	}
+}
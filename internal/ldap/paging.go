@@ -0,0 +1,100 @@
+package ldap
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"sync"
+	"time"
+
+	ldap "github.com/ps78674/goldap/message"
+)
+
+// pagedSearch is a saved state of paged search (RFC 2696)
+type pagedSearch struct {
+	client     int
+	baseObject string
+	scope      int
+	filter     string
+	attrs      []string
+	entries    []searchEntry
+	controls   ldap.Controls
+	pos        int
+	sent       int
+	expires    time.Time
+}
+
+// matches returns true if search request parameters are equal to saved ones
+func (s *pagedSearch) matches(client int, baseObject string, scope int, filter string, attrs []string) bool {
+	if s.client != client || s.baseObject != baseObject || s.scope != scope || s.filter != filter {
+		return false
+	}
+	if len(s.attrs) != len(attrs) {
+		return false
+	}
+	for i := range attrs {
+		if s.attrs[i] != attrs[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// pagedSearches holds paged search states by cookie
+type pagedSearches struct {
+	sync.Mutex
+	timeout  time.Duration
+	searches map[string]*pagedSearch
+}
+
+func newPagedSearches(timeout time.Duration) *pagedSearches {
+	return &pagedSearches{
+		timeout:  timeout,
+		searches: make(map[string]*pagedSearch),
+	}
+}
+
+// newCookie returns new random cookie
+func newCookie() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", fmt.Errorf("error generating cookie: %s", err)
+	}
+	return hex.EncodeToString(b), nil
+}
+
+// put saves paged search state with cookie
+func (p *pagedSearches) put(cookie string, s *pagedSearch) {
+	p.Lock()
+	defer p.Unlock()
+
+	// drop expired searches
+	now := time.Now()
+	for c, search := range p.searches {
+		if now.After(search.expires) {
+			delete(p.searches, c)
+		}
+	}
+
+	s.expires = now.Add(p.timeout)
+	p.searches[cookie] = s
+}
+
+// take removes paged search state by cookie and returns it,
+// state must be saved back with put if search is not done
+func (p *pagedSearches) take(cookie string) (*pagedSearch, bool) {
+	p.Lock()
+	defer p.Unlock()
+
+	s, ok := p.searches[cookie]
+	if !ok {
+		return nil, false
+	}
+	delete(p.searches, cookie)
+
+	if time.Now().After(s.expires) {
+		return nil, false
+	}
+
+	return s, true
+}
@@ -4,6 +4,7 @@ import (
 	"fmt"
 	"reflect"
 	"strings"
+	"time"
 
 	ldap "github.com/ps78674/goldap/message"
 	"github.com/ps78674/gorestldap/internal/config"
@@ -13,7 +14,7 @@ import (
 	"github.com/sirupsen/logrus"
 )
 
-func handleSearchDSE(w ldapserver.ResponseWriter, m *ldapserver.Message, baseDN string, logger *logrus.Logger) {
+func handleSearchDSE(w ldapserver.ResponseWriter, m *ldapserver.Message, baseDNs []string, logger *logrus.Logger) {
 	r := m.GetSearchRequest()
 
 	logger.Infof("client [%d]: search base='%s' scope=%d filter='%s'", m.Client.Numero(), r.BaseObject(), r.Scope(), r.FilterString())
@@ -29,11 +30,21 @@ func handleSearchDSE(w ldapserver.ResponseWriter, m *ldapserver.Message, baseDN
 		ObjectClass:          []string{"top", "LDAProotDSE"},
 		VendorVersion:        config.VersionString,
 		SupportedLDAPVersion: 3,
-		SupportedControl:     []string{string(ldap.PagedResultsControlOID)},
-		NamingContexts:       []string{baseDN},
+		SupportedControl: []string{
+			string(ldap.PagedResultsControlOID),
+			string(sortRequestControlOID),
+			string(vlvRequestControlOID),
+			string(persistentSearchControlOID),
+			string(syncRequestControlOID),
+			string(assertionControlOID),
+			string(preReadControlOID),
+			string(postReadControlOID),
+			string(matchedValuesControlOID),
+		},
+		NamingContexts: baseDNs,
 	}
 
-	e := createSearchEntry(rootDSE, searchAttrs, "")
+	e := createSearchEntry(rootDSE, searchAttrs, "", r.TypesOnly().Bool(), nil)
 
 	w.Write(e)
 	w.Write(ldapserver.NewSearchResultDoneResponse(ldapserver.LDAPResultSuccess))
@@ -41,11 +52,9 @@ func handleSearchDSE(w ldapserver.ResponseWriter, m *ldapserver.Message, baseDN
 	logger.Infof("client [%d]: search result=OK nentries=1", m.Client.Numero())
 }
 
-func handleSearch(w ldapserver.ResponseWriter, m *ldapserver.Message, entries *data.Entries, baseDN, usersOUName, groupsOUName string, respectCritical bool, logger *logrus.Logger) {
-	entries.RLock()
-	defer entries.RUnlock()
-
+func handleSearch(w ldapserver.ResponseWriter, m *ldapserver.Message, snapshot *data.Snapshot, baseDN, usersOUName, groupsOUName string, respectCritical bool, limits map[string]config.Limits, pagedSearches *pagedSearches, notifier *Notifier, logger *logrus.Logger) {
 	r := m.GetSearchRequest()
+	entries := snapshot.Load()
 
 	logger.Infof("client [%d]: search base='%s' scope=%d filter='%s'", m.Client.Numero(), r.BaseObject(), r.Scope(), r.FilterString())
 
@@ -59,6 +68,14 @@ func handleSearch(w ldapserver.ResponseWriter, m *ldapserver.Message, entries *d
 	// check requested controls
 	var controls []string
 	var simplePagedResultsControl ldap.SimplePagedResultsControl
+	var paged bool
+	var sortKeys []sortKey
+	var sortCritical bool
+	var vlv *vlvRequest
+	var psearch *persistentSearch
+	var syncReq *syncRequest
+	var assertion ldap.Filter
+	var valuesFilter []ldap.Filter
 	var gotUCControl bool
 	if m.Controls() != nil {
 		for _, c := range *m.Controls() {
@@ -68,9 +85,94 @@ func handleSearch(w ldapserver.ResponseWriter, m *ldapserver.Message, entries *d
 				controls = append(controls, c.ControlType().String())
 				c, err := ldap.ReadPagedResultsControl(c.ControlValue())
 				if err != nil {
+					res := ldapserver.NewSearchResultDoneResponse(ldapserver.LDAPResultProtocolError)
+					res.SetDiagnosticMessage(err.Error())
+					w.Write(res)
+
 					logger.Errorf("client [%d]: error decoding pagedResultsControl: %s", m.Client.Numero(), err)
+					return
 				}
 				simplePagedResultsControl = c
+				paged = true
+			// 1.2.840.113556.1.4.473 (server side sort)
+			case sortRequestControlOID:
+				controls = append(controls, c.ControlType().String())
+				keys, err := readSortRequestControl(c.ControlValue())
+				if err != nil {
+					res := ldapserver.NewSearchResultDoneResponse(ldapserver.LDAPResultProtocolError)
+					res.SetDiagnosticMessage(err.Error())
+					w.Write(res)
+
+					logger.Errorf("client [%d]: error decoding sortRequestControl: %s", m.Client.Numero(), err)
+					return
+				}
+				sortKeys = keys
+				sortCritical = c.Criticality().Bool()
+			// 2.16.840.1.113730.3.4.9 (virtual list view)
+			case vlvRequestControlOID:
+				controls = append(controls, c.ControlType().String())
+				req, err := readVLVRequestControl(c.ControlValue())
+				if err != nil {
+					res := ldapserver.NewSearchResultDoneResponse(ldapserver.LDAPResultProtocolError)
+					res.SetDiagnosticMessage(err.Error())
+					w.Write(res)
+
+					logger.Errorf("client [%d]: error decoding vlvRequestControl: %s", m.Client.Numero(), err)
+					return
+				}
+				vlv = &req
+			// 2.16.840.1.113730.3.4.3 (persistent search)
+			case persistentSearchControlOID:
+				controls = append(controls, c.ControlType().String())
+				ps, err := readPersistentSearchControl(c.ControlValue())
+				if err != nil {
+					res := ldapserver.NewSearchResultDoneResponse(ldapserver.LDAPResultProtocolError)
+					res.SetDiagnosticMessage(err.Error())
+					w.Write(res)
+
+					logger.Errorf("client [%d]: error decoding persistentSearchControl: %s", m.Client.Numero(), err)
+					return
+				}
+				psearch = &ps
+			// 1.3.6.1.4.1.4203.1.9.1.1 (content synchronization)
+			case syncRequestControlOID:
+				controls = append(controls, c.ControlType().String())
+				req, err := readSyncRequestControl(c.ControlValue())
+				if err != nil {
+					res := ldapserver.NewSearchResultDoneResponse(ldapserver.LDAPResultProtocolError)
+					res.SetDiagnosticMessage(err.Error())
+					w.Write(res)
+
+					logger.Errorf("client [%d]: error decoding syncRequestControl: %s", m.Client.Numero(), err)
+					return
+				}
+				syncReq = &req
+			// 1.3.6.1.1.12 (assertion)
+			case assertionControlOID:
+				controls = append(controls, c.ControlType().String())
+				f, err := readAssertionControl(c.ControlValue())
+				if err != nil {
+					res := ldapserver.NewSearchResultDoneResponse(ldapserver.LDAPResultProtocolError)
+					res.SetDiagnosticMessage(err.Error())
+					w.Write(res)
+
+					logger.Errorf("client [%d]: error decoding assertionControl: %s", m.Client.Numero(), err)
+					return
+				}
+				assertion = f
+			// 1.2.826.0.1.3344810.2.3 (matched values)
+			case matchedValuesControlOID:
+				controls = append(controls, c.ControlType().String())
+				f, err := readMatchedValuesControl(c.ControlValue())
+				if err != nil {
+					res := ldapserver.NewSearchResultDoneResponse(ldapserver.LDAPResultProtocolError)
+					res.SetDiagnosticMessage(err.Error())
+					w.Write(res)
+
+					logger.Errorf("client [%d]: error decoding matchedValuesControl: %s", m.Client.Numero(), err)
+					return
+				}
+				valuesFilter = f
 			default:
 				if c.Criticality().Bool() {
 					controls = append(controls, c.ControlType().String()+"(U,C)")
@@ -93,7 +195,7 @@ func handleSearch(w ldapserver.ResponseWriter, m *ldapserver.Message, entries *d
 		return
 	}
 
-	logger.Infof("client [%d]: search sizelimit=%d pagesize=%d", m.Client.Numero(), r.SizeLimit(), simplePagedResultsControl.PageSize())
+	logger.Infof("client [%d]: search sizelimit=%d timelimit=%d typesonly=%t pagesize=%d", m.Client.Numero(), r.SizeLimit(), r.TimeLimit(), r.TypesOnly(), simplePagedResultsControl.PageSize())
 
 	// handle stop signal
 	select {
@@ -111,13 +213,8 @@ func handleSearch(w ldapserver.ResponseWriter, m *ldapserver.Message, entries *d
 		baseObject = ldaputils.NormalizeEntry(string(r.BaseObject()))
 	}
 
-	// get ACLs & search control
-	acl := clientACL{}
-	searchControl := clientSearchControl{}
-	if addData := m.Client.GetAddData(); addData != nil {
-		acl = addData.(additionalData).acl
-		searchControl = addData.(additionalData).sc
-	}
+	// get ACLs
+	acl := getClientACL(m, baseDN)
 
 	// non admin user allowed to search only over his entry
 	if !acl.search && baseObject != acl.bindEntry {
@@ -128,249 +225,255 @@ func handleSearch(w ldapserver.ResponseWriter, m *ldapserver.Message, entries *d
 		return
 	}
 
-	entriesWritten := 0
-	sizeLimitReached := false
-
-	// check if more entries is available
-	lastIteration := false
-
-	// how much entries is left
-	left := simplePagedResultsControl.PageSize().Int()
-	if left == 0 {
-		// 3 = domain + users ou + groups ou
-		left = 3 + len(entries.Users) + len(entries.Groups)
+	// administrative limits override client requested ones
+	lim := getLimits(limits, acl)
+	sizeLimit := r.SizeLimit().Int()
+	if lim.Size > 0 && (sizeLimit == 0 || sizeLimit > lim.Size) {
+		sizeLimit = lim.Size
 	}
-
-	// if domain processed -> go to users
-	if searchControl.domainDone {
-		goto ous
+	timeLimit := time.Duration(r.TimeLimit().Int()) * time.Second
+	if lim.Time > 0 && (timeLimit == 0 || timeLimit > lim.Time) {
+		timeLimit = lim.Time
+	}
+	var deadline time.Time
+	if timeLimit > 0 {
+		deadline = time.Now().Add(timeLimit)
 	}
 
-	// got match
-	if baseObject == baseDN {
-		// if searchScope == {base, sub} -> add domain entry
-		if r.Scope() == ldap.SearchRequestScopeBaseObject || r.Scope() == ldap.SearchRequestScopeSubtree {
-			ok, err := applySearchFilter(entries.Domain, r.Filter())
-			if err != nil {
-				res := ldapserver.NewSearchResultDoneResponse(ldapserver.LDAPResultUnwillingToPerform)
-				res.SetDiagnosticMessage(err.Error())
-				w.Write(res)
-
-				logger.Errorf("client [%d]: search error: %s", m.Client.Numero(), err)
-				return
-			}
+	logger.Debugf("client [%d]: search role=%s sizelimit=%d timelimit=%s", m.Client.Numero(), acl.role(), sizeLimit, timeLimit)
 
-			if r.SizeLimit().Int() > 0 && searchControl.sent == r.SizeLimit().Int() {
-				sizeLimitReached = true
-				goto end
-			} else if ok {
-				e := createSearchEntry(entries.Domain, searchAttrs, baseDN)
-				w.Write(e)
+	// virtual list view requires server side sort
+	if vlv != nil && len(sortKeys) == 0 {
+		res := ldapserver.NewSearchResultDoneResponse(ldapResultSortControlMissing)
+		res.SetDiagnosticMessage("virtual list view requires server side sort control")
+		w.Write(res)
 
-				searchControl.sent++
-				entriesWritten++
-				left--
+		logger.Errorf("client [%d]: search error: virtual list view requested without sort control", m.Client.Numero())
+		return
+	}
 
-				// base object found & written -> should not search more
-				if r.Scope() == ldap.SearchRequestScopeBaseObject {
-					goto end
-				}
-			}
-		}
+	// virtual list view can not be combined with paged results
+	if vlv != nil && paged {
+		res := ldapserver.NewSearchResultDoneResponse(ldapResultVirtualListViewError)
+		res.SetDiagnosticMessage("virtual list view can not be combined with paged results")
+		w.Write(res)
 
-		// should search only one level down, over OUs
-		if r.Scope() == ldap.SearchRequestScopeOneLevel {
-			searchControl.usersDone = true
-			searchControl.groupsDone = true
-		}
+		logger.Errorf("client [%d]: search error: virtual list view requested with paged results", m.Client.Numero())
+		return
 	}
 
-	// domain processed
-	searchControl.domainDone = true
+	// persistent search can not be combined with paged results or virtual list view
+	if psearch != nil && (paged || vlv != nil) {
+		res := ldapserver.NewSearchResultDoneResponse(ldapserver.LDAPResultUnwillingToPerform)
+		res.SetDiagnosticMessage("persistent search can not be combined with paged results or virtual list view")
+		w.Write(res)
 
-ous:
-	// if ous processed -> go to users
-	if searchControl.ousDone {
-		goto users
+		logger.Errorf("client [%d]: search error: persistent search requested with paged results or virtual list view", m.Client.Numero())
+		return
 	}
 
-	for i := searchControl.count; i < len(entries.OUs); i++ {
-		// handle stop signal
-		select {
-		case <-m.Done:
+	// assertion is applied to base object
+	if assertion != nil {
+		found, err := findSearchEntries(m, entries, baseObject, baseDN, usersOUName, groupsOUName, ldap.SearchRequestScopeBaseObject, assertion, deadline)
+		if err == errSearchAbandoned {
 			logger.Infof("client [%d]: leaving handleSearch...", m.Client.Numero())
 			return
-		default:
 		}
+		if err != nil {
+			res := ldapserver.NewSearchResultDoneResponse(getSearchErrorResult(err))
+			if err != errTimeLimitExceeded {
+				res.SetDiagnosticMessage(err.Error())
+			}
+			w.Write(res)
 
-		if left == 0 && !lastIteration {
-			lastIteration = true
+			logger.Errorf("client [%d]: search error: %s", m.Client.Numero(), err)
+			return
 		}
+		if len(found) == 0 {
+			res := ldapserver.NewSearchResultDoneResponse(ldapResultAssertionFailed)
+			w.Write(res)
 
-		if !lastIteration {
-			searchControl.count++
+			logger.Errorf("client [%d]: search error: assertion failed", m.Client.Numero())
+			return
 		}
+	}
 
-		entryName := fmt.Sprintf("ou=%s,%s", entries.OUs[i].OU, baseDN)
+	// content synchronization can not be combined with other search controls
+	if syncReq != nil && (paged || vlv != nil || psearch != nil || len(sortKeys) > 0) {
+		res := ldapserver.NewSearchResultDoneResponse(ldapserver.LDAPResultUnwillingToPerform)
+		res.SetDiagnosticMessage("content synchronization can not be combined with other search controls")
+		w.Write(res)
 
-		// entry does not belong to base object
-		if !strings.HasSuffix(entryName, baseObject) {
-			continue
-		}
+		logger.Errorf("client [%d]: search error: content synchronization requested with other search controls", m.Client.Numero())
+		return
+	}
 
-		// entry != baseobject & scope == base requested
-		if r.Scope() == ldap.SearchRequestScopeBaseObject && entryName != baseObject {
-			continue
+	if syncReq != nil {
+		s := syncSearch{
+			w:            w,
+			m:            m,
+			baseObject:   baseObject,
+			scope:        int(r.Scope()),
+			filter:       r.Filter(),
+			attrs:        searchAttrs,
+			typesOnly:    r.TypesOnly().Bool(),
+			valuesFilter: valuesFilter,
 		}
+		handleSyncSearch(s, *syncReq, snapshot, baseDN, usersOUName, groupsOUName, notifier, logger)
+		return
+	}
 
-		// search sublevel based on ou name (users or groups)
-		if entryName == baseObject && (r.Scope() == ldap.SearchRequestScopeOneLevel || r.Scope() == ldap.SearchRequestScopeChildren) {
-			searchControl.count = 0
-			switch entries.OUs[i].OU {
-			case usersOUName:
-				searchControl.groupsDone = true
-				goto users
-			case groupsOUName:
-				searchControl.usersDone = true
-				goto groups
-			}
-		}
+	// subscribe before initial search, so no change is lost in between
+	var changes chan changeSet
+	if psearch != nil {
+		changes, _ = notifier.subscribe()
+		defer notifier.unsubscribe(changes)
 
-		// if size limit reached -> go to response
-		if r.SizeLimit().Int() > 0 && searchControl.sent == r.SizeLimit().Int() {
-			sizeLimitReached = true
-			goto end
-		}
+		// initial search on data loaded after subscribing
+		entries = snapshot.Load()
 
-		// apply search filter for each ou
-		ok, err := applySearchFilter(entries.OUs[i], r.Filter())
-		if err != nil {
-			res := ldapserver.NewSearchResultDoneResponse(ldapserver.LDAPResultUnwillingToPerform)
-			res.SetDiagnosticMessage(err.Error())
-			w.Write(res)
+		logger.Infof("client [%d]: search persistent changetypes=%d changesonly=%t returnecs=%t", m.Client.Numero(), psearch.changeTypes, psearch.changesOnly, psearch.returnECs)
+	}
 
-			logger.Errorf("client [%d]: search error: %s", m.Client.Numero(), err)
-			return
-		}
+	var search *pagedSearch
+	cookie := string(simplePagedResultsControl.Cookie())
+	pageSize := simplePagedResultsControl.PageSize().Int()
 
-		// if filter not applied -> skip ou
+	if len(cookie) > 0 {
+		// continue paged search
+		s, ok := pagedSearches.take(cookie)
 		if !ok {
-			continue
-		}
+			res := ldapserver.NewSearchResultDoneResponse(ldapserver.LDAPResultUnwillingToPerform)
+			res.SetDiagnosticMessage("unknown or expired paged results cookie")
+			w.Write(res)
 
-		if lastIteration {
-			goto end
+			logger.Errorf("client [%d]: search error: unknown or expired paged results cookie", m.Client.Numero())
+			return
 		}
 
-		e := createSearchEntry(entries.OUs[i], searchAttrs, entryName)
-		w.Write(e)
+		if !s.matches(m.Client.Numero(), baseObject, int(r.Scope()), r.FilterString(), searchAttrs) {
+			pagedSearches.put(cookie, s)
 
-		searchControl.sent++
-		entriesWritten++
-		left--
+			res := ldapserver.NewSearchResultDoneResponse(ldapserver.LDAPResultUnwillingToPerform)
+			res.SetDiagnosticMessage("search request does not match paged results cookie")
+			w.Write(res)
 
-		// base object found & written -> should not search more
-		if r.Scope() == ldap.SearchRequestScopeBaseObject {
-			goto end
+			logger.Errorf("client [%d]: search error: search request does not match paged results cookie", m.Client.Numero())
+			return
 		}
-	}
 
-	// ous processed
-	searchControl.count = 0
-	searchControl.ousDone = true
+		// page size 0 -> abandon paged search
+		if pageSize == 0 {
+			v, err := ldap.WritePagedResultsControl(ldap.INTEGER(0), ldap.OCTETSTRING(""))
+			if err != nil {
+				res := ldapserver.NewSearchResultDoneResponse(ldapserver.LDAPResultProtocolError)
+				w.Write(res)
 
-users:
-	// if users processed -> go to groups
-	if searchControl.usersDone {
-		goto groups
-	}
+				logger.Errorf("client [%d]: search error: error encoding pagedResultsControl: %s", m.Client.Numero(), err)
+				return
+			}
 
-	for i := searchControl.count; i < len(entries.Users); i++ {
-		// handle stop signal
-		select {
-		case <-m.Done:
-			logger.Infof("client [%d]: leaving handleSearch...", m.Client.Numero())
-			return
-		default:
-		}
+			res := ldapserver.NewSearchResultDoneResponse(ldapserver.LDAPResultSuccess)
+			responseMessage := ldap.NewLDAPMessageWithProtocolOp(res)
+			ldap.SetMessageControls(responseMessage, ldap.Controls{ldap.NewControl(ldap.PagedResultsControlOID, ldap.BOOLEAN(true), *v)})
+			w.WriteMessage(responseMessage)
 
-		if left == 0 && !lastIteration {
-			lastIteration = true
+			logger.Infof("client [%d]: search result=OK paged search abandoned", m.Client.Numero())
+			return
 		}
 
-		if !lastIteration {
-			searchControl.count++
+		search = s
+	} else {
+		search = &pagedSearch{
+			client:     m.Client.Numero(),
+			baseObject: baseObject,
+			scope:      int(r.Scope()),
+			filter:     r.FilterString(),
+			attrs:      searchAttrs,
 		}
 
-		entryName := fmt.Sprintf("cn=%s,ou=%s,%s", entries.Users[i].CN, usersOUName, baseDN)
+		// check ordering rules, non critical sort control with unsupported ones -> return unsorted results
+		var sortControls ldap.Controls
+		var sortResult int
+		var sortErr error
+		sortKeys, sortControls, sortResult, sortErr = checkSortKeys(sortKeys, sortCritical, vlv != nil)
+		search.controls = append(search.controls, sortControls...)
+		if sortResult != ldapserver.LDAPResultSuccess {
+			res := ldapserver.NewSearchResultDoneResponse(sortResult)
+			res.SetDiagnosticMessage(sortErr.Error())
+			responseMessage := ldap.NewLDAPMessageWithProtocolOp(res)
+			if len(search.controls) > 0 {
+				ldap.SetMessageControls(responseMessage, search.controls)
+			}
+			w.WriteMessage(responseMessage)
 
-		// entry does not belong to base object
-		if !strings.HasSuffix(entryName, baseObject) {
-			continue
+			logger.Errorf("client [%d]: search error: %s", m.Client.Numero(), sortErr)
+			return
 		}
-
-		// entry != baseobject & scope == base requested
-		if r.Scope() == ldap.SearchRequestScopeBaseObject && entryName != baseObject {
-			continue
+		if sortErr != nil {
+			logger.Warnf("client [%d]: search sort skipped: %s", m.Client.Numero(), sortErr)
 		}
 
-		// user does not have sublevel, END
-		if entryName == baseObject && (r.Scope() == ldap.SearchRequestScopeOneLevel || r.Scope() == ldap.SearchRequestScopeChildren) {
-			searchControl.usersDone = true
-			searchControl.groupsDone = true
-			goto end
+		var found []searchEntry
+		var err error
+		// changes only persistent search -> no initial results
+		if psearch == nil || !psearch.changesOnly {
+			found, err = findSearchEntries(m, entries, baseObject, baseDN, usersOUName, groupsOUName, int(r.Scope()), r.Filter(), deadline)
 		}
-
-		// if size limit reached -> go to response
-		if r.SizeLimit().Int() > 0 && searchControl.sent == r.SizeLimit().Int() {
-			sizeLimitReached = true
-			goto end
+		if err == errSearchAbandoned {
+			logger.Infof("client [%d]: leaving handleSearch...", m.Client.Numero())
+			return
 		}
-
-		// apply search filter for each user
-		ok, err := applySearchFilter(entries.Users[i], r.Filter())
 		if err != nil {
-			res := ldapserver.NewSearchResultDoneResponse(ldapserver.LDAPResultUnwillingToPerform)
-			res.SetDiagnosticMessage(err.Error())
+			res := ldapserver.NewSearchResultDoneResponse(getSearchErrorResult(err))
+			if err != errTimeLimitExceeded {
+				res.SetDiagnosticMessage(err.Error())
+			}
 			w.Write(res)
 
 			logger.Errorf("client [%d]: search error: %s", m.Client.Numero(), err)
 			return
 		}
 
-		// if filter not applied -> skip user
-		if !ok {
-			continue
+		if len(sortKeys) > 0 {
+			sortSearchEntries(found, sortKeys)
+			search.controls = append(search.controls, newSortResponseControl(ldapserver.LDAPResultSuccess, ""))
 		}
 
-		if lastIteration {
-			goto end
-		}
+		// sort keys are checked above, virtual list view is not applied to unsorted results
+		if vlv != nil && len(sortKeys) > 0 {
+			contentCount := len(found)
 
-		e := createSearchEntry(entries.Users[i], searchAttrs, entryName)
-		w.Write(e)
+			var targetPosition, vlvResult int
+			found, targetPosition, vlvResult = applyVLV(found, sortKeys[0], *vlv)
+			search.controls = append(search.controls, newVLVResponseControl(targetPosition, contentCount, vlvResult, vlv.contextID))
 
-		searchControl.sent++
-		entriesWritten++
-		left--
+			logger.Infof("client [%d]: search vlv target=%d before=%d after=%d count=%d", m.Client.Numero(), targetPosition, vlv.beforeCount, vlv.afterCount, contentCount)
 
-		// base object found & written -> should not search more
-		if r.Scope() == ldap.SearchRequestScopeBaseObject {
-			goto end
+			if vlvResult != ldapserver.LDAPResultSuccess {
+				res := ldapserver.NewSearchResultDoneResponse(vlvResult)
+				responseMessage := ldap.NewLDAPMessageWithProtocolOp(res)
+				ldap.SetMessageControls(responseMessage, search.controls)
+				w.WriteMessage(responseMessage)
+
+				logger.Errorf("client [%d]: search error: virtual list view result=%d", m.Client.Numero(), vlvResult)
+				return
+			}
 		}
-	}
 
-	// users processed
-	searchControl.count = 0
-	searchControl.usersDone = true
+		search.entries = found
+	}
 
-groups:
-	// if groups processed -> go to end
-	if searchControl.groupsDone {
-		goto end
+	// send next page or all entries
+	end := len(search.entries)
+	if pageSize > 0 && search.pos+pageSize < end {
+		end = search.pos + pageSize
 	}
 
-	for i := searchControl.count; i < len(entries.Groups); i++ {
+	entriesWritten := 0
+	sizeLimitReached := false
+	timeLimitReached := false
+	for search.pos < end {
 		// handle stop signal
 		select {
 		case <-m.Done:
@@ -379,90 +482,47 @@ groups:
 		default:
 		}
 
-		if left == 0 && !lastIteration {
-			lastIteration = true
-		}
-
-		if !lastIteration {
-			searchControl.count++
-		}
-
-		entryName := fmt.Sprintf("cn=%s,ou=%s,%s", entries.Groups[i].CN, groupsOUName, baseDN)
-
-		// entry does not belong to base object
-		if !strings.HasSuffix(entryName, baseObject) {
-			continue
-		}
-
-		// entry != baseobject & scope == base requested
-		if r.Scope() == ldap.SearchRequestScopeBaseObject && entryName != baseObject {
-			continue
-		}
-
-		// user does not have sublevel, END
-		if entryName == baseObject && (r.Scope() == ldap.SearchRequestScopeOneLevel || r.Scope() == ldap.SearchRequestScopeChildren) {
-			searchControl.usersDone = true
-			searchControl.groupsDone = true
-			goto end
-		}
-
-		// if size limit reached -> brake loop
-		if r.SizeLimit().Int() > 0 && searchControl.sent == r.SizeLimit().Int() {
+		// size limit applies to whole paged search
+		if sizeLimit > 0 && search.sent == sizeLimit {
 			sizeLimitReached = true
-			goto end
+			break
 		}
 
-		// apply search filter for each group
-		ok, err := applySearchFilter(entries.Groups[i], r.Filter())
-		if err != nil {
-			res := ldapserver.NewSearchResultDoneResponse(ldapserver.LDAPResultUnwillingToPerform)
-			res.SetDiagnosticMessage(err.Error())
-			w.Write(res)
-
-			logger.Errorf("client [%d]: search error: %s", m.Client.Numero(), err)
-			return
-		}
-
-		// if filter not applied -> skip group
-		if !ok {
-			continue
-		}
-
-		if lastIteration {
-			goto end
+		if !deadline.IsZero() && time.Now().After(deadline) {
+			timeLimitReached = true
+			break
 		}
 
-		e := createSearchEntry(entries.Groups[i], searchAttrs, entryName)
-		w.Write(e)
+		e := search.entries[search.pos]
+		w.Write(createSearchEntry(e.o, searchAttrs, e.name, r.TypesOnly().Bool(), valuesFilter))
 
-		searchControl.sent++
+		search.pos++
+		search.sent++
 		entriesWritten++
-		left--
-
-		// base object found & written -> should not search more
-		if r.Scope() == ldap.SearchRequestScopeBaseObject {
-			goto end
-		}
 	}
 
-	// groups processed
-	searchControl.count = 0
-	searchControl.groupsDone = true
-
-end:
-	newControls := ldap.Controls{}
-	if simplePagedResultsControl.PageSize().Int() > 0 {
-		cpCookie := ldap.OCTETSTRING(config.ProgramName)
+	newControls := append(ldap.Controls{}, search.controls...)
+	if paged {
+		cpCookie := ""
+		// search not finished -> save state for next page
+		if search.pos < len(search.entries) && !sizeLimitReached && !timeLimitReached {
+			if len(cookie) == 0 {
+				c, err := newCookie()
+				if err != nil {
+					res := ldapserver.NewSearchResultDoneResponse(ldapserver.LDAPResultOperationsError)
+					w.Write(res)
 
-		// end search
-		if (searchControl.domainDone && searchControl.ousDone && searchControl.usersDone && searchControl.groupsDone) || sizeLimitReached {
-			cpCookie = ldap.OCTETSTRING("")
-			// end search -> cleanup search control
-			searchControl = clientSearchControl{}
+					logger.Errorf("client [%d]: search error: %s", m.Client.Numero(), err)
+					return
+				}
+				cookie = c
+			}
+			pagedSearches.put(cookie, search)
+			cpCookie = cookie
 		}
 
 		// encode new paged results control
-		v, err := ldap.WritePagedResultsControl(ldap.INTEGER(0), cpCookie)
+		v, err := ldap.WritePagedResultsControl(ldap.INTEGER(len(search.entries)), ldap.OCTETSTRING(cpCookie))
 		if err != nil {
 			res := ldapserver.NewSearchResultDoneResponse(ldapserver.LDAPResultProtocolError)
 			w.Write(res)
@@ -473,17 +533,21 @@ end:
 
 		c := ldap.NewControl(ldap.PagedResultsControlOID, ldap.BOOLEAN(true), *v)
 		newControls = append(newControls, c)
-	} else {
-		// end search -> cleanup search control
-		searchControl = clientSearchControl{}
 	}
 
-	// update additional data with new search control
-	m.Client.SetAddData(additionalData{acl: acl, sc: searchControl})
+	// persistent search -> keep sending changes until search is abandoned
+	if psearch != nil && !sizeLimitReached && !timeLimitReached {
+		logger.Infof("client [%d]: search nentries=%d, waiting for changes", m.Client.Numero(), entriesWritten)
+		persistSearch(w, m, changes, *psearch, baseObject, int(r.Scope()), r.Filter(), searchAttrs, r.TypesOnly().Bool(), valuesFilter, deadline, logger)
+		return
+	}
 
 	resultCode := ldapserver.LDAPResultSuccess
-	if sizeLimitReached {
+	switch {
+	case sizeLimitReached:
 		resultCode = ldapserver.LDAPResultSizeLimitExceeded
+	case timeLimitReached:
+		resultCode = ldapserver.LDAPResultTimeLimitExceeded
 	}
 
 	res := ldapserver.NewSearchResultDoneResponse(resultCode)
@@ -495,11 +559,32 @@ end:
 	logger.Infof("client [%d]: search result=OK nentries=%d", m.Client.Numero(), entriesWritten)
 }
 
+// getSearchErrorResult returns search result code of findSearchEntries error 'err'
+func getSearchErrorResult(err error) int {
+	if err == errTimeLimitExceeded {
+		return ldapserver.LDAPResultTimeLimitExceeded
+	}
+	return ldapserver.LDAPResultUnwillingToPerform
+}
+
+// checkAssertion returns result code of assertion control filter 'f' applied to entry 'o'
+// assertionFailed is returned if entry does not fit filter, filter errors are returned with search result codes
+func checkAssertion(o interface{}, f ldap.Filter) (int, error) {
+	ok, err := applySearchFilter(o, f)
+	if err != nil {
+		return getSearchErrorResult(err), err
+	}
+	if !ok {
+		return ldapResultAssertionFailed, errAssertionFailed
+	}
+	return ldapserver.LDAPResultSuccess, nil
+}
+
 // applySearchFilter returns true if object 'o' fits filter 'f'
 func applySearchFilter(o interface{}, f ldap.Filter) (bool, error) {
 	switch filter := f.(type) {
 	case ldap.FilterEqualityMatch:
-		attrName := string(filter.AttributeDesc())
+		attrName := getAttributeType(string(filter.AttributeDesc()))
 		attrValue := string(filter.AssertionValue())
 
 		if strings.ToLower(attrName) == "entrydn" {
@@ -507,40 +592,11 @@ func applySearchFilter(o interface{}, f ldap.Filter) (bool, error) {
 			attrName, attrValue, _ = getEntryAttrValueSuffix(entry)
 		}
 
-		field, found := reflect.TypeOf(o).FieldByNameFunc(func(s string) bool { return strings.EqualFold(s, attrName) })
+		attr, found := getAttribute(o, attrName)
 		if !found {
 			return false, nil
 		}
-
-		fieldValue := reflect.ValueOf(o).FieldByName(field.Name)
-		if !fieldValue.IsValid() {
-			return false, nil
-		}
-
-		switch val := fieldValue.Interface().(type) {
-		case uint:
-			if fmt.Sprint(val) == attrValue {
-				return true, nil
-			}
-		case string:
-			if !tagValueContains(field.Tag, "ldap", "case_sensitive_value") {
-				val = strings.ToLower(val)
-				attrValue = strings.ToLower(attrValue)
-			}
-			if val == attrValue {
-				return true, nil
-			}
-		case []string:
-			for _, v := range val {
-				if !tagValueContains(field.Tag, "ldap", "case_sensitive_value") {
-					v = strings.ToLower(v)
-					attrValue = strings.ToLower(attrValue)
-				}
-				if v == attrValue {
-					return true, nil
-				}
-			}
-		}
+		return attr.hasValue(attrValue), nil
 	case ldap.FilterAnd:
 		for _, _filter := range filter {
 			ok, err := applySearchFilter(o, _filter)
@@ -565,62 +621,67 @@ func applySearchFilter(o interface{}, f ldap.Filter) (bool, error) {
 		if anyOk {
 			return true, nil
 		}
-	case ldap.FilterPresent:
-		attrName := fmt.Sprintf("%v", filter)
-		if strings.ToLower(attrName) == "entrydn" {
-			return true, nil
+	case ldap.FilterNot:
+		ok, err := applySearchFilter(o, filter.Filter)
+		if err != nil {
+			return false, err
 		}
+		return !ok, nil
+	case ldap.FilterGreaterOrEqual:
+		attrName := getAttributeType(string(filter.AttributeDesc()))
 
-		field, found := reflect.TypeOf(o).FieldByNameFunc(func(s string) bool { return strings.EqualFold(s, attrName) })
+		attr, found := getAttribute(o, attrName)
 		if !found {
 			return false, nil
 		}
+		return attr.matchOrdering(string(filter.AssertionValue()), true), nil
+	case ldap.FilterLessOrEqual:
+		attrName := getAttributeType(string(filter.AttributeDesc()))
 
-		tagValue := field.Tag.Get("json")
-		tagValue, _, _ = strings.Cut(tagValue, ",")
-		if strings.EqualFold(attrName, tagValue) {
+		attr, found := getAttribute(o, attrName)
+		if !found {
+			return false, nil
+		}
+		return attr.matchOrdering(string(filter.AssertionValue()), false), nil
+	case ldap.FilterPresent:
+		attrName := getAttributeType(fmt.Sprintf("%v", filter))
+		if strings.ToLower(attrName) == "entrydn" {
 			return true, nil
 		}
+
+		_, found := getAttribute(o, attrName)
+		return found, nil
 	case ldap.FilterSubstrings:
-		attrName := string(filter.Type_())
-		attrValues := filter.Substrings()
+		attrName := getAttributeType(string(filter.Type_()))
 
-		field, found := reflect.TypeOf(o).FieldByNameFunc(func(s string) bool { return strings.EqualFold(s, attrName) })
+		attr, found := getAttribute(o, attrName)
 		if !found {
 			return false, nil
 		}
-
-		fieldValue := reflect.ValueOf(o).FieldByName(field.Name)
-		if !fieldValue.IsValid() {
+		return attr.matchSubstrings(filter.Substrings()), nil
+	case ldap.FilterExtensibleMatch:
+		rule, attrDesc, attrValue := readExtensibleMatch(filter)
+		// only LDAP_MATCHING_RULE_IN_CHAIN is supported, other rules are undefined
+		if rule != matchingRuleInChainOID {
+			return false, nil
+		}
+		attr, found := getAttribute(o, getAttributeType(attrDesc))
+		if !found {
 			return false, nil
 		}
 
-		for _, _attrValue := range attrValues {
-			substringInitial, _ := _attrValue.(ldap.SubstringInitial)
-			attrValue := string(substringInitial)
-			switch val := fieldValue.Interface().(type) {
-			case uint:
-				if strings.HasPrefix(fmt.Sprint(val), attrValue) {
-					return true, nil
-				}
-			case string:
-				if !tagValueContains(field.Tag, "ldap", "case_sensitive_value") {
-					val = strings.ToLower(val)
-					attrValue = strings.ToLower(attrValue)
-				}
-				if strings.HasPrefix(val, attrValue) {
-					return true, nil
-				}
-			case []string:
-				for _, v := range val {
-					if !tagValueContains(field.Tag, "ldap", "case_sensitive_value") {
-						v = strings.ToLower(v)
-						attrValue = strings.ToLower(attrValue)
-					}
-					if strings.HasPrefix(v, attrValue) {
-						return true, nil
-					}
-				}
+		// transitive values are held in field from 'chain' tag
+		values := attr.values
+		if field, ok := reflect.TypeOf(o).FieldByName(attr.field); ok {
+			if chainField, ok := field.Tag.Lookup("chain"); ok {
+				values, _ = reflect.ValueOf(o).FieldByName(chainField).Interface().([]string)
+			}
+		}
+
+		attrValue = ldaputils.NormalizeEntry(attrValue)
+		for _, v := range values {
+			if ldaputils.NormalizeEntry(v) == attrValue {
+				return true, nil
 			}
 		}
 	default:
@@ -631,60 +692,63 @@ func applySearchFilter(o interface{}, f ldap.Filter) (bool, error) {
 }
 
 // createSearchEntry creates ldap.SearchResultEntry from 'o' with attributes 'attrs' and name 'entryName'
-func createSearchEntry(o interface{}, attrs []string, entryName string) (e ldap.SearchResultEntry) {
+// if 'typesOnly' is set, attribute values are omitted
+// if 'valuesFilter' is set, only attribute values matching it are returned (RFC 3876)
+func createSearchEntry(o interface{}, attrs []string, entryName string, typesOnly bool, valuesFilter []ldap.Filter) (e ldap.SearchResultEntry) {
 	// set entry name
 	e.SetObjectName(entryName)
 
+	addAttribute := func(name ldap.AttributeDescription, values ...ldap.AttributeValue) {
+		if len(valuesFilter) > 0 {
+			var filtered bool
+			values, filtered = filterAttributeValues(o, string(name), values, valuesFilter)
+			// no values matched -> omit attribute
+			if filtered && len(values) == 0 {
+				return
+			}
+		}
+		if typesOnly {
+			values = nil
+		}
+		e.AddAttribute(name, values...)
+	}
+
 	// if no attrs set -> use * (all)
 	if len(attrs) == 0 {
 		attrs = append(attrs, "*")
 	}
 
+	// 1.1 -> no attributes, ignored if other attributes are requested
+	if len(attrs) == 1 && attrs[0] == "1.1" {
+		return
+	}
+
 	for _, a := range attrs {
 		switch attr := strings.ToLower(a); attr {
 		case "entrydn":
-			e.AddAttribute("entryDN", ldap.AttributeValue(entryName))
+			addAttribute("entryDN", ldap.AttributeValue(entryName))
 		case "+": // operational only
-			e.AddAttribute("entryDN", ldap.AttributeValue(entryName))
-			rValue := reflect.ValueOf(o)
-			for i := 0; i < rValue.NumField(); i++ {
-				field := rValue.Type().Field(i)
-				if tagValueContains(field.Tag, "ldap", "skip") {
+			addAttribute("entryDN", ldap.AttributeValue(entryName))
+			for _, attr := range listAttributes(o, true) {
+				// operational attributes which are not set are omitted
+				if len(attr.values) == 1 && len(attr.values[0]) == 0 {
 					continue
 				}
-				if !tagValueContains(field.Tag, "ldap", "operational") {
-					continue
-				}
-				tagValue := field.Tag.Get("json")
-				attrName, _, _ := strings.Cut(tagValue, ",")
-				e.AddAttribute(ldap.AttributeDescription(attrName), newLDAPAttributeValues(rValue.Field(i).Interface())...)
+				addAttribute(ldap.AttributeDescription(attr.name), newLDAPAttributeValues(attr.values)...)
 			}
 		case "*": // all except operational
-			rValue := reflect.ValueOf(o)
-			for i := 0; i < rValue.NumField(); i++ {
-				field := rValue.Type().Field(i)
-				if tagValueContains(field.Tag, "ldap", "skip") {
-					continue
-				}
-				if tagValueContains(field.Tag, "ldap", "operational") {
-					continue
-				}
-				tagValue := field.Tag.Get("json")
-				attrName, _, _ := strings.Cut(tagValue, ",")
-				e.AddAttribute(ldap.AttributeDescription(attrName), newLDAPAttributeValues(rValue.Field(i).Interface())...)
+			for _, attr := range listAttributes(o, false) {
+				addAttribute(ldap.AttributeDescription(attr.name), newLDAPAttributeValues(attr.values)...)
 			}
+		case "1.1":
+			continue
 		default:
-			field, found := reflect.TypeOf(o).FieldByNameFunc(func(n string) bool { return strings.EqualFold(n, attr) })
+			attrType := getAttributeType(attr)
+			attr, found := getAttribute(o, attrType)
 			if !found {
 				continue
 			}
-			if tagValueContains(field.Tag, "ldap", "skip") {
-				continue
-			}
-			fieldValue := reflect.ValueOf(o).FieldByName(field.Name)
-			if fieldValue.IsValid() {
-				e.AddAttribute(ldap.AttributeDescription(a), newLDAPAttributeValues(fieldValue.Interface())...)
-			}
+			addAttribute(ldap.AttributeDescription(a), newLDAPAttributeValues(attr.values)...)
 		}
 	}
 
@@ -696,6 +760,11 @@ func newLDAPAttributeValues(in interface{}) (out []ldap.AttributeValue) {
 	switch in := in.(type) {
 	case uint:
 		out = append(out, ldap.AttributeValue(fmt.Sprint(in)))
+	case *int:
+		// optional integer, nil means no value
+		if in != nil {
+			out = append(out, ldap.AttributeValue(fmt.Sprint(*in)))
+		}
 	case string:
 		out = append(out, ldap.AttributeValue(in))
 	case []string:
@@ -705,3 +774,262 @@ func newLDAPAttributeValues(in interface{}) (out []ldap.AttributeValue) {
 	}
 	return
 }
+
+// findSearchEntries returns all entries within scope 'scope' of 'baseObject' matching filter 'f'
+// search is stopped with errTimeLimitExceeded after 'deadline' if it is set
+// indexed entries are used for base object scope and filters which can be resolved with index
+func findSearchEntries(m *ldapserver.Message, entries *data.Entries, baseObject, baseDN, usersOUName, groupsOUName string, scope int, f ldap.Filter, deadline time.Time) ([]searchEntry, error) {
+	var found []searchEntry
+	for _, e := range getSearchCandidates(entries, baseObject, baseDN, usersOUName, groupsOUName, scope, f) {
+		// handle stop signal
+		select {
+		case <-m.Done:
+			return nil, errSearchAbandoned
+		default:
+		}
+
+		if !deadline.IsZero() && time.Now().After(deadline) {
+			return nil, errTimeLimitExceeded
+		}
+
+		if !isInScope(ldaputils.NormalizeEntry(e.name), baseObject, scope) {
+			continue
+		}
+
+		ok, err := applySearchFilter(e.o, f)
+		if err != nil {
+			return nil, err
+		}
+		if ok {
+			found = append(found, e)
+		}
+	}
+
+	return found, nil
+}
+
+// getSearchCandidates returns entries which may be within scope 'scope' of 'baseObject' and match filter 'f'
+func getSearchCandidates(entries *data.Entries, baseObject, baseDN, usersOUName, groupsOUName string, scope int, f ldap.Filter) []searchEntry {
+	idx := entries.Index
+	if idx == nil {
+		return listEntries(entries, baseDN, usersOUName, groupsOUName)
+	}
+
+	if scope == ldap.SearchRequestScopeBaseObject {
+		i, ok := idx.ByDN[ldaputils.NormalizeEntry(baseObject)]
+		if !ok {
+			return nil
+		}
+		return []searchEntry{{name: idx.Names[i], o: idx.Objects[i]}}
+	}
+
+	positions, ok := getIndexCandidates(idx, f)
+	if !ok {
+		return listEntries(entries, baseDN, usersOUName, groupsOUName)
+	}
+	list := make([]searchEntry, 0, len(positions))
+	for _, i := range positions {
+		list = append(list, searchEntry{name: idx.Names[i], o: idx.Objects[i]})
+	}
+	return list
+}
+
+// listEntries returns all entries with their names, names are taken from index if entries are indexed
+func listEntries(entries *data.Entries, baseDN, usersOUName, groupsOUName string) []searchEntry {
+	if idx := entries.Index; idx != nil {
+		list := make([]searchEntry, 0, len(idx.Names))
+		for i := range idx.Names {
+			list = append(list, searchEntry{name: idx.Names[i], o: idx.Objects[i]})
+		}
+		return list
+	}
+
+	var list []searchEntry
+
+	list = append(list, searchEntry{name: baseDN, o: entries.Domain})
+	for _, ou := range entries.OUs {
+		list = append(list, searchEntry{name: getEntryName(ou, baseDN, usersOUName, groupsOUName), o: ou})
+	}
+	for _, user := range entries.Users {
+		list = append(list, searchEntry{name: getEntryName(user, baseDN, usersOUName, groupsOUName), o: user})
+	}
+	for _, group := range entries.Groups {
+		list = append(list, searchEntry{name: getEntryName(group, baseDN, usersOUName, groupsOUName), o: group})
+	}
+	for _, sudoRole := range entries.SudoRoles {
+		list = append(list, searchEntry{name: getEntryName(sudoRole, baseDN, usersOUName, groupsOUName), o: sudoRole})
+	}
+	for _, netgroup := range entries.Netgroups {
+		list = append(list, searchEntry{name: getEntryName(netgroup, baseDN, usersOUName, groupsOUName), o: netgroup})
+	}
+	for _, host := range entries.Hosts {
+		list = append(list, searchEntry{name: getEntryName(host, baseDN, usersOUName, groupsOUName), o: host})
+	}
+	for _, m := range entries.AutomountMaps {
+		list = append(list, searchEntry{name: getEntryName(m, baseDN, usersOUName, groupsOUName), o: m})
+	}
+	for _, automount := range entries.Automounts {
+		list = append(list, searchEntry{name: getEntryName(automount, baseDN, usersOUName, groupsOUName), o: automount})
+	}
+
+	return list
+}
+
+// getEntryName returns name of entry 'o'
+func getEntryName(o interface{}, baseDN, usersOUName, groupsOUName string) string {
+	switch o := o.(type) {
+	case data.OU:
+		if len(o.Parent) > 0 {
+			return fmt.Sprintf("ou=%s,%s,%s", ldaputils.EscapeValue(o.OU), o.Parent, baseDN)
+		}
+		return fmt.Sprintf("ou=%s,%s", ldaputils.EscapeValue(o.OU), baseDN)
+	case data.User:
+		parent, _ := getParentOU(o, baseDN, usersOUName, groupsOUName)
+		return fmt.Sprintf("cn=%s,%s,%s", ldaputils.EscapeValue(o.CN), parent, baseDN)
+	case data.Group:
+		parent, _ := getParentOU(o, baseDN, usersOUName, groupsOUName)
+		return fmt.Sprintf("cn=%s,%s,%s", ldaputils.EscapeValue(o.CN), parent, baseDN)
+	case data.SudoRole:
+		return fmt.Sprintf("cn=%s,%s,%s", ldaputils.EscapeValue(o.CN), o.ParentOU, baseDN)
+	case data.Netgroup:
+		return fmt.Sprintf("cn=%s,%s,%s", ldaputils.EscapeValue(o.CN), o.ParentOU, baseDN)
+	case data.Host:
+		var cn string
+		if len(o.CN) > 0 {
+			cn = o.CN[0]
+		}
+		return fmt.Sprintf("cn=%s,%s,%s", ldaputils.EscapeValue(cn), o.ParentOU, baseDN)
+	case data.AutomountMap:
+		return fmt.Sprintf("automountMapName=%s,%s,%s", ldaputils.EscapeValue(o.AutomountMapName), o.ParentOU, baseDN)
+	case data.Automount:
+		return fmt.Sprintf("automountKey=%s,%s,%s", ldaputils.EscapeValue(o.AutomountKey), o.Parent, baseDN)
+	}
+	return baseDN
+}
+
+// persistSearch sends changed entries matching search request until search is abandoned or its time limit is exceeded
+func persistSearch(w ldapserver.ResponseWriter, m *ldapserver.Message, changes chan changeSet, ps persistentSearch, baseObject string, scope int, f ldap.Filter, attrs []string, typesOnly bool, valuesFilter []ldap.Filter, deadline time.Time, logger *logrus.Logger) {
+	// time limit applies to whole persistent search
+	var timeLimit <-chan time.Time
+	if !deadline.IsZero() {
+		timer := time.NewTimer(time.Until(deadline))
+		defer timer.Stop()
+		timeLimit = timer.C
+	}
+
+	for {
+		select {
+		case <-m.Done:
+			logger.Infof("client [%d]: leaving handleSearch...", m.Client.Numero())
+			return
+		case <-timeLimit:
+			res := ldapserver.NewSearchResultDoneResponse(ldapserver.LDAPResultTimeLimitExceeded)
+			w.Write(res)
+
+			logger.Errorf("client [%d]: search error: persistent search time limit exceeded", m.Client.Numero())
+			return
+		case set, ok := <-changes:
+			// subscriber dropped by notifier
+			if !ok {
+				res := ldapserver.NewSearchResultDoneResponse(ldapserver.LDAPResultAdminLimitExceeded)
+				res.SetDiagnosticMessage("too many pending changes")
+				w.Write(res)
+
+				logger.Errorf("client [%d]: search error: persistent search dropped, too many pending changes", m.Client.Numero())
+				return
+			}
+
+			for _, change := range set.changes {
+				if ps.changeTypes&change.changeType == 0 {
+					continue
+				}
+				if !isInScope(ldaputils.NormalizeEntry(change.entry.name), baseObject, scope) {
+					continue
+				}
+				ok, err := applySearchFilter(change.entry.o, f)
+				if err != nil {
+					res := ldapserver.NewSearchResultDoneResponse(ldapserver.LDAPResultUnwillingToPerform)
+					res.SetDiagnosticMessage(err.Error())
+					w.Write(res)
+
+					logger.Errorf("client [%d]: search error: %s", m.Client.Numero(), err)
+					return
+				}
+				if !ok {
+					continue
+				}
+
+				e := createSearchEntry(change.entry.o, attrs, change.entry.name, typesOnly, valuesFilter)
+				if ps.returnECs {
+					responseMessage := ldap.NewLDAPMessageWithProtocolOp(e)
+					ldap.SetMessageControls(responseMessage, ldap.Controls{newEntryChangeNotificationControl(change.changeType, change.previousName, change.changeNumber)})
+					w.WriteMessage(responseMessage)
+				} else {
+					w.Write(e)
+				}
+
+				logger.Infof("client [%d]: search persistent changetype=%d dn='%s'", m.Client.Numero(), change.changeType, change.entry.name)
+			}
+		}
+	}
+}
+
+// filterAttributeValues returns values of object's 'o' attribute 'attrName' matching values return filter 'filter'
+// and true if filter has items for this attribute
+func filterAttributeValues(o interface{}, attrName string, values []ldap.AttributeValue, filter []ldap.Filter) ([]ldap.AttributeValue, bool) {
+	attrType := getAttributeType(attrName)
+
+	var items []ldap.Filter
+	for _, f := range filter {
+		if filterAttr, ok := getFilterAttribute(f); ok && (len(filterAttr) == 0 || strings.EqualFold(getAttributeType(filterAttr), attrType)) {
+			items = append(items, f)
+		}
+	}
+	if len(items) == 0 {
+		return values, false
+	}
+
+	attr, found := getAttribute(o, attrType)
+	if !found {
+		return values, false
+	}
+
+	var filtered []ldap.AttributeValue
+	for _, v := range values {
+		// evaluate filter over attribute having single value
+		single := attr
+		single.values = []string{string(v)}
+
+		for _, f := range items {
+			if applyAttributeFilter(single, f) {
+				filtered = append(filtered, v)
+				break
+			}
+		}
+	}
+
+	return filtered, true
+}
+
+// getFilterAttribute returns attribute description of simple filter 'f'
+func getFilterAttribute(f ldap.Filter) (string, bool) {
+	switch filter := f.(type) {
+	case ldap.FilterEqualityMatch:
+		return string(filter.AttributeDesc()), true
+	case ldap.FilterSubstrings:
+		return string(filter.Type_()), true
+	case ldap.FilterGreaterOrEqual:
+		return string(filter.AttributeDesc()), true
+	case ldap.FilterLessOrEqual:
+		return string(filter.AttributeDesc()), true
+	case ldap.FilterPresent:
+		return fmt.Sprintf("%v", filter), true
+	case ldap.FilterApproxMatch:
+		return string(filter.AttributeDesc()), true
+	case ldap.FilterExtensibleMatch:
+		// extensible match without type applies to all attributes
+		_, attrDesc, _ := readExtensibleMatch(filter)
+		return attrDesc, true
+	}
+	return "", false
+}
@@ -0,0 +1,131 @@
+package ldap
+
+import (
+	"reflect"
+	"testing"
+
+	ldap "github.com/ps78674/goldap/message"
+	"github.com/ps78674/gorestldap/internal/ber"
+	"github.com/ps78674/gorestldap/internal/data"
+	ldapserver "github.com/ps78674/ldapserver"
+)
+
+// mustDecodeFilter returns filter decoded from BER element 'p'
+func mustDecodeFilter(t *testing.T, p *ber.Packet) ldap.Filter {
+	t.Helper()
+	f, err := decodeFilter(p)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return f
+}
+
+// extensibleFilter returns BER encoded extensible match filter item, dnAttributes are always set as goldap requires them
+func extensibleFilter(rule, attr, value string) *ber.Packet {
+	p := ber.NewConstructed(ber.ClassContext, 9)
+	if len(rule) > 0 {
+		p.Append(ber.NewPrimitive(ber.ClassContext, 1, []byte(rule)))
+	}
+	if len(attr) > 0 {
+		p.Append(ber.NewPrimitive(ber.ClassContext, 2, []byte(attr)))
+	}
+	return p.Append(ber.NewPrimitive(ber.ClassContext, 3, []byte(value)), ber.NewPrimitive(ber.ClassContext, 4, []byte{0xff}))
+}
+
+func TestCheckAssertion(t *testing.T) {
+	user := data.User{CN: "user01", UID: "user01"}
+
+	tests := []struct {
+		name       string
+		filter     *ber.Packet
+		wantResult int
+	}{
+		{"true", equalityFilter("uid", "user01"), ldapserver.LDAPResultSuccess},
+		{"false", equalityFilter("uid", "user02"), ldapResultAssertionFailed},
+		// filter which can not be evaluated is not a failed assertion, as it is for search and compare
+		{"unsupported filter", ber.NewConstructed(ber.ClassContext, 8, ber.NewOctetString("uid"), ber.NewOctetString("user01")), ldapserver.LDAPResultUnwillingToPerform},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result, err := checkAssertion(user, mustDecodeFilter(t, tt.filter))
+			if result != tt.wantResult {
+				t.Errorf("result = %d, want %d", result, tt.wantResult)
+			}
+			if (err != nil) != (tt.wantResult != ldapserver.LDAPResultSuccess) {
+				t.Errorf("err = %v, want error %t", err, tt.wantResult != ldapserver.LDAPResultSuccess)
+			}
+		})
+	}
+}
+
+func TestFilterAttributeValues(t *testing.T) {
+	user := data.User{CN: "user01", UIDNumber: 1000, MemberOf: []string{"cn=admins,ou=groups,dc=example,dc=com", "cn=Users,ou=groups,dc=example,dc=com"}}
+	values := []ldap.AttributeValue{"cn=admins,ou=groups,dc=example,dc=com", "cn=Users,ou=groups,dc=example,dc=com"}
+
+	tests := []struct {
+		name     string
+		attr     string
+		values   []ldap.AttributeValue
+		items    []*ber.Packet
+		want     []ldap.AttributeValue
+		wantUsed bool
+	}{
+		{"equality", "memberOf", values, []*ber.Packet{equalityFilter("memberOf", "CN=users, ou=groups,dc=example,dc=com")}, values[1:], true},
+		{"attribute options", "memberOf", values, []*ber.Packet{equalityFilter("memberOf;x-opt", "cn=users,ou=groups,dc=example,dc=com")}, values[1:], true},
+		{"other attribute", "memberOf", values, []*ber.Packet{equalityFilter("cn", "user01")}, values, false},
+		{"greater or equal", "memberOf", values, []*ber.Packet{ber.NewConstructed(ber.ClassContext, 5, ber.NewOctetString("memberOf"), ber.NewOctetString("cn=b"))}, values[1:], true},
+		{"less or equal", "uidNumber", []ldap.AttributeValue{"1000"}, []*ber.Packet{ber.NewConstructed(ber.ClassContext, 6, ber.NewOctetString("uidNumber"), ber.NewOctetString("999"))}, nil, true},
+		{"approx", "memberOf", values, []*ber.Packet{ber.NewConstructed(ber.ClassContext, 8, ber.NewOctetString("memberOf"), ber.NewOctetString("cn=admins,ou=groups,dc=example,dc=com"))}, values[:1], true},
+		{"extensible", "memberOf", values, []*ber.Packet{extensibleFilter("", "memberOf", "cn=admins,ou=groups,dc=example,dc=com")}, values[:1], true},
+		{"extensible with rule", "memberOf", values, []*ber.Packet{extensibleFilter("caseExactMatch", "memberOf", "cn=Users,ou=groups,dc=example,dc=com")}, values[1:], true},
+		{"extensible without type", "cn", []ldap.AttributeValue{"user01"}, []*ber.Packet{extensibleFilter("caseIgnoreMatch", "", "USER01")}, []ldap.AttributeValue{"user01"}, true},
+		{"extensible with unknown rule", "memberOf", values, []*ber.Packet{extensibleFilter("1.2.3.4", "memberOf", "x")}, nil, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var filter []ldap.Filter
+			for _, p := range tt.items {
+				filter = append(filter, mustDecodeFilter(t, p))
+			}
+			got, used := filterAttributeValues(user, tt.attr, tt.values, filter)
+			if !reflect.DeepEqual(got, tt.want) || used != tt.wantUsed {
+				t.Errorf("values = %v, %t, want %v, %t", got, used, tt.want, tt.wantUsed)
+			}
+		})
+	}
+}
+
+func TestApplySearchFilterInChain(t *testing.T) {
+	group := data.Group{
+		CN:               "group01",
+		Member:           []string{"cn=group02,ou=groups,dc=example,dc=com"},
+		TransitiveMember: []string{"cn=group02,ou=groups,dc=example,dc=com", "cn=user01,ou=users,dc=example,dc=com"},
+	}
+	user := data.User{CN: "user01", BackendMemberOf: []string{"cn=group01,ou=groups,dc=example,dc=com"}}
+
+	tests := []struct {
+		name   string
+		o      interface{}
+		filter *ber.Packet
+		want   bool
+	}{
+		{"direct member", group, extensibleFilter(matchingRuleInChainOID, "member", "cn=group02,ou=groups,dc=example,dc=com"), true},
+		{"nested member", group, extensibleFilter(matchingRuleInChainOID, "member", "CN=user01, ou=users,dc=example,dc=com"), true},
+		{"not a member", group, extensibleFilter(matchingRuleInChainOID, "member", "cn=user02,ou=users,dc=example,dc=com"), false},
+		{"other rule", group, extensibleFilter("caseIgnoreMatch", "member", "cn=group02,ou=groups,dc=example,dc=com"), false},
+		// fields which are not served can not be matched
+		{"hidden transitive members", group, extensibleFilter(matchingRuleInChainOID, "transitiveMember", "cn=user01,ou=users,dc=example,dc=com"), false},
+		{"hidden backend memberOf", user, extensibleFilter(matchingRuleInChainOID, "backendMemberOf", "cn=group01,ou=groups,dc=example,dc=com"), false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ok, err := applySearchFilter(tt.o, mustDecodeFilter(t, tt.filter))
+			if err != nil || ok != tt.want {
+				t.Errorf("applySearchFilter = %t, %v, want %t", ok, err, tt.want)
+			}
+		})
+	}
+}
@@ -2,36 +2,67 @@ package ldap
 
 import (
 	"fmt"
+	"time"
 
 	"github.com/ps78674/gorestldap/internal/backend"
+	"github.com/ps78674/gorestldap/internal/config"
 	"github.com/ps78674/gorestldap/internal/data"
+	"github.com/ps78674/gorestldap/internal/ldaputils"
 	"github.com/ps78674/gorestldap/internal/ticker"
 	ldapserver "github.com/ps78674/ldapserver"
 	"github.com/sirupsen/logrus"
 )
 
-func NewServer(entries *data.Entries, baseDN, usersOUName, groupsOUName string, respectCritical bool, backend backend.Backend, ticker *ticker.Ticker, logger *logrus.Logger) (*ldapserver.Server, error) {
+// NamingContext is a suffix with entries loaded from its own backend
+type NamingContext struct {
+	Entries      *data.Snapshot
+	BaseDN       string
+	UsersOUName  string
+	GroupsOUName string
+	Notifier     *Notifier
+	Backend      backend.Backend
+	Ticker       *ticker.Ticker
+}
+
+func NewServer(contexts []*NamingContext, respectCritical bool, computedMemberOf bool, groupSchema config.GroupSchema, limits map[string]config.Limits, pagedSearchTimeout time.Duration, logger *logrus.Logger) (*ldapserver.Server, error) {
 	// create server
 	s := ldapserver.NewServer()
 
 	logger.Debug("registering handlers")
 
+	// paged search states
+	pagedSearches := newPagedSearches(pagedSearchTimeout)
+
+	// suffixes for root DSE
+	var baseDNs []string
+	for _, nc := range contexts {
+		baseDNs = append(baseDNs, nc.BaseDN)
+	}
+
 	// create route bindings
 	routes := ldapserver.NewRouteMux()
 	routes.Bind(func(w ldapserver.ResponseWriter, m *ldapserver.Message) {
-		handleBind(w, m, entries, baseDN, usersOUName, logger)
+		r := m.GetBindRequest()
+		nc := getNamingContext(contexts, string(r.Name()))
+		handleBind(w, m, nc.Entries, nc.BaseDN, nc.UsersOUName, nc.GroupsOUName, logger)
 	})
 	routes.Search(func(w ldapserver.ResponseWriter, m *ldapserver.Message) {
-		handleSearchDSE(w, m, baseDN, logger)
+		handleSearchDSE(w, m, baseDNs, logger)
 	}).BaseDn("").Scope(ldapserver.SearchRequestScopeBaseObject).Filter("(objectclass=*)")
 	routes.Search(func(w ldapserver.ResponseWriter, m *ldapserver.Message) {
-		handleSearch(w, m, entries, baseDN, usersOUName, groupsOUName, respectCritical, logger)
+		r := m.GetSearchRequest()
+		nc := getNamingContext(contexts, string(r.BaseObject()))
+		handleSearch(w, m, nc.Entries, nc.BaseDN, nc.UsersOUName, nc.GroupsOUName, respectCritical, limits, pagedSearches, nc.Notifier, logger)
 	})
 	routes.Compare(func(w ldapserver.ResponseWriter, m *ldapserver.Message) {
-		handleCompare(w, m, entries, baseDN, usersOUName, groupsOUName, logger)
+		r := m.GetCompareRequest()
+		nc := getNamingContext(contexts, string(r.Entry()))
+		handleCompare(w, m, nc.Entries, nc.BaseDN, nc.UsersOUName, nc.GroupsOUName, respectCritical, logger)
 	})
 	routes.Modify(func(w ldapserver.ResponseWriter, m *ldapserver.Message) {
-		handleModify(w, m, entries, baseDN, usersOUName, groupsOUName, backend, ticker, logger)
+		r := m.GetModifyRequest()
+		nc := getNamingContext(contexts, string(r.Object()))
+		handleModify(w, m, nc.Entries, nc.BaseDN, nc.UsersOUName, nc.GroupsOUName, respectCritical, computedMemberOf, groupSchema, nc.Backend, nc.Ticker, logger)
 	})
 
 	// attach routes to server
@@ -41,3 +72,14 @@ func NewServer(entries *data.Entries, baseDN, usersOUName, groupsOUName string,
 
 	return s, nil
 }
+
+// getNamingContext returns naming context of entry 'dn', first (default) naming context is returned for other entries
+func getNamingContext(contexts []*NamingContext, dn string) *NamingContext {
+	dn = ldaputils.NormalizeEntry(dn)
+	for _, nc := range contexts {
+		if ldaputils.IsSubordinate(dn, nc.BaseDN, true) {
+			return nc
+		}
+	}
+	return contexts[0]
+}
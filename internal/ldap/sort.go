@@ -0,0 +1,234 @@
+package ldap
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	ldap "github.com/ps78674/goldap/message"
+	ldapserver "github.com/ps78674/ldapserver"
+)
+
+// virtual list view result codes
+const (
+	ldapResultSortControlMissing   = 60
+	ldapResultOffsetRangeError     = 61
+	ldapResultVirtualListViewError = 76
+)
+
+// sortValue is a comparable value of an entry sort key
+type sortValue struct {
+	present bool
+	numeric bool
+	num     uint64
+	str     string
+}
+
+// compareSortValues compares two sort values, absent values are greater than any other
+func compareSortValues(a, b sortValue) int {
+	switch {
+	case !a.present && !b.present:
+		return 0
+	case !a.present:
+		return 1
+	case !b.present:
+		return -1
+	case a.numeric && b.numeric:
+		switch {
+		case a.num < b.num:
+			return -1
+		case a.num > b.num:
+			return 1
+		}
+		return 0
+	}
+	return strings.Compare(a.str, b.str)
+}
+
+// checkOrderingRule returns error if ordering rule is not supported
+func checkOrderingRule(rule string) error {
+	switch strings.ToLower(rule) {
+	case "", "caseignoreorderingmatch", "2.5.13.3", "caseexactorderingmatch", "2.5.13.6", "integerorderingmatch", "2.5.13.15":
+		return nil
+	}
+	return fmt.Errorf("unsupported ordering rule '%s'", rule)
+}
+
+// checkSortKeys returns sort keys search results are sorted with and sort response controls
+// unsupported ordering rule of non critical sort control skips sorting, error is returned with success result then
+// other results mean search fails with error: sort control is critical or virtual list view 'vlv' can not be applied to unsorted results
+func checkSortKeys(sortKeys []sortKey, critical, vlv bool) ([]sortKey, ldap.Controls, int, error) {
+	for _, key := range sortKeys {
+		err := checkOrderingRule(key.orderingRule)
+		if err == nil {
+			continue
+		}
+		if critical {
+			return nil, nil, ldapserver.LDAPResultUnavailableCriticalExtension, err
+		}
+		controls := ldap.Controls{newSortResponseControl(ldapserver.LDAPResultInappropriateMatching, key.attr)}
+		if vlv {
+			return nil, controls, ldapResultSortControlMissing, fmt.Errorf("virtual list view requires sorted results: %s", err)
+		}
+		return nil, controls, ldapserver.LDAPResultSuccess, err
+	}
+	return sortKeys, nil, ldapserver.LDAPResultSuccess, nil
+}
+
+// newSortValue creates sort value from string 's' of attribute 'attr' using ordering rule 'rule'
+func newSortValue(s string, attr attribute, rule string) sortValue {
+	v := sortValue{present: true, str: s}
+
+	switch strings.ToLower(rule) {
+	case "caseexactorderingmatch", "2.5.13.6":
+		return v
+	case "caseignoreorderingmatch", "2.5.13.3":
+		v.str = strings.ToLower(s)
+		return v
+	}
+
+	if attr.numeric || strings.EqualFold(rule, "integerorderingmatch") || rule == "2.5.13.15" {
+		if n, err := strconv.ParseUint(s, 10, 64); err == nil {
+			v.numeric = true
+			v.num = n
+			return v
+		}
+	}
+
+	if !attr.caseSensitive {
+		v.str = strings.ToLower(s)
+	}
+
+	return v
+}
+
+// getSortValue returns sort value of object 'o' for key 'key'
+// for multi-valued attributes the least value is used in ascending order and the greatest in descending
+func getSortValue(o interface{}, key sortKey) sortValue {
+	values, attr, found := getAttrValues(o, key.attr)
+	if !found || len(values) == 0 {
+		return sortValue{}
+	}
+
+	var v sortValue
+	for _, s := range values {
+		sv := newSortValue(s, attr, key.orderingRule)
+		if !v.present {
+			v = sv
+			continue
+		}
+		c := compareSortValues(sv, v)
+		if (!key.reverse && c < 0) || (key.reverse && c > 0) {
+			v = sv
+		}
+	}
+
+	return v
+}
+
+// sortSearchEntries sorts entries using sort keys 'keys'
+func sortSearchEntries(entries []searchEntry, keys []sortKey) {
+	// precompute sort values, reflection is too slow for comparator
+	values := make([][]sortValue, len(entries))
+	for i, e := range entries {
+		for _, key := range keys {
+			values[i] = append(values[i], getSortValue(e.o, key))
+		}
+	}
+
+	idx := make([]int, len(entries))
+	for i := range idx {
+		idx[i] = i
+	}
+	sort.SliceStable(idx, func(i, j int) bool {
+		a, b := values[idx[i]], values[idx[j]]
+		for k, key := range keys {
+			c := compareSortValues(a[k], b[k])
+			if c == 0 {
+				continue
+			}
+			if key.reverse {
+				return c > 0
+			}
+			return c < 0
+		}
+		return false
+	})
+
+	sorted := make([]searchEntry, len(entries))
+	for i, j := range idx {
+		sorted[i] = entries[j]
+	}
+	copy(entries, sorted)
+}
+
+// applyVLV returns entries window for virtual list view request 'req' over sorted entries,
+// target position (starting from 1) and virtual list view result code
+func applyVLV(entries []searchEntry, key sortKey, req vlvRequest) ([]searchEntry, int, int) {
+	count := len(entries)
+
+	var target int
+	switch {
+	case req.byOffset:
+		if req.offset < 1 {
+			return nil, 0, ldapResultOffsetRangeError
+		}
+		target = req.offset
+		// scale offset to actual content count estimated by client
+		if req.contentCount > 0 && req.contentCount != count {
+			switch {
+			case req.offset >= req.contentCount:
+				target = count
+			case req.offset == 1:
+				target = 1
+			default:
+				target = int(float64(req.offset)*float64(count)/float64(req.contentCount) + 0.5)
+			}
+		}
+		if target > count {
+			target = count
+		}
+		if target < 1 && count > 0 {
+			target = 1
+		}
+	default:
+		// first entry which sort key is greater or equal (less or equal for reverse order) than assertion value
+		target = count + 1
+		for i, e := range entries {
+			values, attr, found := getAttrValues(e.o, key.attr)
+			if !found || len(values) == 0 {
+				// absent values are greater than any assertion value
+				if !key.reverse {
+					target = i + 1
+					break
+				}
+				continue
+			}
+			c := compareSortValues(getSortValue(e.o, key), newSortValue(req.assertionValue, attr, key.orderingRule))
+			if (!key.reverse && c >= 0) || (key.reverse && c <= 0) {
+				target = i + 1
+				break
+			}
+		}
+	}
+
+	if count == 0 {
+		return nil, 0, ldap.ResultCodeSuccess
+	}
+
+	// target is 1-based, window boundaries are 0-based
+	start := target - 1 - req.beforeCount
+	if start < 0 {
+		start = 0
+	}
+	end := target + req.afterCount
+	if end > count {
+		end = count
+	}
+	if start > end {
+		start = end
+	}
+
+	return entries[start:end], target, ldap.ResultCodeSuccess
+}
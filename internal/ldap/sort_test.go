@@ -0,0 +1,106 @@
+package ldap
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/ps78674/gorestldap/internal/data"
+	ldapserver "github.com/ps78674/ldapserver"
+)
+
+func newSortTestEntries(cns ...string) []searchEntry {
+	entries := make([]searchEntry, 0, len(cns))
+	for _, cn := range cns {
+		entries = append(entries, searchEntry{name: "cn=" + cn, o: data.User{CN: cn}})
+	}
+	return entries
+}
+
+func entryNames(entries []searchEntry) []string {
+	names := []string{}
+	for _, e := range entries {
+		names = append(names, e.name)
+	}
+	return names
+}
+
+func TestCheckSortKeys(t *testing.T) {
+	supported := []sortKey{{attr: "cn", orderingRule: "caseIgnoreOrderingMatch"}}
+	unsupported := []sortKey{{attr: "cn"}, {attr: "sn", orderingRule: "1.2.3.4"}}
+
+	tests := []struct {
+		name         string
+		keys         []sortKey
+		critical     bool
+		vlv          bool
+		wantKeys     []sortKey
+		wantControls int
+		wantResult   int
+		wantErr      bool
+	}{
+		{"supported", supported, false, false, supported, 0, ldapserver.LDAPResultSuccess, false},
+		{"supported with vlv", supported, true, true, supported, 0, ldapserver.LDAPResultSuccess, false},
+		{"unsupported non critical", unsupported, false, false, nil, 1, ldapserver.LDAPResultSuccess, true},
+		{"unsupported critical", unsupported, true, false, nil, 0, ldapserver.LDAPResultUnavailableCriticalExtension, true},
+		// regression: unsorted results were passed to virtual list view which indexed empty sort keys
+		{"unsupported non critical with vlv", unsupported, false, true, nil, 1, ldapResultSortControlMissing, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			keys, controls, result, err := checkSortKeys(tt.keys, tt.critical, tt.vlv)
+			if !reflect.DeepEqual(keys, tt.wantKeys) {
+				t.Errorf("keys = %v, want %v", keys, tt.wantKeys)
+			}
+			if len(controls) != tt.wantControls {
+				t.Errorf("got %d controls, want %d", len(controls), tt.wantControls)
+			}
+			if result != tt.wantResult {
+				t.Errorf("result = %d, want %d", result, tt.wantResult)
+			}
+			if (err != nil) != tt.wantErr {
+				t.Errorf("err = %v, want error %t", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestApplyVLV(t *testing.T) {
+	key := sortKey{attr: "cn"}
+	reverse := sortKey{attr: "cn", reverse: true}
+
+	tests := []struct {
+		name       string
+		cns        []string
+		key        sortKey
+		req        vlvRequest
+		wantNames  []string
+		wantTarget int
+		wantResult int
+	}{
+		{"offset", []string{"a", "b", "c", "d", "e"}, key, vlvRequest{byOffset: true, offset: 3, beforeCount: 1, afterCount: 1}, []string{"cn=b", "cn=c", "cn=d"}, 3, ldapserver.LDAPResultSuccess},
+		{"first", []string{"a", "b", "c"}, key, vlvRequest{byOffset: true, offset: 1, beforeCount: 5, afterCount: 0}, []string{"cn=a"}, 1, ldapserver.LDAPResultSuccess},
+		{"offset past end", []string{"a", "b", "c"}, key, vlvRequest{byOffset: true, offset: 10, beforeCount: 1}, []string{"cn=b", "cn=c"}, 3, ldapserver.LDAPResultSuccess},
+		{"scaled offset", []string{"a", "b", "c", "d", "e"}, key, vlvRequest{byOffset: true, offset: 5, contentCount: 10}, []string{"cn=c"}, 3, ldapserver.LDAPResultSuccess},
+		{"zero offset", []string{"a"}, key, vlvRequest{byOffset: true}, []string{}, 0, ldapResultOffsetRangeError},
+		{"empty", nil, key, vlvRequest{byOffset: true, offset: 1}, []string{}, 0, ldapserver.LDAPResultSuccess},
+		{"assertion", []string{"a", "b", "d", "e"}, key, vlvRequest{assertionValue: "C", afterCount: 1}, []string{"cn=d", "cn=e"}, 3, ldapserver.LDAPResultSuccess},
+		{"assertion past end", []string{"a", "b"}, key, vlvRequest{assertionValue: "z", beforeCount: 1}, []string{"cn=b"}, 3, ldapserver.LDAPResultSuccess},
+		{"reverse assertion", []string{"e", "d", "b", "a"}, reverse, vlvRequest{assertionValue: "c"}, []string{"cn=b"}, 3, ldapserver.LDAPResultSuccess},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			entries, target, result := applyVLV(newSortTestEntries(tt.cns...), tt.key, tt.req)
+			if names := entryNames(entries); !reflect.DeepEqual(names, tt.wantNames) {
+				t.Errorf("entries = %v, want %v", names, tt.wantNames)
+			}
+			if target != tt.wantTarget {
+				t.Errorf("target = %d, want %d", target, tt.wantTarget)
+			}
+			if result != tt.wantResult {
+				t.Errorf("result = %d, want %d", result, tt.wantResult)
+			}
+		})
+	}
+}
@@ -1,5 +1,12 @@
 package ldap
 
+// client roles for administrative limits
+const (
+	roleAnonymous = "anonymous"
+	roleUsers     = "users"
+	roleAdmins    = "admins"
+)
+
 type clientACL struct {
 	bindEntry string
 	search    bool
@@ -7,16 +14,22 @@ type clientACL struct {
 	modify    bool
 }
 
-type clientSearchControl struct {
-	domainDone bool
-	ousDone    bool
-	usersDone  bool
-	groupsDone bool
-	count      int
-	sent       int
+// role returns client role based on its ACLs
+func (acl clientACL) role() string {
+	switch {
+	case acl.search:
+		return roleAdmins
+	case len(acl.bindEntry) > 0:
+		return roleUsers
+	}
+	return roleAnonymous
 }
 
 type additionalData struct {
 	acl clientACL
-	sc  clientSearchControl
+}
+
+type searchEntry struct {
+	name string
+	o    interface{}
 }
@@ -0,0 +1,356 @@
+package ldap
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/google/uuid"
+	ldap "github.com/ps78674/goldap/message"
+	"github.com/ps78674/gorestldap/internal/ber"
+	"github.com/ps78674/gorestldap/internal/data"
+	"github.com/ps78674/gorestldap/internal/ldaputils"
+	ldapserver "github.com/ps78674/ldapserver"
+	"github.com/sirupsen/logrus"
+)
+
+// content synchronization modes (RFC 4533)
+const (
+	syncModeRefreshOnly       = 1
+	syncModeRefreshAndPersist = 3
+)
+
+// content synchronization entry states (RFC 4533)
+const (
+	syncStateNone    = -1
+	syncStatePresent = 0
+	syncStateAdd     = 1
+	syncStateModify  = 2
+	syncStateDelete  = 3
+)
+
+// sync info message choices (RFC 4533)
+const (
+	syncInfoNewCookie      = 0
+	syncInfoRefreshDelete  = 1
+	syncInfoRefreshPresent = 2
+)
+
+// e-syncRefreshRequired result code (RFC 4533)
+const ldapResultSyncRefreshRequired = 4096
+
+// syncEntry is an entry sent to content synchronization consumer
+type syncEntry struct {
+	state int
+	entry searchEntry
+}
+
+// syncSearch holds content synchronization search parameters
+type syncSearch struct {
+	w          ldapserver.ResponseWriter
+	m          *ldapserver.Message
+	baseObject string
+	scope      int
+	filter     ldap.Filter
+	attrs      []string
+	typesOnly  bool
+	// values return filter (RFC 3876)
+	valuesFilter []ldap.Filter
+}
+
+// handleSyncSearch serves content synchronization (syncrepl) request 'req'
+func handleSyncSearch(s syncSearch, req syncRequest, snapshot *data.Snapshot, baseDN, usersOUName, groupsOUName string, notifier *Notifier, logger *logrus.Logger) {
+	w, m := s.w, s.m
+
+	logger.Infof("client [%d]: search sync mode=%d cookie='%s' reloadhint=%t", m.Client.Numero(), req.mode, req.cookie, req.reloadHint)
+
+	// subscribe before refresh, so no change is lost in between
+	var changes chan changeSet
+	var generation int
+	var missed []changeSet
+	incremental := false
+	if generationSince, ok := notifier.parseCookie(req.cookie); ok {
+		changes, generation, missed, incremental = notifier.subscribeSince(generationSince)
+	} else {
+		changes, generation = notifier.subscribe()
+	}
+	defer notifier.unsubscribe(changes)
+
+	// changes since cookie are lost -> consumer must reload content
+	if len(req.cookie) > 0 && !incremental && !req.reloadHint {
+		res := ldapserver.NewSearchResultDoneResponse(ldapResultSyncRefreshRequired)
+		res.SetDiagnosticMessage("sync cookie is unknown or expired, full refresh required")
+		w.Write(res)
+
+		logger.Errorf("client [%d]: search error: sync cookie '%s' is unknown or expired", m.Client.Numero(), req.cookie)
+		return
+	}
+
+	// refresh stage
+	var refresh []syncEntry
+	if incremental {
+		// changes since cookie, followed by refreshDeletes
+		var err error
+		refresh, err = s.collapseChanges(missed)
+		if err != nil {
+			res := ldapserver.NewSearchResultDoneResponse(ldapserver.LDAPResultUnwillingToPerform)
+			res.SetDiagnosticMessage(err.Error())
+			w.Write(res)
+
+			logger.Errorf("client [%d]: search error: %s", m.Client.Numero(), err)
+			return
+		}
+	} else {
+		// full content, entries not sent are deleted by consumer
+		found, err := findSearchEntries(m, snapshot.Load(), s.baseObject, baseDN, usersOUName, groupsOUName, s.scope, s.filter, time.Time{})
+		if err == errSearchAbandoned {
+			logger.Infof("client [%d]: leaving handleSearch...", m.Client.Numero())
+			return
+		}
+		if err != nil {
+			res := ldapserver.NewSearchResultDoneResponse(ldapserver.LDAPResultUnwillingToPerform)
+			res.SetDiagnosticMessage(err.Error())
+			w.Write(res)
+
+			logger.Errorf("client [%d]: search error: %s", m.Client.Numero(), err)
+			return
+		}
+		for _, e := range found {
+			refresh = append(refresh, syncEntry{state: syncStateAdd, entry: e})
+		}
+	}
+
+	for _, e := range refresh {
+		// handle stop signal
+		select {
+		case <-m.Done:
+			logger.Infof("client [%d]: leaving handleSearch...", m.Client.Numero())
+			return
+		default:
+		}
+
+		s.writeEntry(e, "")
+	}
+
+	cookie := notifier.cookie(generation)
+	logger.Infof("client [%d]: search sync refresh incremental=%t nentries=%d cookie='%s'", m.Client.Numero(), incremental, len(refresh), cookie)
+
+	if req.mode == syncModeRefreshOnly {
+		res := ldapserver.NewSearchResultDoneResponse(ldapserver.LDAPResultSuccess)
+		responseMessage := ldap.NewLDAPMessageWithProtocolOp(res)
+		ldap.SetMessageControls(responseMessage, ldap.Controls{newSyncDoneControl(cookie, incremental)})
+		w.WriteMessage(responseMessage)
+
+		logger.Infof("client [%d]: search result=OK", m.Client.Numero())
+		return
+	}
+
+	// refresh done -> persist stage
+	choice := syncInfoRefreshPresent
+	if incremental {
+		choice = syncInfoRefreshDelete
+	}
+	if err := s.writeSyncInfo(ber.NewConstructed(ber.ClassContext, choice, ber.NewOctetString(cookie))); err != nil {
+		logger.Errorf("client [%d]: search error: %s", m.Client.Numero(), err)
+		return
+	}
+
+	for {
+		select {
+		case <-m.Done:
+			logger.Infof("client [%d]: leaving handleSearch...", m.Client.Numero())
+			return
+		case set, ok := <-changes:
+			// subscriber dropped by notifier
+			if !ok {
+				res := ldapserver.NewSearchResultDoneResponse(ldapResultSyncRefreshRequired)
+				res.SetDiagnosticMessage("too many pending changes, full refresh required")
+				w.Write(res)
+
+				logger.Errorf("client [%d]: search error: sync dropped, too many pending changes", m.Client.Numero())
+				return
+			}
+
+			// already sent within refresh stage
+			if set.generation <= generation {
+				continue
+			}
+			generation = set.generation
+			cookie = notifier.cookie(generation)
+
+			var sent int
+			for _, change := range set.changes {
+				e, err := s.getSyncEntry(change)
+				if err != nil {
+					res := ldapserver.NewSearchResultDoneResponse(ldapserver.LDAPResultUnwillingToPerform)
+					res.SetDiagnosticMessage(err.Error())
+					w.Write(res)
+
+					logger.Errorf("client [%d]: search error: %s", m.Client.Numero(), err)
+					return
+				}
+				if e.state == syncStateNone {
+					continue
+				}
+
+				s.writeEntry(e, cookie)
+				sent++
+
+				logger.Infof("client [%d]: search sync state=%d dn='%s'", m.Client.Numero(), e.state, e.entry.name)
+			}
+
+			// nothing sent -> advance consumer cookie
+			if sent == 0 {
+				if err := s.writeSyncInfo(ber.NewPrimitive(ber.ClassContext, syncInfoNewCookie, []byte(cookie))); err != nil {
+					logger.Errorf("client [%d]: search error: %s", m.Client.Numero(), err)
+					return
+				}
+			}
+		}
+	}
+}
+
+// matches returns true if entry 'name' with object 'o' is within search scope and matches search filter
+func (s syncSearch) matches(name string, o interface{}) (bool, error) {
+	if !isInScope(ldaputils.NormalizeEntry(name), s.baseObject, s.scope) {
+		return false, nil
+	}
+	return applySearchFilter(o, s.filter)
+}
+
+// getSyncEntry returns entry with its sync state for change 'change',
+// entries which are moved out of search are deleted
+func (s syncSearch) getSyncEntry(change entryChange) (syncEntry, error) {
+	none := syncEntry{state: syncStateNone}
+
+	if change.changeType == changeTypeDelete {
+		ok, err := s.matches(change.entry.name, change.entry.o)
+		if err != nil || !ok {
+			return none, err
+		}
+		return syncEntry{state: syncStateDelete, entry: change.entry}, nil
+	}
+
+	ok, err := s.matches(change.entry.name, change.entry.o)
+	if err != nil {
+		return none, err
+	}
+
+	var wasOk bool
+	if change.changeType != changeTypeAdd {
+		previousName := change.entry.name
+		if change.changeType == changeTypeModDN {
+			previousName = change.previousName
+		}
+		if wasOk, err = s.matches(previousName, change.previous); err != nil {
+			return none, err
+		}
+		if wasOk && !ok {
+			return syncEntry{state: syncStateDelete, entry: searchEntry{name: previousName, o: change.previous}}, nil
+		}
+	}
+
+	switch {
+	case !ok:
+		return none, nil
+	case wasOk:
+		return syncEntry{state: syncStateModify, entry: change.entry}, nil
+	}
+	return syncEntry{state: syncStateAdd, entry: change.entry}, nil
+}
+
+// collapseChanges returns latest sync state of every entry changed within change sets 'sets'
+func (s syncSearch) collapseChanges(sets []changeSet) ([]syncEntry, error) {
+	var order []string
+	states := make(map[string]syncEntry)
+	for _, set := range sets {
+		for _, change := range set.changes {
+			e, err := s.getSyncEntry(change)
+			if err != nil {
+				return nil, err
+			}
+			if e.state == syncStateNone {
+				continue
+			}
+
+			// entries are sent with add state during refresh
+			if e.state == syncStateModify {
+				e.state = syncStateAdd
+			}
+
+			id := string(getSyncUUID(e.entry))
+			if _, ok := states[id]; !ok {
+				order = append(order, id)
+			}
+			states[id] = e
+		}
+	}
+
+	var collapsed []syncEntry
+	for _, id := range order {
+		collapsed = append(collapsed, states[id])
+	}
+
+	return collapsed, nil
+}
+
+// writeEntry writes entry 'e' with sync state control
+// deleted entries are written without attributes
+func (s syncSearch) writeEntry(e syncEntry, cookie string) {
+	var res ldap.SearchResultEntry
+	if e.state == syncStateDelete {
+		res.SetObjectName(e.entry.name)
+	} else {
+		res = createSearchEntry(e.entry.o, s.attrs, e.entry.name, s.typesOnly, s.valuesFilter)
+	}
+
+	responseMessage := ldap.NewLDAPMessageWithProtocolOp(res)
+	ldap.SetMessageControls(responseMessage, ldap.Controls{newSyncStateControl(e.state, getSyncUUID(e.entry), cookie)})
+	s.w.WriteMessage(responseMessage)
+}
+
+// writeSyncInfo writes sync info intermediate response with value 'p'
+//
+//	syncInfoValue ::= CHOICE {
+//	     newcookie      [0] syncCookie,
+//	     refreshDelete  [1] SEQUENCE {
+//	          cookie         syncCookie OPTIONAL,
+//	          refreshDone    BOOLEAN DEFAULT TRUE },
+//	     refreshPresent [2] SEQUENCE {
+//	          cookie         syncCookie OPTIONAL,
+//	          refreshDone    BOOLEAN DEFAULT TRUE },
+//	     syncIdSet      [3] SEQUENCE {
+//	          cookie         syncCookie OPTIONAL,
+//	          refreshDeletes BOOLEAN DEFAULT FALSE,
+//	          syncUUIDs      SET OF syncUUID } }
+func (s syncSearch) writeSyncInfo(p *ber.Packet) error {
+	// goldap has no IntermediateResponse constructor -> decode it from BER
+	//	IntermediateResponse ::= [APPLICATION 25] SEQUENCE {
+	//	     responseName     [0] LDAPOID OPTIONAL,
+	//	     responseValue    [1] OCTET STRING OPTIONAL }
+	msg := ber.NewSequence(
+		ber.NewInteger(0),
+		ber.NewConstructed(ber.ClassApplication, 25,
+			ber.NewPrimitive(ber.ClassContext, 0, []byte(syncInfoMessageOID)),
+			ber.NewPrimitive(ber.ClassContext, 1, p.Bytes()),
+		),
+	)
+
+	decoded, err := ldap.ReadLDAPMessage(ldap.NewBytes(0, msg.Bytes()))
+	if err != nil {
+		return fmt.Errorf("error encoding sync info message: %s", err)
+	}
+
+	s.w.Write(decoded.ProtocolOp())
+
+	return nil
+}
+
+// getSyncUUID returns entryUUID of entry 'e' as syncUUID,
+// uuid is generated from entry dn if entry has no valid entryUUID
+func getSyncUUID(e searchEntry) []byte {
+	id, err := uuid.Parse(getEntryUUID(e.o))
+	if err != nil {
+		id = uuid.MustParse(newEntryUUID(ldaputils.NormalizeEntry(e.name)))
+	}
+	return id[:]
+}
@@ -0,0 +1,228 @@
+package ldap
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ps78674/gorestldap/internal/data"
+	"github.com/ps78674/gorestldap/internal/ldaputils"
+)
+
+// format of createTimestamp and modifyTimestamp values (RFC 4517)
+const generalizedTimeFormat = "20060102150405Z"
+
+// format of entryCSN time, entryCSN is <time>#<change count>#<server id>#<modification number>
+const csnTimeFormat = "20060102150405.000000Z"
+
+// accepted generalized time values, fraction of seconds is parsed by time.Parse even if layout has none
+var generalizedTimeLayouts = []string{
+	"20060102150405Z0700",
+	"20060102150405Z07",
+	"200601021504Z0700",
+	"200601021504Z07",
+	"2006010215Z0700",
+	"2006010215Z07",
+}
+
+// time bind dn of client which modified entry is kept, modifies which are not seen in backend data by then are forgotten
+const entryModifierTTL = time.Hour
+
+// entryModifier is bind dn of client which modified entry and time of modify
+type entryModifier struct {
+	bindEntry string
+	time      time.Time
+}
+
+// bind dns of clients which modified entries, kept until modify is seen in backend data or expired
+var entryModifiers = struct {
+	sync.Mutex
+	m map[string]entryModifier
+}{m: make(map[string]entryModifier)}
+
+// setEntryModifier saves bind dn 'bindEntry' of client which modified entry 'entryName', expired bind dns are removed
+func setEntryModifier(entryName, bindEntry string) {
+	entryModifiers.Lock()
+	defer entryModifiers.Unlock()
+	now := time.Now()
+	for name, m := range entryModifiers.m {
+		if now.Sub(m.time) > entryModifierTTL {
+			delete(entryModifiers.m, name)
+		}
+	}
+	entryModifiers.m[ldaputils.NormalizeEntry(entryName)] = entryModifier{bindEntry: bindEntry, time: now}
+}
+
+// popEntryModifier returns and forgets bind dn of client which modified entry 'entryName', empty if it is expired
+func popEntryModifier(entryName string) string {
+	entryModifiers.Lock()
+	defer entryModifiers.Unlock()
+	name := ldaputils.NormalizeEntry(entryName)
+	m, ok := entryModifiers.m[name]
+	delete(entryModifiers.m, name)
+	if !ok || time.Since(m.time) > entryModifierTTL {
+		return ""
+	}
+	return m.bindEntry
+}
+
+// getEntryModifier returns bind dn of client which modified entry 'entryName' or base dn 'baseDN' if entry is changed by backend
+func getEntryModifier(entryName, baseDN string) string {
+	if modifier := popEntryModifier(entryName); len(modifier) > 0 {
+		return modifier
+	}
+	return baseDN
+}
+
+// SetTimestamps sets createTimestamp, modifyTimestamp, creatorsName, modifiersName and entryCSN of entries 'new' which are not set by backend
+// values are taken from previous snapshot 'old' or timestamps 'saved' before restart for unchanged entries, added and modified entries get time 'now'
+// creatorsName and modifiersName are bind dn of client which modified entries or base dn if they are changed by backend
+func SetTimestamps(old *data.Entries, saved map[string]data.SavedTimestamps, new *data.Entries, baseDN, usersOUName, groupsOUName string, now time.Time) {
+	oldByName := make(map[string]interface{})
+	oldByUUID := make(map[string]interface{})
+	if old != nil {
+		for _, e := range listEntries(old, baseDN, usersOUName, groupsOUName) {
+			oldByName[ldaputils.NormalizeEntry(e.name)] = e.o
+			if uuid := getEntryUUID(e.o); len(uuid) > 0 {
+				oldByUUID[uuid] = e.o
+			}
+		}
+	}
+
+	timestamp := now.UTC().Format(generalizedTimeFormat)
+	var changeCount int
+	newCSN := func() string {
+		csn := fmt.Sprintf("%s#%06x#000#000000", now.UTC().Format(csnTimeFormat), changeCount)
+		changeCount++
+		return csn
+	}
+
+	setEntry := func(v reflect.Value) {
+		backendTimestamps := getTimestamps(v)
+		if f := v.FieldByName("BackendTimestamps"); f.IsValid() {
+			f.Set(reflect.ValueOf(backendTimestamps))
+		}
+
+		name := getEntryName(v.Interface(), baseDN, usersOUName, groupsOUName)
+		prev, found := oldByName[ldaputils.NormalizeEntry(name)]
+		if !found {
+			if uuid := getEntryUUID(v.Interface()); len(uuid) > 0 {
+				prev, found = oldByUUID[uuid]
+			}
+		}
+
+		var derived data.Timestamps
+		var unchanged bool
+		if found {
+			derived = getTimestamps(reflect.ValueOf(prev))
+			unchanged = equalEntries(withoutTimestamps(prev), withoutTimestamps(v.Interface()))
+		} else if s, ok := saved[ldaputils.NormalizeEntry(name)]; ok {
+			derived, found = s.Timestamps, true
+			unchanged = s.Hash == hashEntry(v.Interface())
+		}
+
+		switch {
+		case !found:
+			creator := getEntryModifier(name, baseDN)
+			derived = data.Timestamps{
+				CreateTimestamp: timestamp,
+				ModifyTimestamp: timestamp,
+				CreatorsName:    creator,
+				ModifiersName:   creator,
+				EntryCSN:        newCSN(),
+			}
+		case unchanged:
+			// timestamps are kept
+		default:
+			derived.ModifyTimestamp = timestamp
+			derived.ModifiersName = getEntryModifier(name, baseDN)
+			derived.EntryCSN = newCSN()
+		}
+
+		// backend values take precedence
+		for _, f := range timestampFields {
+			field := v.FieldByName(f)
+			if len(field.String()) == 0 {
+				field.SetString(reflect.ValueOf(derived).FieldByName(f).String())
+			}
+		}
+	}
+
+	entries := reflect.ValueOf(new).Elem()
+	setEntry(entries.FieldByName("Domain"))
+	for i := 0; i < entries.NumField(); i++ {
+		list := entries.Field(i)
+		if list.Kind() != reflect.Slice {
+			continue
+		}
+		for j := 0; j < list.Len(); j++ {
+			setEntry(list.Index(j))
+		}
+	}
+}
+
+// GetSavedTimestamps returns timestamps of entries 'entries' keyed by normalized entry name, they are saved to cache
+// and passed to SetTimestamps after restart
+func GetSavedTimestamps(entries *data.Entries, baseDN, usersOUName, groupsOUName string) map[string]data.SavedTimestamps {
+	list := listEntries(entries, baseDN, usersOUName, groupsOUName)
+	saved := make(map[string]data.SavedTimestamps, len(list))
+	for _, e := range list {
+		saved[ldaputils.NormalizeEntry(e.name)] = data.SavedTimestamps{
+			Hash:       hashEntry(e.o),
+			Timestamps: getTimestamps(reflect.ValueOf(e.o)),
+		}
+	}
+	return saved
+}
+
+// hashEntry returns hash of entry 'o' values without timestamps
+func hashEntry(o interface{}) string {
+	b, err := json.Marshal(withoutTimestamps(o))
+	if err != nil {
+		return ""
+	}
+	sum := sha256.Sum256(b)
+	return hex.EncodeToString(sum[:])
+}
+
+// names of data.Timestamps fields, entries have fields with the same names
+var timestampFields = []string{"CreateTimestamp", "ModifyTimestamp", "CreatorsName", "ModifiersName", "EntryCSN"}
+
+// getTimestamps returns timestamps of entry 'v'
+func getTimestamps(v reflect.Value) (t data.Timestamps) {
+	tv := reflect.ValueOf(&t).Elem()
+	for _, f := range timestampFields {
+		if field := v.FieldByName(f); field.IsValid() {
+			tv.FieldByName(f).SetString(field.String())
+		}
+	}
+	return
+}
+
+// withoutTimestamps returns copy of entry 'o' with empty timestamps
+func withoutTimestamps(o interface{}) interface{} {
+	v := reflect.New(reflect.TypeOf(o)).Elem()
+	v.Set(reflect.ValueOf(o))
+	for _, f := range timestampFields {
+		if field := v.FieldByName(f); field.IsValid() {
+			field.SetString("")
+		}
+	}
+	return v.Interface()
+}
+
+// parseGeneralizedTime returns time of generalized time value 's' (RFC 4517)
+func parseGeneralizedTime(s string) (time.Time, error) {
+	s = strings.Replace(s, ",", ".", 1)
+	for _, layout := range generalizedTimeLayouts {
+		if t, err := time.Parse(layout, s); err == nil {
+			return t, nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("wrong generalized time '%s'", s)
+}
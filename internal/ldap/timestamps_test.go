@@ -0,0 +1,91 @@
+package ldap
+
+import (
+	"testing"
+	"time"
+
+	"github.com/ps78674/gorestldap/internal/data"
+)
+
+const testBaseDN = "dc=example,dc=com"
+
+func newTimestampsTestEntries(users ...data.User) *data.Entries {
+	entries := GetEntries(testBaseDN)
+	entries.Users = users
+	return entries
+}
+
+func TestSetTimestampsSaved(t *testing.T) {
+	start := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+	entries := newTimestampsTestEntries(data.User{CN: "user01", UID: "user01"}, data.User{CN: "user02", UID: "user02"})
+	SetTimestamps(nil, nil, entries, testBaseDN, "users", "groups", start)
+	saved := GetSavedTimestamps(entries, testBaseDN, "users", "groups")
+
+	// restart: user01 is unchanged, user02 is changed, user03 is added
+	restart := start.Add(time.Hour)
+	entries = newTimestampsTestEntries(data.User{CN: "user01", UID: "user01"}, data.User{CN: "user02", UID: "user02", Mail: "user02@example.com"}, data.User{CN: "user03", UID: "user03"})
+	SetTimestamps(nil, saved, entries, testBaseDN, "users", "groups", restart)
+
+	startTime, restartTime := start.Format(generalizedTimeFormat), restart.Format(generalizedTimeFormat)
+	tests := []struct {
+		user       data.User
+		wantCreate string
+		wantModify string
+	}{
+		{entries.Users[0], startTime, startTime},
+		{entries.Users[1], startTime, restartTime},
+		{entries.Users[2], restartTime, restartTime},
+	}
+	for _, tt := range tests {
+		if tt.user.CreateTimestamp != tt.wantCreate || tt.user.ModifyTimestamp != tt.wantModify {
+			t.Errorf("%s: createTimestamp = %s, modifyTimestamp = %s, want %s, %s", tt.user.CN, tt.user.CreateTimestamp, tt.user.ModifyTimestamp, tt.wantCreate, tt.wantModify)
+		}
+		if tt.user.CreatorsName != testBaseDN {
+			t.Errorf("%s: creatorsName = %s, want %s", tt.user.CN, tt.user.CreatorsName, testBaseDN)
+		}
+	}
+}
+
+func TestPopEntryModifier(t *testing.T) {
+	setEntryModifier("cn=user01,ou=users,dc=example,dc=com", "cn=admin,ou=users,dc=example,dc=com")
+	if got := popEntryModifier("CN=user01, ou=users,dc=example,dc=com"); got != "cn=admin,ou=users,dc=example,dc=com" {
+		t.Errorf("popEntryModifier = %q, want admin dn", got)
+	}
+	if got := popEntryModifier("cn=user01,ou=users,dc=example,dc=com"); got != "" {
+		t.Errorf("popEntryModifier after pop = %q, want empty", got)
+	}
+
+	// expired modifiers are forgotten
+	entryModifiers.Lock()
+	entryModifiers.m["cn=user02,ou=users,dc=example,dc=com"] = entryModifier{bindEntry: "cn=admin", time: time.Now().Add(-2 * entryModifierTTL)}
+	entryModifiers.Unlock()
+	setEntryModifier("cn=user03,ou=users,dc=example,dc=com", "cn=admin")
+	entryModifiers.Lock()
+	_, found := entryModifiers.m["cn=user02,ou=users,dc=example,dc=com"]
+	entryModifiers.Unlock()
+	if found {
+		t.Error("expired modifier is not removed")
+	}
+	popEntryModifier("cn=user03,ou=users,dc=example,dc=com")
+}
+
+func TestSetTimestampsModifiersName(t *testing.T) {
+	start := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+	old := newTimestampsTestEntries(data.User{CN: "user01", UID: "user01"}, data.User{CN: "user02", UID: "user02"})
+	SetTimestamps(nil, nil, old, testBaseDN, "users", "groups", start)
+
+	// user01 is modified over LDAP, user02 is modified in backend
+	const adminDN = "cn=admin,ou=users," + testBaseDN
+	setEntryModifier("cn=user01,ou=users,"+testBaseDN, adminDN)
+	entries := newTimestampsTestEntries(data.User{CN: "user01", UID: "user01", Mail: "user01@example.com"}, data.User{CN: "user02", UID: "user02", Mail: "user02@example.com"})
+	SetTimestamps(old, nil, entries, testBaseDN, "users", "groups", start.Add(time.Hour))
+
+	for i, want := range []string{adminDN, testBaseDN} {
+		if got := entries.Users[i].ModifiersName; got != want {
+			t.Errorf("%s: modifiersName = %q, want %q", entries.Users[i].CN, got, want)
+		}
+		if got := entries.Users[i].CreatorsName; got != testBaseDN {
+			t.Errorf("%s: creatorsName = %q, want %q", entries.Users[i].CN, got, testBaseDN)
+		}
+	}
+}
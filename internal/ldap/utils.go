@@ -3,40 +3,51 @@ package ldap
 import (
 	"reflect"
 	"strings"
+
+	ldap "github.com/ps78674/goldap/message"
+	"github.com/ps78674/gorestldap/internal/config"
+	"github.com/ps78674/gorestldap/internal/ldaputils"
+	ldapserver "github.com/ps78674/ldapserver"
 )
 
-// isCorrectDn checks dn syntax
-func isCorrectDn(s string) bool {
-	var allowedAttrs = []string{"cn", "uid", "ou", "dc"}
+// LDAP_MATCHING_RULE_IN_CHAIN, matches values of dn valued attributes transitively
+const matchingRuleInChainOID = "1.2.840.113556.1.4.1941"
 
-	for _, sub := range strings.Split(s, ",") {
-		var found bool
+// isCorrectDn checks dn syntax and attribute types of its rdns
+func isCorrectDn(s string) bool {
+	var allowedAttrs = []string{"cn", "uid", "ou", "dc", "automountmapname", "automountkey"}
 
-		attrName, _, found := strings.Cut(sub, "=")
-		if !found {
-			return false
-		}
-		for _, attr := range allowedAttrs {
-			if attrName == attr {
-				found = true
-				break
+	dn, err := ldaputils.ParseDN(s)
+	if err != nil {
+		return false
+	}
+	for _, rdn := range dn.Normalize() {
+		for _, atv := range rdn {
+			var found bool
+			for _, attr := range allowedAttrs {
+				if atv.Type == attr {
+					found = true
+					break
+				}
+			}
+			if !found {
+				return false
 			}
-		}
-		if !found {
-			return false
 		}
 	}
 
 	return true
 }
 
-// getEntryAttrValueSuffix returns entry attribute, its value and suffix
+// getEntryAttrValueSuffix returns entry attribute, its unescaped value and suffix
 // e.g. for entry cn=admin,ou=users,dc=example,dc=com it would return ['cn', 'admin', 'ou=users,dc=example,dc=com']
+// first attribute is returned for multi-valued rdn
 func getEntryAttrValueSuffix(entry string) (attr, value, suffix string) {
-	var attrValue string
-	attrValue, suffix, _ = strings.Cut(entry, ",")
-	attr, value, _ = strings.Cut(attrValue, "=")
-	return
+	dn, err := ldaputils.ParseDN(entry)
+	if err != nil || len(dn) == 0 {
+		return
+	}
+	return dn[0][0].Type, dn[0][0].Value, dn.Parent().String()
 }
 
 // tagValueContains returns true if StructTag's 'tag' key 'tagName' contains value 'tagValue'
@@ -55,3 +66,73 @@ func tagValueContains(tag reflect.StructTag, tagName, tagValue string) bool {
 	}
 	return found
 }
+
+// getAttrValues returns string values of object's 'o' attribute 'attrName' and the attribute
+func getAttrValues(o interface{}, attrName string) ([]string, attribute, bool) {
+	attr, found := getAttribute(o, attrName)
+	return attr.values, attr, found
+}
+
+// isInScope returns true if entry 'entryName' is within search scope 'scope' of base object 'baseObject'
+func isInScope(entryName, baseObject string, scope int) bool {
+	switch scope {
+	case ldap.SearchRequestScopeBaseObject:
+		return entryName == baseObject
+	case ldap.SearchRequestScopeOneLevel:
+		return ldaputils.ParentEntry(entryName) == baseObject
+	case ldap.SearchRequestScopeSubtree:
+		return ldaputils.IsSubordinate(entryName, baseObject, true)
+	case ldap.SearchRequestScopeChildren:
+		return ldaputils.IsSubordinate(entryName, baseObject, false)
+	}
+	return false
+}
+
+// getClientACL returns ACLs of client, clients bound within other naming context than 'baseDN' are anonymous
+func getClientACL(m *ldapserver.Message, baseDN string) clientACL {
+	addData := m.Client.GetAddData()
+	if addData == nil {
+		return clientACL{}
+	}
+	acl := addData.(additionalData).acl
+	if !ldaputils.IsSubordinate(acl.bindEntry, baseDN, true) {
+		return clientACL{}
+	}
+	return acl
+}
+
+// getLimits returns administrative limits for client with ACLs 'acl', limits for bind dn take precedence over role limits
+func getLimits(limits map[string]config.Limits, acl clientACL) config.Limits {
+	if len(acl.bindEntry) > 0 {
+		if l, ok := limits[acl.bindEntry]; ok {
+			return l
+		}
+	}
+	return limits[acl.role()]
+}
+
+// getAttributeType returns attribute type of attribute description 'desc', e.g. 'cn' for 'cn;binary' or 'cn;lang-en',
+// options are ignored since values are stored without them
+func getAttributeType(desc string) string {
+	attrType, _, _ := strings.Cut(desc, ";")
+	return attrType
+}
+
+// readExtensibleMatch returns matching rule, attribute description and match value of filter 'f'
+func readExtensibleMatch(f ldap.FilterExtensibleMatch) (rule, attrDesc, value string) {
+	// goldap has no getters for matching rule assertion fields -> read them by reflection
+	//	MatchingRuleAssertion ::= SEQUENCE {
+	//	     matchingRule    [1] MatchingRuleId OPTIONAL,
+	//	     type            [2] AttributeDescription OPTIONAL,
+	//	     matchValue      [3] AssertionValue,
+	//	     dnAttributes    [4] BOOLEAN DEFAULT FALSE }
+	v := reflect.ValueOf(f)
+	if p := v.FieldByName("matchingRule"); !p.IsNil() {
+		rule = p.Elem().String()
+	}
+	if p := v.FieldByName("type_"); !p.IsNil() {
+		attrDesc = p.Elem().String()
+	}
+	value = v.FieldByName("matchValue").String()
+	return
+}
@@ -0,0 +1,279 @@
+package ldaputils
+
+import (
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// AttributeTypeAndValue is an attribute type and its value within relative distinguished name
+type AttributeTypeAndValue struct {
+	Type  string
+	Value string
+}
+
+// RDN is a relative distinguished name, multi-valued rdns have several attribute types and values
+type RDN []AttributeTypeAndValue
+
+// DN is a distinguished name (RFC 4514), leftmost rdn goes first
+type DN []RDN
+
+// short names of attribute types which may be set with their OIDs
+var attributeTypeNames = map[string]string{
+	"2.5.4.3":                    "cn",
+	"2.5.4.11":                   "ou",
+	"0.9.2342.19200300.100.1.1":  "uid",
+	"0.9.2342.19200300.100.1.25": "dc",
+}
+
+// ParseDN returns dn from its string representation 's' (RFC 4514)
+// spaces around attribute types, values and separators are ignored unless escaped, e.g. CN = Smith\, John , DC=example
+func ParseDN(s string) (DN, error) {
+	var dn DN
+	if len(strings.TrimSpace(s)) == 0 {
+		return dn, nil
+	}
+
+	var rdn RDN
+	for i := 0; ; {
+		// attribute type
+		j := strings.IndexByte(s[i:], '=')
+		if j < 0 {
+			return nil, fmt.Errorf("wrong dn '%s': attribute type without value", s)
+		}
+		attrType := strings.TrimSpace(s[i : i+j])
+		if !isAttributeType(attrType) {
+			return nil, fmt.Errorf("wrong dn '%s': wrong attribute type '%s'", s, attrType)
+		}
+		i += j + 1
+
+		// attribute value up to unescaped separator
+		value, n, err := parseValue(s[i:])
+		if err != nil {
+			return nil, fmt.Errorf("wrong dn '%s': %s", s, err)
+		}
+		i += n
+		rdn = append(rdn, AttributeTypeAndValue{Type: attrType, Value: value})
+
+		if i == len(s) {
+			dn = append(dn, rdn)
+			return dn, nil
+		}
+		if s[i] == ',' || s[i] == ';' {
+			dn = append(dn, rdn)
+			rdn = nil
+		}
+		i++
+	}
+}
+
+// parseValue returns unescaped attribute value at the beginning of 's' and length of its string representation
+func parseValue(s string) (string, int, error) {
+	// skip leading spaces
+	i := 0
+	for i < len(s) && s[i] == ' ' {
+		i++
+	}
+
+	// hex encoded BER value, e.g. #04024869
+	if i < len(s) && s[i] == '#' {
+		j := i + 1
+		for j < len(s) && strings.IndexByte("0123456789abcdefABCDEF", s[j]) >= 0 {
+			j++
+		}
+		b, err := hex.DecodeString(s[i+1 : j])
+		if err != nil || len(b) == 0 {
+			return "", 0, fmt.Errorf("wrong hex value '%s'", s[i:j])
+		}
+		// primitive value with short length -> its contents
+		if len(b) >= 2 && b[1] < 0x80 && int(b[1]) == len(b)-2 {
+			b = b[2:]
+		}
+		for j < len(s) && s[j] == ' ' {
+			j++
+		}
+		if j < len(s) && strings.IndexByte(",;+", s[j]) < 0 {
+			return "", 0, fmt.Errorf("wrong hex value '%s'", s[i:])
+		}
+		return string(b), j, nil
+	}
+
+	var value []byte
+	// length of value without trailing unescaped spaces
+	var significant int
+	for ; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case c == ',' || c == ';' || c == '+':
+			return string(value[:significant]), i, nil
+		case c == '\\':
+			if i+1 >= len(s) {
+				return "", 0, errors.New("escape sequence without character")
+			}
+			if b, err := hex.DecodeString(s[i+1 : min(i+3, len(s))]); err == nil && len(b) == 1 {
+				value = append(value, b[0])
+				i += 2
+			} else if strings.IndexByte(" \"#+,;<=>\\", s[i+1]) >= 0 {
+				value = append(value, s[i+1])
+				i++
+			} else {
+				return "", 0, fmt.Errorf("wrong escape sequence '%s'", s[i:min(i+3, len(s))])
+			}
+			significant = len(value)
+		case c == '"' || c == '<' || c == '>' || c == 0:
+			return "", 0, fmt.Errorf("character '%c' must be escaped", c)
+		default:
+			value = append(value, c)
+			if c != ' ' {
+				significant = len(value)
+			}
+		}
+	}
+
+	return string(value[:significant]), i, nil
+}
+
+// isAttributeType returns true if 's' is a descriptor or numeric OID
+func isAttributeType(s string) bool {
+	if len(s) == 0 {
+		return false
+	}
+	if s[0] >= '0' && s[0] <= '9' {
+		for _, part := range strings.Split(s, ".") {
+			if len(part) == 0 || strings.Trim(part, "0123456789") != "" {
+				return false
+			}
+		}
+		return true
+	}
+	for i, c := range s {
+		isAlpha := (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z')
+		if !isAlpha && (i == 0 || ((c < '0' || c > '9') && c != '-')) {
+			return false
+		}
+	}
+	return true
+}
+
+// min returns the smaller of 'a' and 'b'
+func min(a, b int) int {
+	if a < b {
+		return a
+	}
+	return b
+}
+
+// EscapeValue returns attribute value 's' escaped for dn string representation (RFC 4514)
+func EscapeValue(s string) string {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case c == 0:
+			b.WriteString(`\00`)
+			continue
+		case strings.IndexByte("\"+,;<>\\", c) >= 0,
+			i == 0 && (c == ' ' || c == '#'),
+			i == len(s)-1 && c == ' ':
+			b.WriteByte('\\')
+		}
+		b.WriteByte(c)
+	}
+	return b.String()
+}
+
+// String returns string representation of rdn
+func (rdn RDN) String() string {
+	parts := make([]string, 0, len(rdn))
+	for _, atv := range rdn {
+		parts = append(parts, atv.Type+"="+EscapeValue(atv.Value))
+	}
+	return strings.Join(parts, "+")
+}
+
+// String returns string representation of dn (RFC 4514)
+func (dn DN) String() string {
+	parts := make([]string, 0, len(dn))
+	for _, rdn := range dn {
+		parts = append(parts, rdn.String())
+	}
+	return strings.Join(parts, ",")
+}
+
+// Normalize returns dn with lowercase attribute types and values, attribute types set by known OIDs are replaced with their names
+// attribute types and values of multi-valued rdns are sorted
+func (dn DN) Normalize() DN {
+	normalized := make(DN, 0, len(dn))
+	for _, rdn := range dn {
+		nrdn := make(RDN, 0, len(rdn))
+		for _, atv := range rdn {
+			attrType := strings.ToLower(atv.Type)
+			if name, ok := attributeTypeNames[attrType]; ok {
+				attrType = name
+			}
+			nrdn = append(nrdn, AttributeTypeAndValue{Type: attrType, Value: strings.ToLower(atv.Value)})
+		}
+		sort.Slice(nrdn, func(i, j int) bool {
+			if nrdn[i].Type != nrdn[j].Type {
+				return nrdn[i].Type < nrdn[j].Type
+			}
+			return nrdn[i].Value < nrdn[j].Value
+		})
+		normalized = append(normalized, nrdn)
+	}
+	return normalized
+}
+
+// Parent returns dn of parent entry, empty dn is returned for top level entries
+func (dn DN) Parent() DN {
+	if len(dn) == 0 {
+		return nil
+	}
+	return dn[1:]
+}
+
+// HasSuffix returns true if dn is 'suffix' or is below it, dns are compared normalized
+func (dn DN) HasSuffix(suffix DN) bool {
+	if len(suffix) > len(dn) {
+		return false
+	}
+	tail := DN(dn[len(dn)-len(suffix):]).Normalize().String()
+	return tail == suffix.Normalize().String()
+}
+
+// NormalizeEntry returns normalized string representation of dn 's', it is returned lowercased if it is not a valid dn
+// e.g. DC=test, dc=EXAMPLE,  dc=com -> dc=test,dc=example,dc=com
+func NormalizeEntry(s string) string {
+	dn, err := ParseDN(s)
+	if err != nil {
+		return strings.ToLower(s)
+	}
+	return dn.Normalize().String()
+}
+
+// IsSubordinate returns true if entry 'entry' is below entry 'base', 'orSelf' also accepts entry itself
+func IsSubordinate(entry, base string, orSelf bool) bool {
+	entryDN, err := ParseDN(entry)
+	if err != nil {
+		return false
+	}
+	baseDN, err := ParseDN(base)
+	if err != nil {
+		return false
+	}
+	if len(entryDN) == len(baseDN) && !orSelf {
+		return false
+	}
+	return entryDN.HasSuffix(baseDN)
+}
+
+// ParentEntry returns normalized dn of parent of entry 'entry'
+func ParentEntry(entry string) string {
+	dn, err := ParseDN(entry)
+	if err != nil {
+		return ""
+	}
+	return dn.Parent().Normalize().String()
+}
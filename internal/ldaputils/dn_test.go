@@ -0,0 +1,148 @@
+package ldaputils
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseDN(t *testing.T) {
+	tests := []struct {
+		s    string
+		want DN
+	}{
+		{"", nil},
+		{"  ", nil},
+		{"dc=example,dc=com", DN{{{"dc", "example"}}, {{"dc", "com"}}}},
+		{"CN = Smith\\, John , DC=example", DN{{{"CN", "Smith, John"}}, {{"DC", "example"}}}},
+		{"cn=a;dc=com", DN{{{"cn", "a"}}, {{"dc", "com"}}}},
+		{"cn=a+uid=b,dc=com", DN{{{"cn", "a"}, {"uid", "b"}}, {{"dc", "com"}}}},
+		{"cn=", DN{{{"cn", ""}}}},
+		{`cn=\4A\4f`, DN{{{"cn", "JO"}}}},
+		{`cn=\ a\ `, DN{{{"cn", " a "}}}},
+		{`cn=\#a\"b\<c\>\\d`, DN{{{"cn", `#a"b<c>\d`}}}},
+		{"cn=#04024869,dc=com", DN{{{"cn", "Hi"}}, {{"dc", "com"}}}},
+		{"2.5.4.3=a", DN{{{"2.5.4.3", "a"}}}},
+		{"cn=a b  ", DN{{{"cn", "a b"}}}},
+	}
+
+	for _, tt := range tests {
+		dn, err := ParseDN(tt.s)
+		if err != nil {
+			t.Errorf("ParseDN(%q): %s", tt.s, err)
+			continue
+		}
+		if !reflect.DeepEqual(dn, tt.want) {
+			t.Errorf("ParseDN(%q) = %v, want %v", tt.s, dn, tt.want)
+		}
+	}
+}
+
+func TestParseDNErrors(t *testing.T) {
+	for _, s := range []string{
+		"cn",
+		"dc=com,",
+		"cn=a+",
+		"=a",
+		"1cn=a",
+		"c n=a",
+		"1..2=a",
+		`cn=a\`,
+		`cn=a\zz`,
+		`cn=a"b`,
+		"cn=a<b",
+		"cn=#zz",
+		"cn=#04024869x",
+	} {
+		if _, err := ParseDN(s); err == nil {
+			t.Errorf("ParseDN(%q): expected error", s)
+		}
+	}
+}
+
+func TestEscapeValue(t *testing.T) {
+	tests := []struct {
+		value string
+		want  string
+	}{
+		{"user01", "user01"},
+		{"Smith, John", `Smith\, John`},
+		{"#a b ", `\#a b\ `},
+		{" a#", `\ a#`},
+		{`a+b;c<d>e"f\g`, `a\+b\;c\<d\>e\"f\\g`},
+		{"a\x00b", `a\00b`},
+	}
+
+	for _, tt := range tests {
+		if got := EscapeValue(tt.value); got != tt.want {
+			t.Errorf("EscapeValue(%q) = %s, want %s", tt.value, got, tt.want)
+		}
+		// escaped value is parsed back to the same value
+		dn, err := ParseDN("cn=" + EscapeValue(tt.value))
+		if err != nil || len(dn) != 1 || dn[0][0].Value != tt.value {
+			t.Errorf("ParseDN of escaped %q = %v, %v", tt.value, dn, err)
+		}
+	}
+}
+
+func TestNormalizeEntry(t *testing.T) {
+	tests := []struct {
+		s    string
+		want string
+	}{
+		{"DC=test, dc=EXAMPLE,  dc=com", "dc=test,dc=example,dc=com"},
+		{"2.5.4.3=John,0.9.2342.19200300.100.1.25=com", "cn=john,dc=com"},
+		{"uid=b+CN=a,dc=com", "cn=a+uid=b,dc=com"},
+		{`cn=Smith\2C John,dc=com`, `cn=smith\, john,dc=com`},
+		{"Not A DN", "not a dn"},
+		{"", ""},
+	}
+
+	for _, tt := range tests {
+		if got := NormalizeEntry(tt.s); got != tt.want {
+			t.Errorf("NormalizeEntry(%q) = %s, want %s", tt.s, got, tt.want)
+		}
+	}
+}
+
+func TestIsSubordinate(t *testing.T) {
+	tests := []struct {
+		entry  string
+		base   string
+		orSelf bool
+		want   bool
+	}{
+		{"cn=a,ou=users,dc=example,dc=com", "OU=Users, dc=example,dc=com", false, true},
+		{"cn=a,ou=users,dc=example,dc=com", "dc=com", false, true},
+		{"cn=a,ou=users,dc=example,dc=com", "", false, true},
+		{"ou=users,dc=example,dc=com", "ou=users,dc=example,dc=com", false, false},
+		{"ou=users,dc=example,dc=com", "ou=users,dc=example,dc=com", true, true},
+		{"ou=users,dc=example,dc=com", "cn=a,ou=users,dc=example,dc=com", true, false},
+		{"cn=a,ou=groups,dc=example,dc=com", "ou=users,dc=example,dc=com", false, false},
+		{"cn=a,dc=example2,dc=com", "dc=example,dc=com", false, false},
+		{"cn", "dc=com", true, false},
+	}
+
+	for _, tt := range tests {
+		if got := IsSubordinate(tt.entry, tt.base, tt.orSelf); got != tt.want {
+			t.Errorf("IsSubordinate(%q, %q, %t) = %t, want %t", tt.entry, tt.base, tt.orSelf, got, tt.want)
+		}
+	}
+}
+
+func TestParentEntry(t *testing.T) {
+	tests := []struct {
+		entry string
+		want  string
+	}{
+		{"cn=a,OU=Users,dc=com", "ou=users,dc=com"},
+		{`cn=a\,b,dc=com`, "dc=com"},
+		{"dc=com", ""},
+		{"cn", ""},
+	}
+
+	for _, tt := range tests {
+		if got := ParentEntry(tt.entry); got != tt.want {
+			t.Errorf("ParentEntry(%q) = %s, want %s", tt.entry, got, tt.want)
+		}
+	}
+}
@@ -1,14 +1,34 @@
 package ldaputils
 
-import (
-	"regexp"
-	"strings"
-)
+import "fmt"
 
-// NormalizeEntry returns entry in lowercase without spaces after comma
-// e.g. DC=test, dc=EXAMPLE,  dc=com -> dc=test,dc=example,dc=com
-func NormalizeEntry(s string) string {
-	s = strings.ToLower(s)
-	re := regexp.MustCompile(`(,[\s]+)`)
-	return re.ReplaceAllString(s, ",")
+// NormalizeOUPath returns normalized path of organizational units 's' relative to base dn 'baseDN'
+// 's' may also be a dn within 'baseDN', e.g. OU=People, ou=EU,dc=example,dc=com -> ou=people,ou=eu
+func NormalizeOUPath(s, baseDN string) (string, error) {
+	path, err := ParseDN(s)
+	if err != nil {
+		return "", fmt.Errorf("wrong ou path '%s': %s", s, err)
+	}
+	base, err := ParseDN(baseDN)
+	if err != nil {
+		return "", fmt.Errorf("wrong base dn '%s': %s", baseDN, err)
+	}
+	if path.HasSuffix(base) {
+		path = path[:len(path)-len(base)]
+	}
+	if len(path) == 0 {
+		return "", fmt.Errorf("wrong ou path '%s': path is empty", s)
+	}
+	path = path.Normalize()
+	for _, rdn := range path {
+		if len(rdn) != 1 || rdn[0].Type != "ou" || len(rdn[0].Value) == 0 {
+			return "", fmt.Errorf("wrong ou path '%s': '%s' is not an ou", s, rdn)
+		}
+	}
+	return path.String(), nil
+}
+
+// OUPath returns path of top level ou 'name' relative to base dn, e.g. ou=users
+func OUPath(name string) string {
+	return "ou=" + EscapeValue(name)
 }
@@ -0,0 +1,54 @@
+package sshkey
+
+import (
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// supported OpenSSH public key types
+var keyTypes = map[string]struct{}{
+	"ssh-rsa":                            {},
+	"ssh-dss":                            {},
+	"ssh-ed25519":                        {},
+	"ecdsa-sha2-nistp256":                {},
+	"ecdsa-sha2-nistp384":                {},
+	"ecdsa-sha2-nistp521":                {},
+	"sk-ssh-ed25519@openssh.com":         {},
+	"sk-ecdsa-sha2-nistp256@openssh.com": {},
+}
+
+// ValidatePublicKey checks public key format: key type, base64 encoded key data and optional comment
+// e.g. ssh-ed25519 AAAAC3NzaC1lZDI1NTE5AAAAI... user@host
+func ValidatePublicKey(key string) error {
+	fields := strings.Fields(key)
+	if len(fields) < 2 {
+		return errors.New("key must contain key type and key data")
+	}
+
+	keyType := fields[0]
+	if _, ok := keyTypes[keyType]; !ok {
+		return fmt.Errorf("unsupported key type '%s'", keyType)
+	}
+
+	data, err := base64.StdEncoding.DecodeString(fields[1])
+	if err != nil {
+		return fmt.Errorf("error decoding key data: %s", err)
+	}
+
+	// key data starts with key type as length prefixed string
+	if len(data) < 4 {
+		return errors.New("key data is too short")
+	}
+	n := binary.BigEndian.Uint32(data)
+	if uint64(len(data)-4) <= uint64(n) {
+		return errors.New("key data is too short")
+	}
+	if string(data[4:4+n]) != keyType {
+		return fmt.Errorf("key data does not match key type '%s'", keyType)
+	}
+
+	return nil
+}